@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFirstTooLong(t *testing.T) {
+	short, long := "Av. Arequipa", strings.Repeat("ñ", 11)
+	if _, ok := firstTooLong(lengthRule{"street", &short, 10}); !ok {
+		t.Error("12 caracteres deben exceder el máximo de 10")
+	}
+	if f, ok := firstTooLong(lengthRule{"label", nil, 1}, lengthRule{"reference", &long, 11}); ok {
+		t.Errorf("11 runas no exceden 11 (campo %s)", f.field)
+	}
+}
+
+func TestCreateAddressRejectsLongStreet(t *testing.T) {
+	newMock(t)
+	setVar(t, &maxStreetLen, 10)
+	w := serve(http.MethodPost, "/api/v1/addresses", fmt.Sprintf(`{"user_id":3,"street":%q}`, strings.Repeat("a", 11)), nil)
+	expectStatus(t, w, http.StatusUnprocessableEntity)
+	if got := decode(t, w)["field"]; got != "street" {
+		t.Errorf("field = %v", got)
+	}
+}
+
+func TestSetDefaultAddress(t *testing.T) {
+	t.Run("queda una sola por defecto", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`SELECT user_id FROM addresses WHERE id=?`)).WithArgs(int64(21)).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(testCustomer.ID))
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT id FROM users WHERE id=? FOR UPDATE`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testCustomer.ID))
+		mock.ExpectExec(sqlText(`UPDATE addresses SET is_default=FALSE WHERE user_id=? AND is_default=TRUE AND id<>?`)).
+			WithArgs(testCustomer.ID, int64(21)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`UPDATE addresses SET is_default=TRUE WHERE id=? AND user_id=?`)).
+			WithArgs(int64(21), testCustomer.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPatch, "/api/v1/addresses/21/default", "", nil)
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["default_address_id"]; got != float64(21) {
+			t.Errorf("default_address_id = %v", got)
+		}
+	})
+	t.Run("dirección inexistente", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`SELECT user_id FROM addresses WHERE id=?`)).WithArgs(int64(99)).WillReturnError(sql.ErrNoRows)
+		w := serve(http.MethodPatch, "/api/v1/addresses/99/default", "", nil)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+}
+
+func TestReplaceDefaultAddress(t *testing.T) {
+	t.Run("reemplaza la anterior", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT id FROM users WHERE id=? FOR UPDATE`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testCustomer.ID))
+		mock.ExpectQuery(sqlText(`FROM addresses WHERE id=? AND user_id=?`)).WithArgs(int64(21), testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "label", "street", "reference", "lat", "lng"}).
+				AddRow(21, 3, "Oficina", "Jr. Lima 456", nil, nil, nil))
+		mock.ExpectExec(sqlText(`UPDATE addresses SET is_default=FALSE WHERE user_id=? AND is_default=TRUE AND id<>?`)).
+			WithArgs(testCustomer.ID, int64(21)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`UPDATE addresses SET is_default=TRUE WHERE id=? AND user_id=?`)).
+			WithArgs(int64(21), testCustomer.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPut, "/api/v1/addresses/defaults?user_id=3", `{"default_address_id":21}`, nil)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		address, _ := body["address"].(map[string]any)
+		if body["default_address_id"] != float64(21) || address["is_default"] != true {
+			t.Errorf("respuesta = %v", body)
+		}
+	})
+	t.Run("dirección de otro usuario", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT id FROM users WHERE id=? FOR UPDATE`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testCustomer.ID))
+		mock.ExpectQuery(sqlText(`FROM addresses WHERE id=? AND user_id=?`)).WithArgs(int64(30), testCustomer.ID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPut, "/api/v1/addresses/defaults?user_id=3", `{"default_address_id":30}`, nil)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+	t.Run("usuario inexistente", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT id FROM users WHERE id=? FOR UPDATE`)).WithArgs(int64(99)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPut, "/api/v1/addresses/defaults?user_id=99", `{"default_address_id":21}`, nil)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+	for _, tc := range []struct {
+		name, query, body string
+		want              int
+	}{
+		{"sin user_id", "", `{"default_address_id":21}`, http.StatusBadRequest},
+		{"user_id no numérico", "?user_id=x", `{"default_address_id":21}`, http.StatusBadRequest},
+		{"sin default_address_id", "?user_id=3", `{}`, http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newMock(t)
+			w := serve(http.MethodPut, "/api/v1/addresses/defaults"+tc.query, tc.body, nil)
+			expectStatus(t, w, tc.want)
+		})
+	}
+}
+
+func TestAutocompleteAddresses(t *testing.T) {
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`AND (a.label COLLATE utf8mb4_unicode_ci LIKE ? OR a.street COLLATE utf8mb4_unicode_ci LIKE ?)`)).
+		WithArgs("3", "%arequipa%", "%arequipa%", autocompleteDefaultLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "label", "street", "reference", "lat", "lng", "is_default", "last_used"}).
+			AddRow(20, 3, "Casa", "Av. Arequipa 123", nil, nil, nil, true, testNow))
+
+	w := serve(http.MethodGet, "/api/v1/addresses/autocomplete?user_id=3&q=AREQUIPA", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `"street":"Av. Arequipa 123"`) {
+		t.Errorf("cuerpo = %s", w.Body.String())
+	}
+
+	for _, q := range []string{"user_id=3", "q=casa", "user_id=3&q=casa&limit=0"} {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/addresses/autocomplete?"+q, "", nil)
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+}
+
+func TestAddressStats(t *testing.T) {
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`LEFT JOIN orders o ON o.address_id = a.id AND o.status = 'entregado'`)).WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "label", "street", "reference", "lat", "lng", "is_default", "delivered", "last_used"}).
+			AddRow(20, 3, "Casa", "Av. Arequipa 123", nil, nil, nil, true, 5, testNow).
+			AddRow(21, 3, "Oficina", "Jr. Lima 456", nil, nil, nil, false, 0, nil))
+
+	w := serve(http.MethodGet, "/api/v1/addresses/stats?user_id=3", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	body := w.Body.String()
+	// Las direcciones nunca usadas también aparecen, con last_used_at null
+	if !strings.Contains(body, `"delivered_count":5`) || !strings.Contains(body, `"delivered_count":0,"last_used_at":null`) {
+		t.Errorf("cuerpo = %s", body)
+	}
+
+	newMock(t)
+	w = serve(http.MethodGet, "/api/v1/addresses/stats", "", nil)
+	expectStatus(t, w, http.StatusBadRequest)
+}
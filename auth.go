@@ -0,0 +1,187 @@
+package main
+
+// Autenticación de quien llama a la API.
+// Acepta el token de POST /api/v1/login (Authorization: Bearer) o, como en el MVP,
+// HTTP Basic con email, phone o num_doc.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles (users.role_id)
+const (
+	roleAdmin    int8 = 1 // encargado
+	roleDriver   int8 = 2 // repartidor
+	roleCustomer int8 = 3 // cliente
+)
+
+// validRole indica si role_id es uno de los roles conocidos.
+func validRole(r int8) bool {
+	return r == roleAdmin || r == roleDriver || r == roleCustomer
+}
+
+var errInvalidCredentials = errors.New("usuario o contraseña inválidos")
+
+const authUserKey = "auth_user"
+
+// authenticate valida identificador + contraseña y devuelve el usuario activo.
+// Si el identificador coincide con más de un usuario (ej. num_doc duplicado de
+// datos antiguos) el login es ambiguo y se rechaza.
+func authenticate(ctx context.Context, username, password string) (User, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, role_id, full_name, phone, email, num_doc, password_hash, is_active, branch_id FROM users WHERE (email=? OR phone IN (?,?) OR num_doc=?) LIMIT 2`, username, username, phoneLookupKey(username), username)
+	if err != nil {
+		return User{}, err
+	}
+	defer rows.Close()
+	var u User
+	var stored string
+	matches := 0
+	for rows.Next() {
+		if err := rows.Scan(&u.ID, &u.RoleID, &u.FullName, &u.Phone, &u.Email, &u.NumDoc, &stored, &u.IsActive, &u.BranchID); err != nil {
+			return User{}, err
+		}
+		matches++
+	}
+	if err := rows.Err(); err != nil {
+		return User{}, err
+	}
+	if matches != 1 || !u.IsActive || !checkPassword(stored, password) {
+		return User{}, errInvalidCredentials
+	}
+	return u, nil
+}
+
+// optionalAuth identifica a quien llama si envía credenciales (401 si son inválidas)
+// y deja el usuario en el contexto. Sin credenciales la petición sigue anónima.
+func optionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			u, err := userFromToken(c.Request.Context(), strings.TrimSpace(raw))
+			if errors.Is(err, errInvalidToken) {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.Set(authUserKey, u)
+			c.Next()
+			return
+		}
+		username, password, ok := c.Request.BasicAuth()
+		if !ok {
+			c.Next()
+			return
+		}
+		u, err := authenticate(c.Request.Context(), username, password)
+		if errors.Is(err, errInvalidCredentials) {
+			c.Header("WWW-Authenticate", "Basic realm=API")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "usuario o contraseña inválidos"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(authUserKey, u)
+		c.Next()
+	}
+}
+
+// requireAuth exige un usuario autenticado (lo identifica optionalAuth).
+func requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := currentUser(c); !ok {
+			c.Header("WWW-Authenticate", "Basic realm=API")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "credenciales requeridas"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireRole se usa después de requireAuth; responde 403 si el rol no está permitido.
+func requireRole(roles ...int8) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, _ := currentUser(c)
+		for _, r := range roles {
+			if u.RoleID == r {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+	}
+}
+
+// currentUser devuelve el usuario autenticado (ok=false si la ruta no usa requireAuth).
+func currentUser(c *gin.Context) (User, bool) {
+	v, ok := c.Get(authUserKey)
+	if !ok {
+		return User{}, false
+	}
+	u, ok := v.(User)
+	return u, ok
+}
+
+// isSelfOrAdmin: el usuario autenticado es admin o es el dueño del recurso.
+func isSelfOrAdmin(c *gin.Context, userID int64) bool {
+	u, ok := currentUser(c)
+	return ok && (u.RoleID == roleAdmin || u.ID == userID)
+}
+
+// canSeeCustomerPrices: los precios negociados de un cliente son confidenciales;
+// solo los ve el propio cliente o un admin. Sin credenciales responde 401 y si no
+// corresponde 403; en ambos casos devuelve false.
+func canSeeCustomerPrices(c *gin.Context, customerID int64) bool {
+	if _, ok := currentUser(c); !ok {
+		c.Header("WWW-Authenticate", "Basic realm=API")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "credenciales requeridas"})
+		return false
+	}
+	if !isSelfOrAdmin(c, customerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return false
+	}
+	return true
+}
+
+// canSeeOrder aplica la regla de canActOnOrder a un pedido ya leído.
+func canSeeOrder(u User, customerID int64, driverID *int64) bool {
+	return u.RoleID == roleAdmin ||
+		(u.RoleID == roleCustomer && customerID == u.ID) ||
+		(u.RoleID == roleDriver && driverID != nil && *driverID == u.ID)
+}
+
+// canActOnOrder: un admin opera sobre cualquier pedido, un cliente solo sobre los
+// suyos y un repartidor solo sobre los que tiene asignados. Responde 404/403 y
+// devuelve false.
+func canActOnOrder(c *gin.Context, orderID string) bool {
+	u, _ := currentUser(c)
+	if u.RoleID == roleAdmin {
+		return true
+	}
+	var customerID int64
+	var driverID *int64
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT customer_id, assigned_driver_id FROM orders WHERE id=?`, orderID).Scan(&customerID, &driverID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !canSeeOrder(u, customerID, driverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return false
+	}
+	return true
+}
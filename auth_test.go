@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var loginColumns = []string{"id", "role_id", "full_name", "phone", "email", "num_doc", "password_hash", "is_active", "branch_id"}
+
+// expectLoginLookup espera la búsqueda de authenticate por username.
+func expectLoginLookup(mock sqlmock.Sqlmock, username string, u User, stored string) {
+	mock.ExpectQuery(sqlText(`FROM users WHERE (email=? OR phone IN (?,?) OR num_doc=?) LIMIT 2`)).
+		WithArgs(username, username, phoneLookupKey(username), username).
+		WillReturnRows(sqlmock.NewRows(loginColumns).AddRow(u.ID, u.RoleID, u.FullName, u.Phone, u.Email, u.NumDoc, stored, u.IsActive, u.BranchID))
+}
+
+// expectStartSession espera la sesión nueva de respondLogin; families son las
+// sesiones activas que devuelve activeSessions (de la más nueva a la más antigua).
+func expectStartSession(mock sqlmock.Sqlmock, userID int64, families ...string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlText(`SELECT id FROM users WHERE id=? FOR UPDATE`)).WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+	mock.ExpectExec(sqlText(`INSERT INTO refresh_tokens(user_id, token_hash, family_id, expires_at)`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	rows := sqlmock.NewRows([]string{"family_id", "started_at", "last_used_at", "expires_at"})
+	for _, f := range families {
+		rows.AddRow(f, testNow, testNow, testNow)
+	}
+	mock.ExpectQuery(sqlText(`FROM refresh_tokens rt`)).WithArgs(userID, userID).WillReturnRows(rows)
+}
+
+func TestBodyLogin(t *testing.T) {
+	stored, err := hashPassword("agua2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	formHeader := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+
+	t.Run("form", func(t *testing.T) {
+		mock := newMock(t)
+		expectLoginLookup(mock, "cliente@example.com", testCustomer, stored)
+		expectStartSession(mock, testCustomer.ID, "f1")
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/login", "username=cliente%40example.com&password=agua2024", formHeader)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["token"] == "" || body["refresh_token"] == "" || body["token_type"] != "Bearer" {
+			t.Errorf("cuerpo = %v", body)
+		}
+	})
+	t.Run("json con contraseña incorrecta", func(t *testing.T) {
+		mock := newMock(t)
+		expectLoginLookup(mock, "cliente@example.com", testCustomer, stored)
+
+		w := serve(http.MethodPost, "/api/v1/login", `{"username":"cliente@example.com","password":"otra1234"}`, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+	t.Run("form sin contraseña", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodPost, "/api/v1/login", "username=cliente", formHeader)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+	t.Run("otro content type", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodPost, "/api/v1/login", "username=cliente", http.Header{"Content-Type": {"text/plain"}})
+		expectStatus(t, w, http.StatusUnsupportedMediaType)
+	})
+}
+
+func TestRefreshToken(t *testing.T) {
+	const raw = "refresh-viejo"
+	expectToken := func(mock sqlmock.Sqlmock, expiresAt time.Time, revokedAt any) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`FROM refresh_tokens WHERE token_hash=? FOR UPDATE`)).WithArgs(hashRefreshToken(raw)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"}).
+				AddRow(5, testCustomer.ID, "fam", expiresAt, revokedAt))
+	}
+	const body = `{"refresh_token":"` + raw + `"}`
+
+	t.Run("rota el token", func(t *testing.T) {
+		setVar(t, &jwtTTL, 30*time.Minute)
+		mock := newMock(t)
+		expectToken(mock, time.Now().Add(time.Hour), nil)
+		mock.ExpectQuery(sqlText(`FROM users WHERE id=?`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "full_name", "phone", "email", "num_doc", "is_active", "branch_id"}).
+				AddRow(testCustomer.ID, roleCustomer, testCustomer.FullName, nil, nil, nil, true, nil))
+		mock.ExpectExec(sqlText(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE id=?`)).WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO refresh_tokens`)).WithArgs(testCustomer.ID, sqlmock.AnyArg(), "fam", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(6, 1))
+		mock.ExpectCommit()
+
+		before := time.Now()
+		w := serve(http.MethodPost, "/api/v1/token/refresh", body, nil)
+		expectStatus(t, w, http.StatusOK)
+		resp := decode(t, w)
+		if resp["refresh_token"] == raw || resp["refresh_token"] == "" {
+			t.Errorf("refresh_token = %v", resp["refresh_token"])
+		}
+		exp, err := time.Parse(time.RFC3339Nano, resp["expires_at"].(string))
+		if err != nil || exp.Before(before.Add(29*time.Minute)) || exp.After(before.Add(31*time.Minute)) {
+			t.Errorf("expires_at = %v con JWT_TTL_MINUTES=30", resp["expires_at"])
+		}
+	})
+	t.Run("reuso revoca la sesión", func(t *testing.T) {
+		mock := newMock(t)
+		expectToken(mock, time.Now().Add(time.Hour), testNow)
+		mock.ExpectExec(sqlText(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=? AND revoked_at IS NULL`)).WithArgs("fam").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/token/refresh", body, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+	t.Run("expirado", func(t *testing.T) {
+		mock := newMock(t)
+		expectToken(mock, time.Now().Add(-time.Minute), nil)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPost, "/api/v1/token/refresh", body, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+}
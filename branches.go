@@ -0,0 +1,136 @@
+package main
+
+// Sucursales: cada una tiene su catálogo y sus pedidos.
+// Los datos existentes quedan en la sucursal por defecto (id 1).
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultBranchID int64 = 1
+
+type Branch struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	IsActive bool   `json:"is_active"`
+}
+
+// branchFromRequest resuelve la sucursal de la petición:
+//  1. ?branch_id= explícito
+//  2. la sucursal del usuario autenticado (si tiene una)
+//  3. la sucursal por defecto
+//
+// Un usuario que no es admin y está ligado a una sucursal no puede pedir otra (403).
+// Responde el error y devuelve ok=false si la sucursal no es válida.
+func branchFromRequest(c *gin.Context) (int64, bool) {
+	u, authed := currentUser(c)
+	if v := c.Query("branch_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "branch_id debe ser numérico"})
+			return 0, false
+		}
+		if authed && u.RoleID != roleAdmin && u.BranchID != nil && *u.BranchID != id {
+			c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado para esa sucursal"})
+			return 0, false
+		}
+		return id, true
+	}
+	if authed && u.BranchID != nil {
+		return *u.BranchID, true
+	}
+	return defaultBranchID, true
+}
+
+// resolveBranch usa el branch_id del body si viene; si no, branchFromRequest.
+// Valida que la sucursal exista y esté activa.
+func resolveBranch(c *gin.Context, fromBody *int64) (int64, bool) {
+	var id int64
+	if fromBody != nil {
+		u, authed := currentUser(c)
+		if authed && u.RoleID != roleAdmin && u.BranchID != nil && *u.BranchID != *fromBody {
+			c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado para esa sucursal"})
+			return 0, false
+		}
+		id = *fromBody
+	} else {
+		var ok bool
+		if id, ok = branchFromRequest(c); !ok {
+			return 0, false
+		}
+	}
+	var n int
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT COUNT(1) FROM branches WHERE id=? AND is_active=TRUE`, id).Scan(&n); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "branch_id inválido"})
+		return 0, false
+	}
+	return id, true
+}
+
+// Tablas con branch_id que se leen y escriben por :id
+const (
+	branchTableOrders   = "orders"
+	branchTableProducts = "products"
+)
+
+// branchScoped restringe una ruta por :id a los registros de la sucursal de la
+// petición (branchFromRequest), igual que los listados. Un admin accede a todas.
+// Un registro de otra sucursal responde 404 con notFound, sin revelar que existe.
+// Con un :id no numérico sigue al handler, que responde como siempre.
+func branchScoped(table, notFound string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if u, ok := currentUser(c); ok && u.RoleID == roleAdmin {
+			c.Next()
+			return
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.Next()
+			return
+		}
+		branchID, ok := branchFromRequest(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+		var recordBranch int64
+		err = db.QueryRowContext(c.Request.Context(), `SELECT branch_id FROM `+table+` WHERE id=?`, id).Scan(&recordBranch)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && recordBranch != branchID) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": notFound})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+func listBranchesHandler(c *gin.Context) {
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT id, name, is_active FROM branches ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var list []Branch
+	for rows.Next() {
+		var b Branch
+		if err := rows.Scan(&b.ID, &b.Name, &b.IsActive); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, b)
+	}
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestBranchScoped(t *testing.T) {
+	t.Run("pedido de otra sucursal", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		expectBranchOf(mock, "orders", 10, 2)
+
+		w := serve(http.MethodGet, "/api/v1/orders/10", "", h)
+		expectStatus(t, w, http.StatusNotFound)
+		if got := decode(t, w)["error"]; got != "pedido no existe" {
+			t.Errorf("error = %v", got)
+		}
+	})
+	t.Run("producto inexistente", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`SELECT branch_id FROM products WHERE id=?`)).WithArgs(int64(7)).WillReturnError(sql.ErrNoRows)
+
+		w := serve(http.MethodGet, "/api/v1/products/7", "", nil)
+		expectStatus(t, w, http.StatusNotFound)
+		if got := decode(t, w)["error"]; got != "producto no encontrado" {
+			t.Errorf("error = %v", got)
+		}
+	})
+	t.Run("usuario ligado a otra sucursal", func(t *testing.T) {
+		mock := newMock(t)
+		driver := testDriver
+		driver.BranchID = int64Ptr(2)
+		h := authAs(t, mock, driver)
+
+		w := serve(http.MethodGet, "/api/v1/orders/10/history?branch_id=1", "", h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("sucursal del usuario", func(t *testing.T) {
+		mock := newMock(t)
+		driver := testDriver
+		driver.BranchID = int64Ptr(2)
+		h := authAs(t, mock, driver)
+		expectBranchOf(mock, "orders", 10, 2)
+		expectOrderOwner(mock, 10, testCustomer.ID, driver.ID)
+		mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM order_status_history h`)).WithArgs("10").WillReturnError(sql.ErrConnDone)
+
+		w := serve(http.MethodGet, "/api/v1/orders/10/history", "", h)
+		// Pasó el filtro de sucursal y llegó al handler
+		expectStatus(t, w, http.StatusInternalServerError)
+	})
+}
+
+func TestCreateProductRejectsInactiveBranch(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT COUNT(1) FROM branches WHERE id=? AND is_active=TRUE`)).WithArgs(int64(9)).
+		WillReturnRows(countRows(0))
+
+	w := serve(http.MethodPost, "/api/v1/products", `{"name":"Bidón","capacity_liters":20,"price":10,"branch_id":9}`, h)
+	expectStatus(t, w, http.StatusBadRequest)
+}
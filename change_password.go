@@ -0,0 +1,69 @@
+package main
+
+// Cambio de contraseña del usuario autenticado. Pide la contraseña actual, aplica
+// la misma política que el alta y la edición (validatePassword) y cierra las
+// sesiones abiertas (refresh tokens), igual que el restablecimiento.
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// PUT /api/v1/me/password
+func changePasswordHandler(c *gin.Context) {
+	var req ChangePasswordReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.CurrentPassword == "" {
+		respondInvalid(c, "current_password", "current_password requerido")
+		return
+	}
+	if failed := validatePassword(req.NewPassword); len(failed) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "contraseña no cumple la política", "field": "new_password", "rules": failed})
+		return
+	}
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	u, _ := currentUser(c)
+
+	err = withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		var stored string
+		if err := tx.QueryRow(`SELECT password_hash FROM users WHERE id=? AND is_active=TRUE FOR UPDATE`, u.ID).Scan(&stored); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "credenciales requeridas"})
+				return errResponded
+			}
+			return err
+		}
+		if !checkPassword(stored, req.CurrentPassword) {
+			respondInvalid(c, "current_password", "current_password incorrecta")
+			return errResponded
+		}
+		if _, err := tx.Exec(`UPDATE users SET password_hash=? WHERE id=?`, hash, u.ID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=? AND revoked_at IS NULL`, u.ID)
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestChangePassword(t *testing.T) {
+	stored, err := hashPassword("actual123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStored := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT password_hash FROM users WHERE id=? AND is_active=TRUE FOR UPDATE`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(stored))
+	}
+
+	t.Run("cambia y revoca las sesiones", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		expectStored(mock)
+		mock.ExpectExec(sqlText(`UPDATE users SET password_hash=? WHERE id=?`)).WithArgs(sqlmock.AnyArg(), testCustomer.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=?`)).WithArgs(testCustomer.ID).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPut, "/api/v1/me/password", `{"current_password":"actual123","new_password":"nueva2024"}`, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("contraseña actual incorrecta", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		expectStored(mock)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPut, "/api/v1/me/password", `{"current_password":"otra1234","new_password":"nueva2024"}`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if got := decode(t, w)["field"]; got != "current_password" {
+			t.Errorf("field = %v", got)
+		}
+	})
+	t.Run("nueva no cumple la política", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+
+		w := serve(http.MethodPut, "/api/v1/me/password", `{"current_password":"actual123","new_password":"corta"}`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if got := decode(t, w)["field"]; got != "new_password" {
+			t.Errorf("field = %v", got)
+		}
+	})
+	t.Run("sin autenticación", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodPut, "/api/v1/me/password", `{"current_password":"actual123","new_password":"nueva2024"}`, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+}
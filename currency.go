@@ -0,0 +1,99 @@
+package main
+
+// Montos formateados para mostrar ("S/ 1,234.50") con ?currency_format=true en
+// pedidos. Los campos numéricos se mantienen; los *_formatted van al lado.
+// CURRENCY_SYMBOL (por defecto "S/") y CURRENCY_LOCALE (es-PE por defecto; define
+// los separadores de miles y decimales) se configuran por entorno.
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Separadores de cada locale soportado
+type currencyLocale struct {
+	decimal string
+	group   string
+}
+
+var currencyLocales = map[string]currencyLocale{
+	"es-PE": {".", ","},
+	"en-US": {".", ","},
+	"es-ES": {",", "."},
+	"es-AR": {",", "."},
+	"es-CL": {",", "."},
+}
+
+var (
+	currencySymbol = "S/"
+	currencyLoc    = currencyLocales["es-PE"]
+)
+
+func loadCurrencyConfig() {
+	if v, ok := os.LookupEnv("CURRENCY_SYMBOL"); ok {
+		currencySymbol = strings.TrimSpace(v)
+	}
+	if v := os.Getenv("CURRENCY_LOCALE"); v != "" {
+		loc, ok := currencyLocales[v]
+		if !ok {
+			log.Fatal("CURRENCY_LOCALE no soportado: ", v)
+		}
+		currencyLoc = loc
+	}
+}
+
+// formatMoney formatea un monto en céntimos con el símbolo y los separadores
+// configurados: 123450 → "S/ 1,234.50", -950 → "-S/ 9.50".
+func formatMoney(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	units := strconv.FormatInt(cents/100, 10)
+	var b strings.Builder
+	for i, d := range units {
+		if i > 0 && (len(units)-i)%3 == 0 {
+			b.WriteString(currencyLoc.group)
+		}
+		b.WriteRune(d)
+	}
+	amount := b.String() + currencyLoc.decimal + strconv.FormatInt(100+cents%100, 10)[1:]
+	if currencySymbol == "" {
+		return sign + amount
+	}
+	return sign + currencySymbol + " " + amount
+}
+
+// currencyFormat lee ?currency_format= (false por defecto). Responde 400 si no
+// es un booleano y devuelve ok=false.
+func currencyFormat(c *gin.Context) (enabled, ok bool) {
+	v := c.Query("currency_format")
+	if v == "" {
+		return false, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency_format debe ser true o false"})
+		return false, false
+	}
+	return b, true
+}
+
+// setFormatted llena los campos *_formatted; el total se suma en céntimos igual
+// que setCents para que coincida con la suma de las partes.
+func (o *Order) setFormatted() {
+	sub, fee, tax := toCents(o.Subtotal), toCents(o.DeliveryFee), toCents(o.Tax)
+	fs, ff, ft, fT := formatMoney(sub), formatMoney(fee), formatMoney(tax), formatMoney(sub+fee+tax)
+	o.SubtotalFormatted, o.DeliveryFeeFormatted, o.TaxFormatted, o.TotalFormatted = &fs, &ff, &ft, &fT
+}
+
+func (it *OrderItem) setFormatted() {
+	unit := toCents(it.UnitPrice)
+	fu, fl := formatMoney(unit), formatMoney(unit*int64(it.Qty))
+	it.UnitPriceFormatted, it.LineTotalFormatted = &fu, &fl
+}
@@ -0,0 +1,237 @@
+package main
+
+// Precios en varias monedas. Cada producto y cada precio de cliente guarda su
+// moneda (por defecto la base, BASE_CURRENCY). Un producto puede tener además
+// precios directos por moneda (product_prices). Con ?currency= el catálogo se
+// devuelve en esa moneda: se usa el precio directo si existe y si no se convierte
+// con CURRENCY_RATES ("USD=0.27,EUR=0.25": unidades de cada moneda por 1 de la
+// base). Los pedidos se cobran siempre en la moneda base.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	baseCurrency  = "PEN"
+	currencyRates = map[string]float64{} // sin la base (vale 1)
+)
+
+func loadCurrencyRatesConfig() {
+	if v := os.Getenv("BASE_CURRENCY"); v != "" {
+		baseCurrency = strings.ToUpper(strings.TrimSpace(v))
+	}
+	v := os.Getenv("CURRENCY_RATES")
+	if v == "" {
+		return
+	}
+	for _, pair := range strings.Split(v, ",") {
+		code, rate, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if !ok || err != nil || r <= 0 {
+			log.Fatal("CURRENCY_RATES inválido: ", pair)
+		}
+		currencyRates[strings.ToUpper(strings.TrimSpace(code))] = r
+	}
+}
+
+func supportedCurrency(code string) bool {
+	_, ok := currencyRates[code]
+	return ok || code == baseCurrency
+}
+
+// convertPrice pasa un monto de una moneda a otra vía la base, redondeado a
+// céntimos. ok=false si alguna de las dos no tiene tasa configurada.
+func convertPrice(amount float64, from, to string) (float64, bool) {
+	if from == to {
+		return amount, true
+	}
+	rate := func(code string) (float64, bool) {
+		if code == baseCurrency {
+			return 1, true
+		}
+		r, ok := currencyRates[code]
+		return r, ok
+	}
+	rf, okFrom := rate(from)
+	rt, okTo := rate(to)
+	if !okFrom || !okTo {
+		return 0, false
+	}
+	return math.Round(amount/rf*rt*100) / 100, true
+}
+
+// currencyParam normaliza una moneda de un body: vacía → base. ok=false si no
+// está soportada (el llamador responde 422).
+func currencyParam(v string) (string, bool) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if v == "" {
+		return baseCurrency, true
+	}
+	return v, supportedCurrency(v)
+}
+
+// requestedCurrency lee ?currency= (la base si no viene). Responde 400 si la
+// moneda no está soportada y devuelve ok=false.
+func requestedCurrency(c *gin.Context) (string, bool) {
+	v := strings.ToUpper(strings.TrimSpace(c.Query("currency")))
+	if v == "" {
+		return baseCurrency, true
+	}
+	if supportedCurrency(v) {
+		return v, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "currency no soportada: " + v})
+	return "", false
+}
+
+// productCurrencyColumns acompaña a effectivePriceSQL: origen del precio y moneda
+// en la que está (la del precio del cliente o la del producto).
+const productCurrencyColumns = priceSourceSQL + `, CASE WHEN cpp.price IS NOT NULL THEN cpp.currency ELSE p.currency END`
+
+// localizeProducts pasa el precio de cada producto a la moneda pedida. Precio
+// base: el directo de product_prices o la conversión. Promoción por porcentaje:
+// se aplica sobre ese mismo precio base. Precio del cliente o promoción con precio
+// fijo: la conversión.
+func localizeProducts(ctx context.Context, items []Product, currency string) error {
+	ids := []any{currency}
+	for _, p := range items {
+		if p.Currency != currency {
+			ids = append(ids, p.ID)
+		}
+	}
+	// Todo ya está en esa moneda (el caso normal sin ?currency=)
+	if len(ids) == 1 {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT product_id, price FROM product_prices WHERE currency=? AND product_id IN (?`+strings.Repeat(",?", len(ids)-2)+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	direct := map[int64]float64{}
+	for rows.Next() {
+		var id int64
+		var price float64
+		if err := rows.Scan(&id, &price); err != nil {
+			return err
+		}
+		direct[id] = price
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range items {
+		p := &items[i]
+		if p.Currency == currency {
+			continue
+		}
+		price, ok := convertPrice(p.Price, p.Currency, currency)
+		if !ok {
+			return errors.New("sin tasa de cambio para " + p.Currency)
+		}
+		base, hasDirect := direct[p.ID]
+		if p.priceSource == priceSourceBase && hasDirect {
+			price = base
+		}
+		// Con promoción no hay precio del cliente: todo está en la moneda del producto
+		if promo := p.Promotion; promo != nil {
+			if !hasDirect {
+				base, _ = convertPrice(promo.BasePrice, p.Currency, currency)
+			} else if promo.DiscountPercent != nil {
+				price = math.Round(base*(100-*promo.DiscountPercent)) / 100
+			}
+			if promo.PromoPrice != nil {
+				pp, _ := convertPrice(*promo.PromoPrice, p.Currency, currency)
+				promo.PromoPrice = &pp
+			}
+			promo.BasePrice = base
+		}
+		p.Price, p.Currency = price, currency
+	}
+	return nil
+}
+
+// sortProductsByPrice reordena tras convertir: con productos en monedas distintas
+// el ORDER BY de la consulta no sirve. Desempate por id, en la misma dirección.
+func sortProductsByPrice(items []Product, desc bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if desc {
+			a, b = b, a
+		}
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+		return a.ID < b.ID
+	})
+}
+
+type ProductPriceReq struct {
+	Price float64 `json:"price"`
+}
+
+// PUT /api/v1/products/:id/prices/:currency (admin): precio directo del producto
+// en otra moneda; reemplaza la conversión en el catálogo.
+func upsertProductPriceHandler(c *gin.Context) {
+	currency := strings.ToUpper(c.Param("currency"))
+	if !supportedCurrency(currency) {
+		respondInvalid(c, "currency", "currency no soportada")
+		return
+	}
+	var req ProductPriceReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.Price <= 0 {
+		respondInvalid(c, "price", "price debe ser mayor a 0")
+		return
+	}
+	var productCurrency string
+	err := db.QueryRowContext(c.Request.Context(), `SELECT currency FROM products WHERE id=?`, c.Param("id")).Scan(&productCurrency)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "producto no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if currency == productCurrency {
+		respondInvalid(c, "currency", "es la moneda del producto: edite price del producto")
+		return
+	}
+	if _, err := db.ExecContext(c.Request.Context(), `
+        INSERT INTO product_prices(product_id, currency, price) VALUES (?,?,?)
+        ON DUPLICATE KEY UPDATE price=VALUES(price)`, c.Param("id"), currency, req.Price); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// DELETE /api/v1/products/:id/prices/:currency (admin): vuelve a la conversión.
+func deleteProductPriceHandler(c *gin.Context) {
+	res, err := db.ExecContext(c.Request.Context(), `DELETE FROM product_prices WHERE product_id=? AND currency=?`, c.Param("id"), strings.ToUpper(c.Param("currency")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "precio no encontrado"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestConvertPrice(t *testing.T) {
+	setVar(t, &currencyRates, map[string]float64{"USD": 0.27, "EUR": 0.25})
+	cases := []struct {
+		amount   float64
+		from, to string
+		want     float64
+		ok       bool
+	}{
+		{10, "PEN", "PEN", 10, true},
+		{10, "PEN", "USD", 2.7, true},
+		{2.7, "USD", "PEN", 10, true},
+		{2.7, "USD", "EUR", 2.5, true}, // vía la base
+		{10, "PEN", "GBP", 0, false},
+		{10, "GBP", "PEN", 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := convertPrice(tc.amount, tc.from, tc.to)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("convertPrice(%v, %s, %s) = %v, %v; quiero %v, %v", tc.amount, tc.from, tc.to, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestLocalizeProducts(t *testing.T) {
+	setVar(t, &currencyRates, map[string]float64{"USD": 0.27})
+	mock := newMock(t)
+
+	direct := catalogProduct(1, "Con precio directo", 12)
+	converted := catalogProduct(2, "Sin precio directo", 10)
+	custom := catalogProduct(3, "Precio del cliente", 8)
+	custom.priceSource = priceSourceCustom
+	percent := catalogProduct(4, "Promoción por porcentaje", 9)
+	percent.priceSource = priceSourcePromo
+	percent.Promotion = &AppliedPromotion{ID: 5, DiscountPercent: floatPtr(10), BasePrice: 10}
+	fixed := catalogProduct(5, "Promoción con precio fijo", 8)
+	fixed.priceSource = priceSourcePromo
+	fixed.Promotion = &AppliedPromotion{ID: 6, PromoPrice: floatPtr(8), BasePrice: 10}
+	already := catalogProduct(6, "Ya en dólares", 4)
+	already.Currency = "USD"
+	items := []Product{direct, converted, custom, percent, fixed, already}
+
+	// El que ya está en la moneda pedida no se consulta
+	mock.ExpectQuery(sqlText(`SELECT product_id, price FROM product_prices WHERE currency=? AND product_id IN (?,?,?,?,?)`)).
+		WithArgs("USD", int64(1), int64(2), int64(3), int64(4), int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "price"}).AddRow(1, 3.2).AddRow(3, 5.0).AddRow(4, 3.0))
+
+	if err := localizeProducts(t.Context(), items, "USD"); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		price float64
+		why   string
+	}{
+		{3.2, "precio directo"},
+		{2.7, "sin precio directo se convierte"},
+		{2.16, "el precio del cliente se convierte aunque haya directo"},
+		{2.7, "el porcentaje se aplica sobre el precio directo"},
+		{2.16, "el precio fijo de la promoción se convierte"},
+		{4, "ya estaba en la moneda"},
+	}
+	for i, w := range want {
+		if p := items[i]; p.Price != w.price || p.Currency != "USD" {
+			t.Errorf("%s: price = %v %s, quiero %v USD", w.why, p.Price, p.Currency, w.price)
+		}
+	}
+	if pr := items[3].Promotion; pr.BasePrice != 3 {
+		t.Errorf("base de la promoción por porcentaje = %v, quiero el directo 3", pr.BasePrice)
+	}
+	if pr := items[4].Promotion; pr.BasePrice != 2.7 || *pr.PromoPrice != 2.16 {
+		t.Errorf("promoción con precio fijo = %+v", pr)
+	}
+}
+
+func TestLocalizeProductsWithoutRate(t *testing.T) {
+	setVar(t, &currencyRates, map[string]float64{"USD": 0.27})
+	mock := newMock(t)
+	p := catalogProduct(1, "En euros", 10)
+	p.Currency = "EUR"
+	mock.ExpectQuery(sqlText(`FROM product_prices`)).WithArgs("USD", int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "price"}))
+	if err := localizeProducts(t.Context(), []Product{p}, "USD"); err == nil {
+		t.Error("sin tasa para EUR debe fallar")
+	}
+}
+
+func TestListProductsInCurrency(t *testing.T) {
+	setVar(t, &currencyRates, map[string]float64{"USD": 0.27})
+
+	t.Run("conversión de respaldo", func(t *testing.T) {
+		mock := newMock(t)
+		expectCatalog(mock, catalogRows(catalogProduct(1, "Bidón 20L", 10), catalogProduct(2, "Bidón 7L", 6)))
+		mock.ExpectQuery(sqlText(`FROM product_prices WHERE currency=?`)).WithArgs("USD", int64(1), int64(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "price"}).AddRow(1, 3.0))
+
+		w := serve(http.MethodGet, "/api/v1/products?currency=usd", "", nil)
+		expectStatus(t, w, http.StatusOK)
+		for _, want := range []string{`"id":1,"name":"Bidón 20L","price":3,"currency":"USD"`, `"id":2,"name":"Bidón 7L","price":1.62,"currency":"USD"`} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("falta %s en %s", want, w.Body.String())
+			}
+		}
+	})
+	t.Run("moneda no soportada", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/products?currency=GBP", "", nil)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+// Los pedidos se cobran en la moneda base aunque el precio del cliente esté en otra.
+func TestPriceOrderItemsInBaseCurrency(t *testing.T) {
+	setVar(t, &currencyRates, map[string]float64{"USD": 0.27})
+	mock := newMock(t)
+	p := baseProduct(2.7)
+	p.source, p.currency = priceSourceCustom, "USD"
+	expectPricing(mock, testCustomer.ID, 7, p)
+
+	items, subtotal, err := priceOrderItems(db, defaultBranchID, testCustomer.ID, []OrderItemReq{{ProductID: 7, Qty: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].UnitPrice != 10 || subtotal != 20 {
+		t.Errorf("unit_price = %v, subtotal = %v; quiero 10 y 20 en %s", items[0].UnitPrice, subtotal, baseCurrency)
+	}
+}
+
+func TestUpsertProductPrice(t *testing.T) {
+	setVar(t, &currencyRates, map[string]float64{"USD": 0.27})
+	t.Run("precio directo", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`SELECT currency FROM products WHERE id=?`)).WithArgs("7").
+			WillReturnRows(sqlmock.NewRows([]string{"currency"}).AddRow(baseCurrency))
+		mock.ExpectExec(sqlText(`INSERT INTO product_prices(product_id, currency, price)`)).WithArgs("7", "USD", 3.0).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		w := serve(http.MethodPut, "/api/v1/products/7/prices/usd", `{"price":3}`, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("la moneda del producto", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`SELECT currency FROM products WHERE id=?`)).WithArgs("7").
+			WillReturnRows(sqlmock.NewRows([]string{"currency"}).AddRow("USD"))
+		w := serve(http.MethodPut, "/api/v1/products/7/prices/USD", `{"price":3}`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+	t.Run("moneda no soportada", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPut, "/api/v1/products/7/prices/GBP", `{"price":3}`, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestDeleteProductPrice(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectExec(sqlText(`DELETE FROM product_prices WHERE product_id=? AND currency=?`)).WithArgs("7", "USD").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	w := serve(http.MethodDelete, "/api/v1/products/7/prices/usd", "", h)
+	expectStatus(t, w, http.StatusNotFound)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFormatMoney(t *testing.T) {
+	cases := []struct {
+		symbol string
+		loc    string
+		cents  int64
+		want   string
+	}{
+		{"S/", "es-PE", 123450, "S/ 1,234.50"},
+		{"S/", "es-PE", -950, "-S/ 9.50"},
+		{"S/", "es-PE", 5, "S/ 0.05"},
+		{"S/", "es-PE", 100000000, "S/ 1,000,000.00"},
+		{"€", "es-ES", 123450, "€ 1.234,50"},
+		{"", "en-US", 99900, "999.00"},
+	}
+	for _, tc := range cases {
+		setVar(t, &currencySymbol, tc.symbol)
+		setVar(t, &currencyLoc, currencyLocales[tc.loc])
+		if got := formatMoney(tc.cents); got != tc.want {
+			t.Errorf("formatMoney(%d) con %s = %q, quiero %q", tc.cents, tc.loc, got, tc.want)
+		}
+	}
+}
+
+func TestGetOrderCurrencyFormat(t *testing.T) {
+	t.Run("montos formateados", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectGetOrder(mock, sampleOrder(10))
+
+		w := serve(http.MethodGet, "/api/v1/orders/10?currency_format=true", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		// Los numéricos se mantienen; los *_formatted van al lado
+		if body["total"] != float64(25) || body["total_formatted"] != "S/ 25.00" || body["delivery_fee_formatted"] != "S/ 5.00" {
+			t.Errorf("pedido = %v", body)
+		}
+		items, _ := body["items"].([]any)
+		if len(items) != 1 || items[0].(map[string]any)["line_total_formatted"] != "S/ 20.00" {
+			t.Errorf("ítems = %v", items)
+		}
+	})
+	t.Run("sin el parámetro no se agregan", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectGetOrder(mock, sampleOrder(10))
+
+		w := serve(http.MethodGet, "/api/v1/orders/10", "", h)
+		expectStatus(t, w, http.StatusOK)
+		if _, ok := decode(t, w)["total_formatted"]; ok {
+			t.Error("total_formatted sin ?currency_format=true")
+		}
+	})
+	t.Run("valor inválido", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/orders/10?currency_format=si", "", authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
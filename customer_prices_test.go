@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDeleteCustomerPriceByID(t *testing.T) {
+	t.Run("existente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectExec(sqlText(`DELETE FROM customer_product_prices WHERE id=?`)).WithArgs(int64(15)).WillReturnResult(sqlmock.NewResult(0, 1))
+		w := serve(http.MethodDelete, "/api/v1/customer_prices/15", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("inexistente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectExec(sqlText(`DELETE FROM customer_product_prices WHERE id=?`)).WithArgs(int64(99)).WillReturnResult(sqlmock.NewResult(0, 0))
+		w := serve(http.MethodDelete, "/api/v1/customer_prices/99", "", h)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+	t.Run("id no numérico", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodDelete, "/api/v1/customer_prices/abc", "", authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodDelete, "/api/v1/customer_prices/15", "", authAs(t, mock, testCustomer))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+func TestListCustomerPricesVisibility(t *testing.T) {
+	priceRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "customer_id", "product_id", "price", "currency", "is_active"}).
+			AddRow(1, 3, 10, 4.5, "PEN", true)
+	}
+	t.Run("el propio cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		mock.ExpectQuery(sqlText(`FROM customer_product_prices`)).WithArgs("3").WillReturnRows(priceRows())
+		w := serve(http.MethodGet, "/api/v1/customer_prices?customer_id=3", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("admin", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`FROM customer_product_prices`)).WithArgs("3").WillReturnRows(priceRows())
+		w := serve(http.MethodGet, "/api/v1/customer_prices?customer_id=3", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("otro cliente", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/customer_prices?customer_id=3", "", authAs(t, mock, otherUser))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/customer_prices?customer_id=3", "", authAs(t, mock, testDriver))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("sin credenciales", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/customer_prices?customer_id=3", "", nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+}
+
+func TestUpsertCustomerPriceUpdatesExistingRow(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT COUNT(1) FROM products WHERE id=?`)).WithArgs(int64(10)).WillReturnRows(countRows(1))
+	mock.ExpectQuery(sqlText(`SELECT COUNT(1) FROM users WHERE id=?`)).WithArgs(int64(3)).WillReturnRows(countRows(1))
+	// Con la clave única un segundo POST del mismo par actualiza la fila (2 = fila actualizada)
+	mock.ExpectExec(sqlText(`ON DUPLICATE KEY UPDATE price=VALUES(price), currency=VALUES(currency), is_active=VALUES(is_active)`)).
+		WithArgs(int64(3), int64(10), 4.5, baseCurrency, true).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	w := serve(http.MethodPost, "/api/v1/customer_prices", `{"customer_id":3,"product_id":10,"price":4.5}`, h)
+	expectStatus(t, w, http.StatusOK)
+}
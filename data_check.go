@@ -0,0 +1,120 @@
+package main
+
+// Chequeo de consistencia de fechas de pedidos (solo admin). delivered_at solo lo
+// escribe la transición a entregado con NOW() de la BD, pero una edición manual o
+// un dato importado puede dejarlo en el futuro o antes de la creación, y entonces
+// las duraciones de los reportes salen negativas. Las fechas se comparan con el
+// reloj de la BD para no depender del reloj de este servidor. También reporta
+// precios de cliente duplicados por (customer_id, product_id), que solo pueden
+// existir si falta la clave única de la migración 012.
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Problemas que detecta GET /api/v1/admin/data-check
+const (
+	checkDeliveredInFuture      = "delivered_at_in_future"
+	checkDeliveredBeforeCreated = "delivered_at_before_created_at"
+	checkDeliveredBeforeTransit = "delivered_at_before_transit_started_at"
+)
+
+type DataIssue struct {
+	OrderID          int64      `json:"order_id"`
+	Issue            string     `json:"issue"`
+	Status           string     `json:"status"`
+	CreatedAt        *time.Time `json:"created_at"`
+	TransitStartedAt *time.Time `json:"transit_started_at,omitempty"`
+	DeliveredAt      *time.Time `json:"delivered_at"`
+}
+
+// DuplicateCustomerPrice es un par cliente/producto con más de un precio. KeptID es
+// la fila activa que usa el cálculo de precios (la más reciente; ausente si
+// ninguna está activa).
+type DuplicateCustomerPrice struct {
+	CustomerID int64  `json:"customer_id"`
+	ProductID  int64  `json:"product_id"`
+	Rows       int    `json:"rows"`
+	KeptID     *int64 `json:"kept_id,omitempty"`
+}
+
+func duplicateCustomerPrices(ctx context.Context) ([]DuplicateCustomerPrice, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT customer_id, product_id, COUNT(*), MAX(CASE WHEN is_active THEN id END)
+        FROM customer_product_prices
+        GROUP BY customer_id, product_id
+        HAVING COUNT(*) > 1
+        ORDER BY customer_id, product_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []DuplicateCustomerPrice{}
+	for rows.Next() {
+		var d DuplicateCustomerPrice
+		if err := rows.Scan(&d.CustomerID, &d.ProductID, &d.Rows, &d.KeptID); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// GET /api/v1/admin/data-check (admin)
+// Un pedido puede aparecer más de una vez si tiene varios problemas.
+func dataCheckHandler(c *gin.Context) {
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT id, status, created_at, transit_started_at, delivered_at,
+               delivered_at > NOW(), COALESCE(delivered_at < created_at, FALSE),
+               COALESCE(delivered_at < transit_started_at, FALSE)
+        FROM orders
+        WHERE delivered_at IS NOT NULL
+          AND (delivered_at > NOW() OR delivered_at < created_at OR delivered_at < transit_started_at)
+        ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	issues := []DataIssue{}
+	for rows.Next() {
+		var d DataIssue
+		var future, beforeCreated, beforeTransit bool
+		if err := rows.Scan(&d.OrderID, &d.Status, &d.CreatedAt, &d.TransitStartedAt, &d.DeliveredAt, &future, &beforeCreated, &beforeTransit); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		checks := []struct {
+			issue string
+			found bool
+		}{
+			{checkDeliveredInFuture, future},
+			{checkDeliveredBeforeCreated, beforeCreated},
+			{checkDeliveredBeforeTransit, beforeTransit},
+		}
+		for _, ch := range checks {
+			if ch.found {
+				d.Issue = ch.issue
+				issues = append(issues, d)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	dupPrices, err := duplicateCustomerPrices(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ok":                        len(issues) == 0 && len(dupPrices) == 0,
+		"issues":                    issues,
+		"duplicate_customer_prices": dupPrices,
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var dataCheckColumns = []string{"id", "status", "created_at", "transit_started_at", "delivered_at", "future", "before_created", "before_transit"}
+
+var duplicatePriceColumns = []string{"customer_id", "product_id", "rows", "kept_id"}
+
+// expectDuplicatePrices espera la búsqueda de precios de cliente duplicados.
+func expectDuplicatePrices(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(sqlText(`HAVING COUNT(*) > 1`)).WillReturnRows(rows)
+}
+
+func TestDataCheckDeliveredAt(t *testing.T) {
+	t.Run("sin problemas", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`WHERE delivered_at IS NOT NULL`)).WillReturnRows(sqlmock.NewRows(dataCheckColumns))
+		expectDuplicatePrices(mock, sqlmock.NewRows(duplicatePriceColumns))
+
+		w := serve(http.MethodGet, "/api/v1/admin/data-check", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if issues, _ := body["issues"].([]any); body["ok"] != true || issues == nil || len(issues) != 0 {
+			t.Errorf("chequeo = %v", body)
+		}
+	})
+	t.Run("un pedido por cada problema", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		before := testNow.Add(-time.Hour)
+		mock.ExpectQuery(sqlText(`WHERE delivered_at IS NOT NULL`)).WillReturnRows(sqlmock.NewRows(dataCheckColumns).
+			AddRow(10, statusEntregado, testNow, nil, testNow.Add(48*time.Hour), true, false, false).
+			AddRow(11, statusEntregado, testNow, testNow, before, false, true, true))
+		expectDuplicatePrices(mock, sqlmock.NewRows(duplicatePriceColumns))
+
+		w := serve(http.MethodGet, "/api/v1/admin/data-check", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		issues, _ := body["issues"].([]any)
+		if body["ok"] != false || len(issues) != 3 {
+			t.Fatalf("chequeo = %v", body)
+		}
+		want := []struct {
+			id    float64
+			issue string
+		}{
+			{10, checkDeliveredInFuture},
+			{11, checkDeliveredBeforeCreated},
+			{11, checkDeliveredBeforeTransit},
+		}
+		for i, w := range want {
+			if got := issues[i].(map[string]any); got["order_id"] != w.id || got["issue"] != w.issue {
+				t.Errorf("problema %d = %v, quiero %v", i, got, w)
+			}
+		}
+	})
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/admin/data-check", "", authAs(t, mock, testDriver))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+func TestDataCheckDuplicateCustomerPrices(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`WHERE delivered_at IS NOT NULL`)).WillReturnRows(sqlmock.NewRows(dataCheckColumns))
+	expectDuplicatePrices(mock, sqlmock.NewRows(duplicatePriceColumns).
+		AddRow(3, 10, 2, 41).
+		AddRow(4, 10, 3, nil))
+
+	w := serve(http.MethodGet, "/api/v1/admin/data-check", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := w.Body.String()
+	// Sin fila activa no hay kept_id: ninguna se usa para el precio
+	for _, want := range []string{
+		`"ok":false`,
+		`{"customer_id":3,"product_id":10,"rows":2,"kept_id":41}`,
+		`{"customer_id":4,"product_id":10,"rows":3}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("falta %s en %s", want, body)
+		}
+	}
+}
+
+// Con duplicados el JOIN une una sola fila (la activa más reciente) para no
+// multiplicar productos ni líneas de pedido.
+func TestCustomerPriceJoinPicksOneRow(t *testing.T) {
+	if !strings.Contains(customerPriceJoin, "cpp.id = (") || !strings.Contains(customerPriceJoin, "SELECT MAX(cpp2.id)") ||
+		!strings.Contains(customerPriceJoin, "cpp2.is_active = TRUE") {
+		t.Errorf("customerPriceJoin = %s", customerPriceJoin)
+	}
+}
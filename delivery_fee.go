@@ -0,0 +1,119 @@
+package main
+
+// Tarifa de delivery por distancia.
+// Los tramos viven en la tabla delivery_fee_tiers (max_km, fee), se cargan al iniciar
+// y se pueden recargar con POST /api/v1/admin/delivery-fee-tiers/reload.
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeliveryFeeTier struct {
+	MaxKm float64 `json:"max_km"`
+	Fee   float64 `json:"fee"`
+}
+
+var (
+	feeMu    sync.RWMutex
+	feeTiers []DeliveryFeeTier // ordenados por max_km ascendente
+	// feeFallback se cobra más allá del último tramo; nil = usar la tarifa del último tramo
+	feeFallback *float64
+)
+
+// Coordenadas del almacén, punto de partida para calcular la distancia
+var warehouseLat, warehouseLng *float64
+
+func loadDeliveryConfig() {
+	warehouseLat = envFloatPtr("WAREHOUSE_LAT")
+	warehouseLng = envFloatPtr("WAREHOUSE_LNG")
+	feeFallback = envFloatPtr("DELIVERY_FEE_FALLBACK")
+}
+
+// loadDeliveryFeeTiers reemplaza los tramos en memoria con los de la BD.
+func loadDeliveryFeeTiers() ([]DeliveryFeeTier, error) {
+	rows, err := db.Query(`SELECT max_km, fee FROM delivery_fee_tiers ORDER BY max_km`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tiers []DeliveryFeeTier
+	for rows.Next() {
+		var t DeliveryFeeTier
+		if err := rows.Scan(&t.MaxKm, &t.Fee); err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	feeMu.Lock()
+	feeTiers = tiers
+	feeMu.Unlock()
+	return tiers, nil
+}
+
+// computeDeliveryFee elige el primer tramo cuyo max_km cubre la distancia.
+// Más allá del último tramo cobra fallback (o la tarifa del último tramo si es nil).
+// Sin tramos configurados el delivery es gratis.
+func computeDeliveryFee(tiers []DeliveryFeeTier, fallback *float64, km float64) float64 {
+	for _, t := range tiers {
+		if km <= t.MaxKm {
+			return t.Fee
+		}
+	}
+	if fallback != nil {
+		return *fallback
+	}
+	if len(tiers) > 0 {
+		return tiers[len(tiers)-1].Fee
+	}
+	return 0
+}
+
+// deliveryFeeFor calcula la tarifa para unas coordenadas de entrega.
+// Si faltan coordenadas (dirección o almacén) la tarifa es 0, como en el MVP original.
+func deliveryFeeFor(lat, lng *float64) float64 {
+	if lat == nil || lng == nil || warehouseLat == nil || warehouseLng == nil {
+		return 0
+	}
+	km := haversineKm(*warehouseLat, *warehouseLng, *lat, *lng)
+	feeMu.RLock()
+	defer feeMu.RUnlock()
+	return computeDeliveryFee(feeTiers, feeFallback, km)
+}
+
+// haversineKm devuelve la distancia en km entre dos puntos (lat/lng en grados).
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func envFloatPtr(key string) *float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// ADMIN: recargar tramos sin reiniciar
+func reloadDeliveryFeeTiersHandler(c *gin.Context) {
+	tiers, err := loadDeliveryFeeTiers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "tiers": tiers, "fallback": feeFallback})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func floatPtr(v float64) *float64 { return &v }
+
+func TestComputeDeliveryFee(t *testing.T) {
+	tiers := []DeliveryFeeTier{{MaxKm: 3, Fee: 0}, {MaxKm: 8, Fee: 5}, {MaxKm: 15, Fee: 10}}
+	cases := []struct {
+		name     string
+		tiers    []DeliveryFeeTier
+		fallback *float64
+		km       float64
+		want     float64
+	}{
+		{"primer tramo", tiers, nil, 2.5, 0},
+		{"límite del tramo", tiers, nil, 8, 5},
+		{"tramo intermedio", tiers, nil, 12, 10},
+		{"fuera de tramos sin fallback", tiers, nil, 20, 10},
+		{"fuera de tramos con fallback", tiers, floatPtr(25), 20, 25},
+		{"sin tramos", nil, nil, 4, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := computeDeliveryFee(tc.tiers, tc.fallback, tc.km); got != tc.want {
+				t.Errorf("computeDeliveryFee(%v km) = %v, quiero %v", tc.km, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReloadDeliveryFeeTiers(t *testing.T) {
+	mock := newMock(t)
+	setVar(t, &feeTiers, nil)
+	setVar(t, &warehouseLat, floatPtr(-12.0464))
+	setVar(t, &warehouseLng, floatPtr(-77.0428))
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT max_km, fee FROM delivery_fee_tiers ORDER BY max_km`)).
+		WillReturnRows(sqlmock.NewRows([]string{"max_km", "fee"}).AddRow(5, 3.5).AddRow(10, 7))
+
+	w := serve(http.MethodPost, "/api/v1/admin/delivery-fee-tiers/reload", "", h)
+	expectStatus(t, w, http.StatusOK)
+	if got := deliveryFeeFor(floatPtr(-12.0464), floatPtr(-77.0428)); got != 3.5 {
+		t.Errorf("tarifa en el almacén = %v, quiero 3.5 (tramos recargados)", got)
+	}
+	if got := deliveryFeeFor(nil, nil); got != 0 {
+		t.Errorf("tarifa sin coordenadas = %v, quiero 0", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+// Cola de despacho: pedidos por_atender listos para asignar, con la dirección y
+// un resumen de ítems para no abrir cada pedido. Orden: prioridad alta primero,
+// luego hora programada (scheduled_at o inicio de la franja; sin hora al final)
+// y luego antigüedad.
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DispatchItem struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	Qty       int    `json:"qty"`
+}
+
+type DispatchOrder struct {
+	ID                  int64          `json:"id"`
+	CustomerID          int64          `json:"customer_id"`
+	CustomerName        string         `json:"customer_name"`
+	Priority            string         `json:"priority"`
+	ScheduledAt         *time.Time     `json:"scheduled_at"`
+	DeliveryWindowStart *time.Time     `json:"delivery_window_start,omitempty"`
+	DeliveryWindowEnd   *time.Time     `json:"delivery_window_end,omitempty"`
+	CreatedAt           *time.Time     `json:"created_at"`
+	Total               float64        `json:"total"`
+	Notes               *string        `json:"notes,omitempty"`
+	Address             Address        `json:"address"`
+	Items               []DispatchItem `json:"items"`
+	TotalQty            int            `json:"total_qty"`
+}
+
+// GET /api/v1/orders/unassigned (admin; paginado, opcional ?branch_id=)
+func unassignedOrdersHandler(c *gin.Context) {
+	page, pageSize, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+	branchID, ok := branchFromRequest(c)
+	if !ok {
+		return
+	}
+	var total int
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT COUNT(*) FROM orders WHERE status='`+statusPorAtender+`' AND branch_id=?`, branchID).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT o.id, o.customer_id, u.full_name, o.priority, o.scheduled_at, o.delivery_window_start, o.delivery_window_end,
+               o.created_at, o.total, o.notes,
+               a.id, a.user_id, a.label, a.street, a.reference, a.lat, a.lng, a.is_default
+        FROM orders o
+        JOIN users u ON u.id = o.customer_id
+        JOIN addresses a ON a.id = o.address_id
+        WHERE o.status='`+statusPorAtender+`' AND o.branch_id=?
+        ORDER BY o.priority='`+priorityHigh+`' DESC,
+                 COALESCE(o.scheduled_at, o.delivery_window_start) IS NULL,
+                 COALESCE(o.scheduled_at, o.delivery_window_start),
+                 o.created_at, o.id
+        LIMIT ? OFFSET ?`, branchID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var out []DispatchOrder
+	byID := map[int64]int{}
+	for rows.Next() {
+		var o DispatchOrder
+		a := &o.Address
+		if err := rows.Scan(&o.ID, &o.CustomerID, &o.CustomerName, &o.Priority, &o.ScheduledAt, &o.DeliveryWindowStart, &o.DeliveryWindowEnd,
+			&o.CreatedAt, &o.Total, &o.Notes,
+			&a.ID, &a.UserID, &a.Label, &a.Street, &a.Reference, &a.Lat, &a.Lng, &a.IsDefault); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		o.Items = []DispatchItem{}
+		byID[o.ID] = len(out)
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(out) > 0 {
+		ids := make([]any, 0, len(out))
+		for _, o := range out {
+			ids = append(ids, o.ID)
+		}
+		itemRows, err := db.QueryContext(c.Request.Context(), `
+            SELECT oi.order_id, oi.product_id, p.name, oi.qty
+            FROM order_items oi JOIN products p ON p.id = oi.product_id
+            WHERE oi.order_id IN (?`+strings.Repeat(",?", len(ids)-1)+`)
+            ORDER BY oi.order_id, oi.id`, ids...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer itemRows.Close()
+		for itemRows.Next() {
+			var orderID int64
+			var it DispatchItem
+			if err := itemRows.Scan(&orderID, &it.ProductID, &it.Name, &it.Qty); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			o := &out[byID[orderID]]
+			o.Items = append(o.Items, it)
+			o.TotalQty += it.Qty
+		}
+		if err := itemRows.Err(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	setLinkHeader(c, page, pageSize, total)
+	c.JSON(http.StatusOK, newPaginated(out, page, pageSize, total))
+}
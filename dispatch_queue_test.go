@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUnassignedOrders(t *testing.T) {
+	t.Run("con resumen de ítems", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM orders WHERE status='por_atender' AND branch_id=?`)).WithArgs(defaultBranchID).
+			WillReturnRows(countRows(2))
+		mock.ExpectQuery(sqlText(`ORDER BY o.priority='high' DESC`)).WithArgs(defaultBranchID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "full_name", "priority", "scheduled_at", "window_start", "window_end", "created_at", "total", "notes",
+				"a_id", "a_user_id", "label", "street", "reference", "lat", "lng", "is_default"}).
+				AddRow(51, 3, "Cliente", priorityHigh, nil, nil, nil, testNow, 25.0, nil, 20, 3, "Casa", "Av. Arequipa 123", nil, nil, nil, true).
+				AddRow(50, 4, "Otro cliente", priorityNormal, testNow, nil, nil, testNow, 10.0, "Tocar timbre", 22, 4, "Casa", "Jr. Lima 456", nil, nil, nil, true))
+		mock.ExpectQuery(sqlText(`WHERE oi.order_id IN (?,?)`)).WithArgs(int64(51), int64(50)).
+			WillReturnRows(sqlmock.NewRows([]string{"order_id", "product_id", "name", "qty"}).
+				AddRow(51, 7, "Bidón 20L", 2).
+				AddRow(51, 8, "Bidón 7L", 3).
+				AddRow(50, 7, "Bidón 20L", 1))
+
+		w := serve(http.MethodGet, "/api/v1/orders/unassigned", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		data, _ := body["data"].([]any)
+		if body["total"] != float64(2) || len(data) != 2 {
+			t.Fatalf("respuesta = %v", body)
+		}
+		first := data[0].(map[string]any)
+		items, _ := first["items"].([]any)
+		address, _ := first["address"].(map[string]any)
+		if first["id"] != float64(51) || first["total_qty"] != float64(5) || len(items) != 2 || address["street"] != "Av. Arequipa 123" {
+			t.Errorf("primer pedido = %v", first)
+		}
+	})
+	t.Run("cola vacía", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM orders`)).WithArgs(defaultBranchID).WillReturnRows(countRows(0))
+		mock.ExpectQuery(sqlText(`FROM orders o`)).WithArgs(defaultBranchID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		w := serve(http.MethodGet, "/api/v1/orders/unassigned", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/orders/unassigned", "", authAs(t, mock, testDriver))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
@@ -0,0 +1,409 @@
+package main
+
+// Endpoints para repartidores (dashboards de turno).
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resumen del día para el repartidor
+type DriverTodayStats struct {
+	DriverID         int64   `json:"driver_id"`
+	Date             string  `json:"date"`     // YYYY-MM-DD en appLocation
+	Timezone         string  `json:"timezone"` // ej. America/Lima
+	Assigned         int     `json:"assigned"`
+	Delivered        int     `json:"delivered"`
+	Cancelled        int     `json:"cancelled"`
+	DeliveredRevenue float64 `json:"delivered_revenue"`
+}
+
+// dayBounds devuelve [inicio, fin) del día calendario de now en loc.
+// Se usa AddDate y no +24h para que los días con cambio de horario queden bien.
+func dayBounds(now time.Time, loc *time.Location) (time.Time, time.Time) {
+	t := now.In(loc)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// GET /api/v1/drivers/:id/today (el propio repartidor o un admin)
+func driverTodayHandler(c *gin.Context) {
+	driverID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	if !isSelfOrAdmin(c, driverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return
+	}
+
+	start, end := dayBounds(time.Now(), appLocation)
+	st := DriverTodayStats{DriverID: driverID, Date: start.Format("2006-01-02"), Timezone: appLocation.String()}
+
+	// Entregas: por delivered_at del pedido
+	if err := db.QueryRowContext(c.Request.Context(), `
+        SELECT COUNT(*), COALESCE(SUM(total), 0)
+        FROM orders
+        WHERE assigned_driver_id=? AND status='entregado' AND delivered_at >= ? AND delivered_at < ?`,
+		driverID, start, end).Scan(&st.Delivered, &st.DeliveredRevenue); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Asignaciones y cancelaciones: por fecha del cambio en el historial
+	if err := db.QueryRowContext(c.Request.Context(), `
+        SELECT
+          COUNT(DISTINCT CASE WHEN h.new_status='asignado' THEN h.order_id END),
+          COUNT(DISTINCT CASE WHEN h.new_status='cancelado' THEN h.order_id END)
+        FROM order_status_history h
+        JOIN orders o ON o.id=h.order_id
+        WHERE o.assigned_driver_id=? AND h.changed_at >= ? AND h.changed_at < ?`,
+		driverID, start, end).Scan(&st.Assigned, &st.Cancelled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// Parada de la ruta del repartidor
+type RouteStop struct {
+	OrderID      int64    `json:"order_id"`
+	Status       string   `json:"status"`
+	AddressID    int64    `json:"address_id"`
+	Street       string   `json:"street"`
+	Lat          *float64 `json:"lat,omitempty"`
+	Lng          *float64 `json:"lng,omitempty"`
+	LegKm        float64  `json:"leg_km"`
+	CumulativeKm float64  `json:"cumulative_km"`
+	// Cuándo espera el cliente la entrega (hora exacta o franja)
+	ScheduledAt         *time.Time `json:"scheduled_at,omitempty"`
+	DeliveryWindowStart *time.Time `json:"delivery_window_start,omitempty"`
+	DeliveryWindowEnd   *time.Time `json:"delivery_window_end,omitempty"`
+}
+
+// nearestNeighborRoute ordena las paradas yendo siempre a la más cercana aún no
+// visitada, partiendo de (startLat, startLng). Si no hay punto de partida se
+// empieza por la primera parada. Todas las paradas deben tener coordenadas.
+func nearestNeighborRoute(start *[2]float64, stops []RouteStop) []RouteStop {
+	remaining := append([]RouteStop(nil), stops...)
+	route := make([]RouteStop, 0, len(stops))
+	var cur [2]float64
+	if start != nil {
+		cur = *start
+	} else if len(remaining) > 0 {
+		cur = [2]float64{*remaining[0].Lat, *remaining[0].Lng}
+	}
+	total := 0.0
+	for len(remaining) > 0 {
+		best, bestKm := 0, math.MaxFloat64
+		for i, st := range remaining {
+			if km := haversineKm(cur[0], cur[1], *st.Lat, *st.Lng); km < bestKm {
+				best, bestKm = i, km
+			}
+		}
+		next := remaining[best]
+		total += bestKm
+		next.LegKm, next.CumulativeKm = bestKm, total
+		route = append(route, next)
+		cur = [2]float64{*next.Lat, *next.Lng}
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return route
+}
+
+// GET /api/v1/drivers/:id/route (el propio repartidor o un admin)
+// Parte del almacén, o de ?from_lat=&from_lng= si el repartidor envía su ubicación.
+func driverRouteHandler(c *gin.Context) {
+	driverID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	if !isSelfOrAdmin(c, driverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return
+	}
+	var start *[2]float64
+	if c.Query("from_lat") != "" || c.Query("from_lng") != "" {
+		lat, err1 := strconv.ParseFloat(c.Query("from_lat"), 64)
+		lng, err2 := strconv.ParseFloat(c.Query("from_lng"), 64)
+		if err1 != nil || err2 != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from_lat y from_lng deben ser numéricos"})
+			return
+		}
+		start = &[2]float64{lat, lng}
+	} else if warehouseLat != nil && warehouseLng != nil {
+		start = &[2]float64{*warehouseLat, *warehouseLng}
+	}
+
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT o.id, o.status, a.id, a.street, a.lat, a.lng, o.scheduled_at, o.delivery_window_start, o.delivery_window_end
+        FROM orders o
+        JOIN addresses a ON a.id=o.address_id
+        WHERE o.assigned_driver_id=? AND o.status IN (`+inRouteStatusesSQL+`)
+        ORDER BY o.id`, driverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var located []RouteStop
+	unlocated := []RouteStop{}
+	for rows.Next() {
+		var st RouteStop
+		if err := rows.Scan(&st.OrderID, &st.Status, &st.AddressID, &st.Street, &st.Lat, &st.Lng, &st.ScheduledAt, &st.DeliveryWindowStart, &st.DeliveryWindowEnd); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if st.Lat == nil || st.Lng == nil {
+			unlocated = append(unlocated, st)
+			continue
+		}
+		located = append(located, st)
+	}
+	route := nearestNeighborRoute(start, located)
+	totalKm := 0.0
+	if len(route) > 0 {
+		totalKm = route[len(route)-1].CumulativeKm
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"driver_id":        driverID,
+		"stops":            route,
+		"total_km":         totalKm,
+		"without_location": unlocated, // pedidos sin coordenadas, fuera de la ruta
+	})
+}
+
+// Carga de trabajo actual de un repartidor
+type DriverWorkload struct {
+	DriverID    int64  `json:"driver_id"`
+	FullName    string `json:"full_name"`
+	IsAvailable bool   `json:"is_available"`
+	Assigned    int    `json:"asignado"`
+	Preparing   int    `json:"en_preparacion"`
+	InTransit   int    `json:"en_camino"`
+	Total       int    `json:"total"`
+}
+
+// driverWorkloads lista los repartidores activos del menos al más cargado
+// (pedidos asignado + en_preparacion + en_camino), desempatando por id.
+func driverWorkloads(ctx context.Context) ([]DriverWorkload, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT u.id, u.full_name, u.is_available,
+               COUNT(CASE WHEN o.status='asignado' THEN 1 END),
+               COUNT(CASE WHEN o.status='en_preparacion' THEN 1 END),
+               COUNT(CASE WHEN o.status='en_camino' THEN 1 END)
+        FROM users u
+        LEFT JOIN orders o ON o.assigned_driver_id=u.id AND o.status IN (`+inRouteStatusesSQL+`)
+        WHERE u.role_id=? AND u.is_active=TRUE
+        GROUP BY u.id, u.full_name, u.is_available
+        ORDER BY COUNT(o.id), u.id`, roleDriver)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []DriverWorkload{}
+	for rows.Next() {
+		var w DriverWorkload
+		if err := rows.Scan(&w.DriverID, &w.FullName, &w.IsAvailable, &w.Assigned, &w.Preparing, &w.InTransit); err != nil {
+			return nil, err
+		}
+		w.Total = w.Assigned + w.Preparing + w.InTransit
+		list = append(list, w)
+	}
+	return list, rows.Err()
+}
+
+// GET /api/v1/drivers/workload (admin)
+func driverWorkloadHandler(c *gin.Context) {
+	list, err := driverWorkloads(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+type UpdateAvailabilityReq struct {
+	IsAvailable *bool `json:"is_available"`
+}
+
+// PATCH /api/v1/drivers/:id/availability (el propio repartidor o un admin)
+func updateDriverAvailabilityHandler(c *gin.Context) {
+	driverID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	if !isSelfOrAdmin(c, driverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return
+	}
+	var req UpdateAvailabilityReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.IsAvailable == nil {
+		respondInvalid(c, "is_available", "is_available requerido")
+		return
+	}
+	res, err := db.ExecContext(c.Request.Context(), `UPDATE users SET is_available=? WHERE id=? AND role_id=?`, *req.IsAvailable, driverID, roleDriver)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// RowsAffected es 0 también si el valor no cambió; confirmamos que exista
+		var exists int
+		if err := db.QueryRowContext(c.Request.Context(), `SELECT COUNT(1) FROM users WHERE id=? AND role_id=?`, driverID, roleDriver).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "repartidor no encontrado"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// Línea resumida de un pedido en el manifiesto
+type ManifestItem struct {
+	ProductName string `json:"product_name"`
+	Qty         int    `json:"qty"`
+}
+
+// Parada del manifiesto diario: la parada de la ruta con sus ítems y montos
+type ManifestStop struct {
+	RouteStop
+	Reference   *string        `json:"reference,omitempty"`
+	Priority    string         `json:"priority"`
+	Notes       *string        `json:"notes,omitempty"`
+	Items       []ManifestItem `json:"items"`
+	Units       int            `json:"units"`
+	Subtotal    float64        `json:"subtotal"`
+	DeliveryFee float64        `json:"delivery_fee"`
+	Total       float64        `json:"total"`
+}
+
+// GET /api/v1/drivers/:id/manifest?date=YYYY-MM-DD (el propio repartidor o un admin)
+// Pedidos del repartidor para ese día (por defecto hoy, en appLocation): los
+// programados ese día (scheduled_at o inicio de la franja) y, si es hoy, también
+// los asignado/en_preparacion/en_camino sin horario. Ordenados con la heurística de la ruta; los
+// que no tienen coordenadas van al final en orden de id.
+func driverManifestHandler(c *gin.Context) {
+	driverID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	if !isSelfOrAdmin(c, driverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return
+	}
+	now := time.Now()
+	day := now
+	if v := c.Query("date"); v != "" {
+		if day, err = time.ParseInLocation("2006-01-02", v, appLocation); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date debe ser YYYY-MM-DD"})
+			return
+		}
+	}
+	start, end := dayBounds(day, appLocation)
+	todayStart, _ := dayBounds(now, appLocation)
+	includeUnscheduled := start.Equal(todayStart)
+
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT o.id, o.status, a.id, a.street, a.reference, a.lat, a.lng, o.scheduled_at, o.delivery_window_start, o.delivery_window_end,
+               o.priority, o.notes, o.subtotal, o.delivery_fee, o.total
+        FROM orders o
+        JOIN addresses a ON a.id=o.address_id
+        WHERE o.assigned_driver_id=? AND o.status IN (`+inRouteStatusesSQL+`,'`+statusEntregado+`')
+          AND ((COALESCE(o.scheduled_at, o.delivery_window_start) >= ? AND COALESCE(o.scheduled_at, o.delivery_window_start) < ?)
+               OR (? AND o.scheduled_at IS NULL AND o.delivery_window_start IS NULL AND o.status IN (`+inRouteStatusesSQL+`)))
+        ORDER BY o.id`, driverID, start, end, includeUnscheduled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	byID := map[int64]*ManifestStop{}
+	var ids []any
+	var located, unlocated []RouteStop
+	for rows.Next() {
+		m := &ManifestStop{Items: []ManifestItem{}}
+		st := &m.RouteStop
+		if err := rows.Scan(&st.OrderID, &st.Status, &st.AddressID, &st.Street, &m.Reference, &st.Lat, &st.Lng, &st.ScheduledAt, &st.DeliveryWindowStart, &st.DeliveryWindowEnd,
+			&m.Priority, &m.Notes, &m.Subtotal, &m.DeliveryFee, &m.Total); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		byID[st.OrderID] = m
+		ids = append(ids, st.OrderID)
+		if st.Lat == nil || st.Lng == nil {
+			unlocated = append(unlocated, *st)
+		} else {
+			located = append(located, *st)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(ids) > 0 {
+		itemRows, err := db.QueryContext(c.Request.Context(), `
+            SELECT oi.order_id, p.name, oi.qty
+            FROM order_items oi
+            JOIN products p ON p.id=oi.product_id
+            WHERE oi.order_id IN (?`+strings.Repeat(",?", len(ids)-1)+`)
+            ORDER BY oi.order_id, oi.id`, ids...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer itemRows.Close()
+		for itemRows.Next() {
+			var orderID int64
+			var it ManifestItem
+			if err := itemRows.Scan(&orderID, &it.ProductName, &it.Qty); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			m := byID[orderID]
+			m.Items = append(m.Items, it)
+			m.Units += it.Qty
+		}
+	}
+
+	// Ruta desde el almacén (si está configurado) y luego las paradas sin coordenadas
+	var origin *[2]float64
+	if warehouseLat != nil && warehouseLng != nil {
+		origin = &[2]float64{*warehouseLat, *warehouseLng}
+	}
+	stops := make([]ManifestStop, 0, len(ids))
+	units, total := 0, 0.0
+	for _, st := range append(nearestNeighborRoute(origin, located), unlocated...) {
+		m := byID[st.OrderID]
+		m.RouteStop = st
+		stops = append(stops, *m)
+		units += m.Units
+		total += m.Total
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"driver_id": driverID,
+		"date":      start.Format("2006-01-02"),
+		"timezone":  appLocation.String(),
+		"stops":     stops,
+		"orders":    len(stops),
+		"units":     units,
+		"total":     total,
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDayBounds(t *testing.T) {
+	lima, err := time.LoadLocation("America/Lima")
+	if err != nil {
+		t.Skip("sin base de zonas horarias:", err)
+	}
+	// 02:30 UTC del 15 es todavía el 14 en Lima (UTC-5)
+	start, end := dayBounds(time.Date(2026, 10, 15, 2, 30, 0, 0, time.UTC), lima)
+	if want := time.Date(2026, 10, 14, 0, 0, 0, 0, lima); !start.Equal(want) {
+		t.Errorf("inicio = %v, quiero %v", start, want)
+	}
+	if end.Sub(start) != 24*time.Hour {
+		t.Errorf("el día dura %v", end.Sub(start))
+	}
+}
+
+func TestDriverToday(t *testing.T) {
+	t.Run("el propio repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testDriver)
+		mock.ExpectQuery(sqlText(`SELECT COUNT(*), COALESCE(SUM(total), 0)`)).WithArgs(testDriver.ID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"n", "revenue"}).AddRow(4, 98.5))
+		mock.ExpectQuery(sqlText(`FROM order_status_history h`)).WithArgs(testDriver.ID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"assigned", "cancelled"}).AddRow(6, 1))
+
+		w := serve(http.MethodGet, "/api/v1/drivers/2/today", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["delivered"] != float64(4) || body["assigned"] != float64(6) || body["cancelled"] != float64(1) {
+			t.Errorf("cuerpo = %v", body)
+		}
+	})
+	t.Run("otro repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/drivers/9/today", "", authAs(t, mock, testDriver))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+func stopAt(orderID int64, lat, lng float64) RouteStop {
+	return RouteStop{OrderID: orderID, Lat: &lat, Lng: &lng}
+}
+
+func TestNearestNeighborRoute(t *testing.T) {
+	// Sobre el ecuador, a 1, 3 y 2 grados del origen
+	stops := []RouteStop{stopAt(1, 0, 1), stopAt(2, 0, 3), stopAt(3, 0, 2)}
+	route := nearestNeighborRoute(&[2]float64{0, 0}, stops)
+	var got []int64
+	for _, st := range route {
+		got = append(got, st.OrderID)
+	}
+	if !slices.Equal(got, []int64{1, 3, 2}) {
+		t.Fatalf("orden = %v", got)
+	}
+	if last := route[len(route)-1]; math.Abs(last.CumulativeKm-haversineKm(0, 0, 0, 3)) > 1e-6 {
+		t.Errorf("cumulative_km = %v", last.CumulativeKm)
+	}
+	if stops[0].LegKm != 0 {
+		t.Error("no debe modificar las paradas de entrada")
+	}
+	if len(nearestNeighborRoute(nil, nil)) != 0 {
+		t.Error("sin paradas la ruta es vacía")
+	}
+}
+
+func TestDriverRouteSeparatesStopsWithoutLocation(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testDriver)
+	mock.ExpectQuery(sqlText(`FROM orders o`)).WithArgs(testDriver.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "address_id", "street", "lat", "lng", "scheduled_at", "window_start", "window_end"}).
+			AddRow(10, statusAsignado, 20, "Av. Uno", 0.0, 2.0, nil, nil, nil).
+			AddRow(11, statusEnCamino, 21, "Jr. Dos", nil, nil, nil, nil, nil).
+			AddRow(12, statusAsignado, 22, "Calle Tres", 0.0, 1.0, nil, nil, nil))
+
+	w := serve(http.MethodGet, "/api/v1/drivers/2/route?from_lat=0&from_lng=0", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	stops, _ := body["stops"].([]any)
+	if len(stops) != 2 || stops[0].(map[string]any)["order_id"] != float64(12) {
+		t.Errorf("stops = %v", body["stops"])
+	}
+	if unlocated, _ := body["without_location"].([]any); len(unlocated) != 1 {
+		t.Errorf("without_location = %v", body["without_location"])
+	}
+}
+
+func TestDriverWorkload(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`FROM users u`)).WithArgs(roleDriver).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "full_name", "is_available", "asignado", "en_preparacion", "en_camino"}).
+			AddRow(5, "Luis", true, 0, 0, 0).
+			AddRow(2, "Repartidor", false, 2, 1, 1))
+
+	w := serve(http.MethodGet, "/api/v1/drivers/workload", "", h)
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `"driver_id":2,"full_name":"Repartidor","is_available":false,"asignado":2,"en_preparacion":1,"en_camino":1,"total":4`) {
+		t.Errorf("cuerpo = %s", w.Body.String())
+	}
+}
+
+func TestDriverWorkloadRequiresAdmin(t *testing.T) {
+	mock := newMock(t)
+	w := serve(http.MethodGet, "/api/v1/drivers/workload", "", authAs(t, mock, testDriver))
+	expectStatus(t, w, http.StatusForbidden)
+}
+
+func TestDriverManifest(t *testing.T) {
+	setVar(t, &appLocation, time.UTC)
+	setVar(t, &warehouseLat, floatPtr(0))
+	setVar(t, &warehouseLng, floatPtr(0))
+	start := time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)
+
+	mock := newMock(t)
+	h := authAs(t, mock, testDriver)
+	// Un día que no es hoy: solo los programados
+	mock.ExpectQuery(sqlText(`WHERE o.assigned_driver_id=?`)).WithArgs(testDriver.ID, start, start.AddDate(0, 0, 1), false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "address_id", "street", "reference", "lat", "lng", "scheduled_at", "window_start", "window_end", "priority", "notes", "subtotal", "delivery_fee", "total"}).
+			AddRow(10, statusAsignado, 20, "Av. Uno", nil, 0.0, 2.0, start.Add(9*time.Hour), nil, nil, priorityNormal, nil, 20.0, 5.0, 25.0).
+			AddRow(11, statusAsignado, 21, "Jr. Dos", nil, nil, nil, start.Add(10*time.Hour), nil, nil, priorityHigh, nil, 10.0, 0.0, 10.0).
+			AddRow(12, statusAsignado, 22, "Calle Tres", nil, 0.0, 1.0, start.Add(11*time.Hour), nil, nil, priorityNormal, nil, 8.0, 2.0, 10.0))
+	mock.ExpectQuery(sqlText(`FROM order_items oi`)).WithArgs(int64(10), int64(11), int64(12)).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id", "name", "qty"}).
+			AddRow(10, "Bidón 20L", 2).AddRow(11, "Botella 1L", 6).AddRow(12, "Bidón 20L", 1))
+
+	w := serve(http.MethodGet, "/api/v1/drivers/2/manifest?date=2020-01-06", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["orders"] != float64(3) || body["units"] != float64(9) || body["total"] != float64(45) {
+		t.Errorf("resumen = %v", body)
+	}
+	var got []float64
+	for _, s := range body["stops"].([]any) {
+		got = append(got, s.(map[string]any)["order_id"].(float64))
+	}
+	// Ruta desde el almacén y al final la parada sin coordenadas
+	if !slices.Equal(got, []float64{12, 10, 11}) {
+		t.Errorf("orden de paradas = %v", got)
+	}
+
+	t.Run("otro repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/drivers/9/manifest", "", authAs(t, mock, testDriver))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
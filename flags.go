@@ -0,0 +1,117 @@
+package main
+
+// Feature flags para endpoints experimentales.
+// Fuente: tabla feature_flags (name, enabled), recargable en caliente, y variables
+// de entorno FEATURE_<NOMBRE>=true|false que tienen prioridad. Por defecto: apagado.
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Flags de los endpoints experimentales registrados con requireFeature
+const (
+	flagOrderStream = "order_stream" // GET /api/v1/orders/stream (SSE, broker en memoria)
+)
+
+var (
+	flagsMu sync.RWMutex
+	dbFlags = map[string]bool{}
+)
+
+// loadFeatureFlags reemplaza los flags en memoria con los de la BD.
+func loadFeatureFlags() error {
+	rows, err := db.Query(`SELECT name, enabled FROM feature_flags`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	flags := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return err
+		}
+		flags[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	flagsMu.Lock()
+	dbFlags = flags
+	flagsMu.Unlock()
+	return nil
+}
+
+func flagEnvKey(name string) string {
+	return "FEATURE_" + strings.ToUpper(name)
+}
+
+// featureEnabled: env FEATURE_<NOMBRE> > tabla feature_flags > apagado.
+func featureEnabled(name string) bool {
+	if v, err := strconv.ParseBool(os.Getenv(flagEnvKey(name))); err == nil {
+		return v
+	}
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	return dbFlags[name]
+}
+
+// requireFeature responde 404 (como si la ruta no existiera) si el flag está apagado.
+func requireFeature(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !featureEnabled(name) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no encontrado"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// FeatureFlag es el estado efectivo de un flag y de dónde sale.
+type FeatureFlag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"` // env | db
+}
+
+// ADMIN: GET /api/v1/flags
+func listFlagsHandler(c *gin.Context) {
+	flagsMu.RLock()
+	names := map[string]bool{}
+	for name := range dbFlags {
+		names[name] = true
+	}
+	flagsMu.RUnlock()
+	for _, kv := range os.Environ() {
+		if key, _, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(key, "FEATURE_") {
+			names[strings.ToLower(strings.TrimPrefix(key, "FEATURE_"))] = true
+		}
+	}
+	list := []FeatureFlag{}
+	for name := range names {
+		source := "db"
+		if _, err := strconv.ParseBool(os.Getenv(flagEnvKey(name))); err == nil {
+			source = "env"
+		}
+		list = append(list, FeatureFlag{Name: name, Enabled: featureEnabled(name), Source: source})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	c.JSON(http.StatusOK, list)
+}
+
+// ADMIN: recargar flags de la BD sin reiniciar
+func reloadFlagsHandler(c *gin.Context) {
+	if err := loadFeatureFlags(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	listFlagsHandler(c)
+}
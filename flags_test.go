@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFeatureEnabledPrecedence(t *testing.T) {
+	setVar(t, &dbFlags, map[string]bool{"beta": true})
+	if !featureEnabled("beta") {
+		t.Error("beta encendido en la BD")
+	}
+	if featureEnabled("otro") {
+		t.Error("un flag desconocido está apagado")
+	}
+	t.Setenv("FEATURE_BETA", "false")
+	if featureEnabled("beta") {
+		t.Error("FEATURE_BETA=false tiene prioridad sobre la BD")
+	}
+}
+
+func TestRequireFeatureHidesRoute(t *testing.T) {
+	newMock(t)
+	setVar(t, &dbFlags, map[string]bool{})
+	w := serve(http.MethodGet, "/api/v1/orders/stream", "", nil)
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestReloadFlags(t *testing.T) {
+	mock := newMock(t)
+	setVar(t, &dbFlags, map[string]bool{})
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT name, enabled FROM feature_flags`)).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "enabled"}).AddRow(flagOrderStream, true))
+
+	w := serve(http.MethodPost, "/api/v1/admin/flags/reload", "", h)
+	expectStatus(t, w, http.StatusOK)
+	if !featureEnabled(flagOrderStream) {
+		t.Error("order_stream debe quedar encendido tras recargar")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+// Compresión gzip de respuestas.
+// Se activa con GZIP_ENABLED (por defecto true) y solo comprime si el cliente envía
+// Accept-Encoding: gzip y el cuerpo mide al menos GZIP_MIN_BYTES (por defecto 1024).
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	gzipEnabled  = true
+	gzipMinBytes = 1024
+)
+
+func loadGzipConfig() {
+	gzipEnabled = envBool("GZIP_ENABLED", gzipEnabled)
+	gzipMinBytes = envInt("GZIP_MIN_BYTES", gzipMinBytes)
+}
+
+// gzipWriter acumula el cuerpo para decidir al final si vale la pena comprimir.
+// Flush (streaming) desactiva la compresión y envía lo acumulado tal cual.
+type gzipWriter struct {
+	gin.ResponseWriter
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Written() bool {
+	return w.buf.Len() > 0 || w.ResponseWriter.Written()
+}
+
+func (w *gzipWriter) Flush() {
+	if !w.passthrough {
+		w.passthrough = true
+		if w.buf.Len() > 0 {
+			w.ResponseWriter.Write(w.buf.Bytes())
+			w.buf.Reset()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+func gzipResponses(minBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+		orig := c.Writer
+		gw := &gzipWriter{ResponseWriter: orig}
+		c.Writer = gw
+		c.Next()
+		c.Writer = orig
+		if gw.passthrough || gw.buf.Len() == 0 {
+			return
+		}
+
+		h := orig.Header()
+		h.Add("Vary", "Accept-Encoding")
+		status := orig.Status()
+		if gw.buf.Len() < minBytes || h.Get("Content-Encoding") != "" ||
+			status == http.StatusNoContent || status == http.StatusNotModified {
+			orig.Write(gw.buf.Bytes())
+			return
+		}
+		var out bytes.Buffer
+		zw := gzip.NewWriter(&out)
+		if _, err := zw.Write(gw.buf.Bytes()); err != nil || zw.Close() != nil {
+			// Si falla la compresión se envía sin comprimir
+			orig.Write(gw.buf.Bytes())
+			return
+		}
+		h.Set("Content-Encoding", "gzip")
+		h.Set("Content-Length", strconv.Itoa(out.Len()))
+		orig.Write(out.Bytes())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+// Tokens de acceso (JWT HS256) emitidos por POST/GET /api/v1/login.
+// Se envían como "Authorization: Bearer <token>"; HTTP Basic sigue funcionando.
+// JWT_SECRET firma los tokens (si falta se genera uno al azar y los tokens no
+// sobreviven un reinicio). JWT_TTL_MINUTES controla la vigencia (por defecto 15);
+// para sesiones largas se usa el refresh token (refresh_tokens.go).
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	jwtSecret []byte
+	jwtTTL    = 15 * time.Minute
+)
+
+var errInvalidToken = errors.New("token inválido o expirado")
+
+func loadJWTConfig() {
+	jwtTTL = time.Duration(envInt("JWT_TTL_MINUTES", int(jwtTTL/time.Minute))) * time.Minute
+	if s := os.Getenv("JWT_SECRET"); s != "" {
+		jwtSecret = []byte(s)
+		return
+	}
+	jwtSecret = make([]byte, 32)
+	if _, err := rand.Read(jwtSecret); err != nil {
+		log.Fatal("No se pudo generar JWT_SECRET:", err)
+	}
+	log.Println("JWT_SECRET no definido: se usa uno temporal (los tokens se invalidan al reiniciar)")
+}
+
+// tokenClaims: sub es el id del usuario; role se incluye solo como referencia
+// para el cliente, la API vuelve a leer el usuario de la BD en cada petición.
+type tokenClaims struct {
+	Role int8 `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// issueToken firma un token de acceso para el usuario.
+func issueToken(u User) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(jwtTTL)
+	claims := tokenClaims{
+		Role: u.RoleID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatInt(u.ID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	return signed, exp, err
+}
+
+// userFromToken valida firma y vigencia y devuelve el usuario activo del token.
+func userFromToken(ctx context.Context, raw string) (User, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (any, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return User{}, errInvalidToken
+	}
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return User{}, errInvalidToken
+	}
+	u, err := userByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !u.IsActive) {
+		return User{}, errInvalidToken
+	}
+	return u, err
+}
+
+// userByID lee los datos del usuario que se dejan en el contexto de la petición.
+func userByID(ctx context.Context, id int64) (User, error) {
+	var u User
+	err := db.QueryRowContext(ctx, `SELECT id, role_id, full_name, phone, email, num_doc, is_active, branch_id FROM users WHERE id=?`, id).
+		Scan(&u.ID, &u.RoleID, &u.FullName, &u.Phone, &u.Email, &u.NumDoc, &u.IsActive, &u.BranchID)
+	return u, err
+}
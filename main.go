@@ -10,16 +10,34 @@ package main
 //   PORT=8080
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 )
 
 // MODELOS BÁSICOS (coinciden con la BD sugerida)
@@ -33,6 +51,8 @@ type User struct {
 	NumDoc    *string   `json:"num_doc,omitempty"`
 	IsActive  bool      `json:"is_active"`
 	CreatedAt sql.NullTime `json:"created_at"`
+	BranchID  *int64    `json:"branch_id,omitempty"` // nil = sin sucursal fija
+	Timezone  *string   `json:"timezone,omitempty"`  // IANA; nil = zona del servidor (APP_TIMEZONE)
 }
 
 type Address struct {
@@ -51,14 +71,29 @@ type Product struct {
 	Name           string   `json:"name"`
 	CapacityLiters *float64 `json:"capacity_liters,omitempty"`
 	Price          float64  `json:"price"`
+	Currency       string   `json:"currency"` // moneda de price
 	IsActive       bool     `json:"is_active"`
+	MinQty         *int     `json:"min_qty,omitempty"`      // NULL = sin mínimo
+	QtyMultiple    *int     `json:"qty_multiple,omitempty"` // NULL = cualquier cantidad
+	BranchID       int64    `json:"branch_id"`
+	Stock          *int     `json:"stock"` // null = ilimitado
+	Version        int      `json:"version,omitempty"` // solo en el detalle; va en If-Match al editar
+	// Solo con ?address_id=: tarifa de delivery para esa dirección y precio + tarifa
+	DeliveryFee       *float64 `json:"delivery_fee,omitempty"`
+	PriceWithDelivery *float64 `json:"price_with_delivery,omitempty"`
+	// Promoción vigente que fija price (si un precio del cliente la pisa, no aparece)
+	Promotion *AppliedPromotion `json:"promotion,omitempty"`
+
+	priceSource string // base | custom | promo (para convertir con ?currency=)
 }
 
 // Precio personalizado por cliente y producto
 type CustomerPrice struct {
+	ID         int64   `json:"id"`
 	CustomerID int64   `json:"customer_id"`
 	ProductID  int64   `json:"product_id"`
 	Price      float64 `json:"price"`
+	Currency   string  `json:"currency"`
 	IsActive   bool    `json:"is_active"`
 }
 
@@ -66,6 +101,7 @@ type UpsertCustomerPriceReq struct {
 	CustomerID int64   `json:"customer_id"`
 	ProductID  int64   `json:"product_id"`
 	Price      float64 `json:"price"`
+	Currency   string  `json:"currency"` // opcional; por defecto la moneda base
 	IsActive   *bool   `json:"is_active"`
 }
 
@@ -78,20 +114,69 @@ type Order struct {
 	ID               int64      `json:"id"`
 	CustomerID       int64      `json:"customer_id"`
 	AddressID        int64      `json:"address_id"`
+	BranchID         int64      `json:"branch_id"`
+	CreatedBy        int64      `json:"created_by"` // quien registró el pedido (agente o el mismo cliente)
 	AssignedDriverID *int64     `json:"assigned_driver_id,omitempty"`
 	Status           string     `json:"status"`
+	Priority         string     `json:"priority"`
+	PaymentMethod    *string    `json:"payment_method,omitempty"`
+	Source           string     `json:"source"` // web | app | phone
 	Subtotal         float64    `json:"subtotal"`
 	DeliveryFee      float64    `json:"delivery_fee"`
+	Tax              float64    `json:"tax"`
 	Total            float64    `json:"total"`
 	Notes            *string    `json:"notes,omitempty"`
 	ScheduledAt      sql.NullTime  `json:"schedule_at"`
 	DeliveredAt      sql.NullTime  `json:"delivered_at"`
 	CreatedAt        sql.NullTime  `json:"created_at"`
+	TransitStartedAt *time.Time `json:"transit_started_at,omitempty"` // paso a en_camino (solo en el detalle)
+	// Franja de entrega pedida por el cliente (alternativa a scheduled_at)
+	DeliveryWindowStart *time.Time `json:"delivery_window_start,omitempty"`
+	DeliveryWindowEnd   *time.Time `json:"delivery_window_end,omitempty"`
+	// Solo con ?expand=cancellation y pedidos cancelados
+	CancellationReason *string    `json:"cancellation_reason,omitempty"`
+	CancelledAt        *time.Time `json:"cancelled_at,omitempty"`
+	// Prueba de entrega (solo en el detalle)
+	ProofURL      *string      `json:"proof_url,omitempty"`
+	SignatureName *string      `json:"signature_name,omitempty"`
+	ProofAt       *time.Time   `json:"proof_at,omitempty"`
+	// Solo con ?money=cents: montos en céntimos enteros junto a los float
+	SubtotalCents    *int64 `json:"subtotal_cents,omitempty"`
+	DeliveryFeeCents *int64 `json:"delivery_fee_cents,omitempty"`
+	TaxCents         *int64 `json:"tax_cents,omitempty"`
+	TotalCents       *int64 `json:"total_cents,omitempty"`
+	// Solo con ?money=string: montos como string decimal ("9.50") junto a los float
+	SubtotalDecimal    *decimal `json:"subtotal_decimal,omitempty"`
+	DeliveryFeeDecimal *decimal `json:"delivery_fee_decimal,omitempty"`
+	TaxDecimal         *decimal `json:"tax_decimal,omitempty"`
+	TotalDecimal       *decimal `json:"total_decimal,omitempty"`
+	// Solo con ?currency_format=true: montos listos para mostrar ("S/ 9.50")
+	SubtotalFormatted    *string `json:"subtotal_formatted,omitempty"`
+	DeliveryFeeFormatted *string `json:"delivery_fee_formatted,omitempty"`
+	TaxFormatted         *string `json:"tax_formatted,omitempty"`
+	TotalFormatted       *string `json:"total_formatted,omitempty"`
 }
 
 type OrderWithItems struct {
 	Order
-	Items []OrderItem `json:"items"`
+	Items    []OrderItem    `json:"items"`
+	Customer *OrderCustomer `json:"customer,omitempty"` // ?expand=customer
+	Address  *OrderAddress  `json:"address,omitempty"`  // ?expand=address
+}
+
+// Datos mínimos del cliente para páginas de seguimiento
+type OrderCustomer struct {
+	ID       int64   `json:"id"`
+	FullName string  `json:"full_name"`
+	Phone    *string `json:"phone,omitempty"`
+}
+
+// Snapshot de la dirección de entrega
+type OrderAddress struct {
+	Street    string   `json:"street"`
+	Reference *string  `json:"reference,omitempty"`
+	Lat       *float64 `json:"lat,omitempty"`
+	Lng       *float64 `json:"lng,omitempty"`
 }
 
 type OrderItem struct {
@@ -101,9 +186,20 @@ type OrderItem struct {
 	Qty       int     `json:"qty"`
 	UnitPrice float64 `json:"unit_price"`
 	LineTotal float64 `json:"line_total"`
+	PriceSource string `json:"price_source"` // base | custom | tier | promo
+	PromotionID *int64 `json:"promotion_id,omitempty"`
 	// opcional: nombre del producto
 	ProductName string   `json:"product_name"`
 	Capacity    *float64 `json:"capacity_liters,omitempty"`
+	// Solo con ?money=cents
+	UnitPriceCents *int64 `json:"unit_price_cents,omitempty"`
+	LineTotalCents *int64 `json:"line_total_cents,omitempty"`
+	// Solo con ?money=string
+	UnitPriceDecimal *decimal `json:"unit_price_decimal,omitempty"`
+	LineTotalDecimal *decimal `json:"line_total_decimal,omitempty"`
+	// Solo con ?currency_format=true
+	UnitPriceFormatted *string `json:"unit_price_formatted,omitempty"`
+	LineTotalFormatted *string `json:"line_total_formatted,omitempty"`
 }
 
 type StatusHistory struct {
@@ -114,6 +210,29 @@ type StatusHistory struct {
 	ChangedBy int64     `json:"changed_by"`
 	ChangedAt  sql.NullTime  `json:"changed_at"`
 	Note      *string   `json:"note,omitempty"`
+	// Solo en reasignaciones
+	OldDriverID *int64 `json:"old_driver_id,omitempty"`
+	NewDriverID *int64 `json:"new_driver_id,omitempty"`
+}
+
+// Quién hizo un cambio del historial (?expand=actor)
+type HistoryActor struct {
+	ID       int64  `json:"id"`
+	FullName string `json:"full_name"`
+	RoleID   int8   `json:"role_id"`
+}
+
+// Fila del historial con el usuario resuelto; changed_by_user es null si el usuario ya no existe
+type StatusHistoryWithActor struct {
+	StatusHistory
+	ChangedByUser *HistoryActor `json:"changed_by_user"`
+}
+
+// Estado de pedido con etiqueta legible (tabla statuses)
+type OrderStatus struct {
+	Code  string  `json:"code"`
+	Label string  `json:"label"`
+	Color *string `json:"color,omitempty"`
 }
 
 // SOLICITUDES
@@ -125,6 +244,7 @@ type CreateUserReq struct {
 	Email    *string `json:"email"`
 	NumDoc   *string `json:"num_doc"`
 	Password string  `json:"password"` // Para MVP no haremos JWT, solo guardamos hash luego
+	Timezone *string `json:"timezone"` // opcional, nombre IANA (ej. America/Lima)
 }
 
 type UpdateUserReq struct {
@@ -135,6 +255,7 @@ type UpdateUserReq struct {
 	NumDoc   *string `json:"num_doc"`
 	Password *string `json:"password"`  // opcional; si viene, se reemplaza
 	IsActive *bool   `json:"is_active"` // opcional; por defecto true
+	Timezone *string `json:"timezone"`  // opcional, nombre IANA; omitir lo deja vacío
 }
 
 type CreateAddressReq struct {
@@ -151,36 +272,187 @@ type CreateProductReq struct {
 	Name           string   `json:"name"`
 	CapacityLiters *float64 `json:"capacity_liters"`
 	Price          float64  `json:"price"`
+	Currency       string   `json:"currency"` // opcional; por defecto la moneda base (en PUT, omitir la mantiene)
 	IsActive       *bool    `json:"is_active"`
+	Stock          *int     `json:"stock"` // NULL = ilimitado; en PUT, omitir mantiene el stock actual
+	MinQty         *int     `json:"min_qty"`
+	QtyMultiple    *int     `json:"qty_multiple"`
+	BranchID       *int64   `json:"branch_id"` // opcional; por defecto la sucursal de la petición
 }
 
 type CreateOrderReq struct {
 	CustomerID  int64          `json:"customer_id"`
 	AddressID   int64          `json:"address_id"`
 	Items       []OrderItemReq `json:"items"`
-	ScheduledAt  sql.NullTime  `json:"scheduled_at"`
+	ScheduledAt *string        `json:"scheduled_at"` // RFC3339; sin offset se interpreta en la zona del cliente
+	// Franja de entrega ("entre 14:00 y 17:00"): van juntas y excluyen scheduled_at
+	DeliveryWindowStart *string `json:"delivery_window_start"`
+	DeliveryWindowEnd   *string `json:"delivery_window_end"`
 	Notes       *string        `json:"notes"`
+	BranchID    *int64         `json:"branch_id"` // opcional; por defecto la sucursal de la petición
+	Priority    *string        `json:"priority"`  // normal (por defecto) | high
+	PaymentMethod *string      `json:"payment_method"` // opcional: cash | card | transfer
+	Source        *string      `json:"source"`         // web (por defecto) | app | phone
+	// Snapshot firmado de POST /orders/quote: cobra los precios cotizados si sigue vigente
+	PriceSnapshot *string `json:"price_snapshot"`
+}
+
+// Estados del pedido (orders.status)
+const (
+	statusPorAtender    = "por_atender"
+	statusAsignado      = "asignado"
+	statusEnPreparacion = "en_preparacion"
+	statusEnCamino      = "en_camino"
+	statusEntregado     = "entregado"
+	statusCancelado     = "cancelado"
+)
+
+// Listas de estados para SQL (IN / NOT IN), armadas con las constantes.
+var (
+	closedStatusesSQL  = sqlStatusList(statusEntregado, statusCancelado)
+	openStatusesSQL    = sqlStatusList(statusPorAtender, statusAsignado, statusEnPreparacion, statusEnCamino)
+	inRouteStatusesSQL = sqlStatusList(statusAsignado, statusEnPreparacion, statusEnCamino) // ya en manos del repartidor
+)
+
+// sqlStatusList devuelve "'a','b'" para usar dentro de IN (...). Solo con constantes.
+func sqlStatusList(statuses ...string) string {
+	return "'" + strings.Join(statuses, "','") + "'"
+}
+
+// Prioridad del pedido (orders.priority)
+const (
+	priorityNormal = "normal"
+	priorityHigh   = "high"
+)
+
+// Medios de pago (orders.payment_method)
+var paymentMethods = map[string]bool{"cash": true, "card": true, "transfer": true}
+
+// Canal de origen del pedido (orders.source)
+const defaultOrderSource = "web"
+
+var orderSources = map[string]bool{"web": true, "app": true, "phone": true}
+
+type LoginReq struct {
+	Username string `json:"username"` // email, phone o num_doc
+	Password string `json:"password"`
 }
 
 type AssignOrderReq struct {
 	DriverID int64 `json:"driver_id"`
 }
 
+// Quien edita se toma del usuario autenticado; changed_by del body se ignora.
+type UpdateOrderItemsReq struct {
+	Items []OrderItemReq `json:"items"`
+}
+
+// Quien cambia la dirección se toma del usuario autenticado; changed_by del body se ignora.
+type UpdateOrderAddressReq struct {
+	AddressID int64 `json:"address_id"`
+}
+
+type OrderProofReq struct {
+	ProofURL      string  `json:"proof_url"`      // foto o firma ya subida (http/https)
+	SignatureName *string `json:"signature_name"` // opcional: quién recibió
+}
+
+type ReassignOrderReq struct {
+	DriverID int64   `json:"driver_id"`
+	Note     *string `json:"note"`
+}
+
+// Quien cancela es el usuario autenticado (ya no se toma changed_by del body)
+type CancelOrderReq struct {
+	Note *string `json:"note"`
+}
+
 type UpdateStatusReq struct {
 	NewStatus string  `json:"new_status"`
 	Note      *string `json:"note"`
-	ChangedBy int64   `json:"changed_by"`
+	ChangedBy int64   `json:"-"` // quien llama; no se toma del body
 }
 
 // VARIABLES GLOBALES SIMPLES (para MVP didáctico)
 
 var db *sql.DB
 
+// Información de build (se inyecta con -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=...")
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// CONFIGURACIÓN (se lee de variables de entorno en loadConfig)
+var (
+	numDocMinLen     = 8
+	numDocMaxLen     = 10
+	numDocDigitsOnly = true
+
+	passwordMinLen        = 8
+	passwordRequireDigit  = true
+	passwordRequireLetter = true
+
+	maxNotesLen     = 500
+	maxStreetLen    = 255
+	maxReferenceLen = 255
+	maxLabelLen     = 50
+
+	maxBodyBytes int64 = 1 << 20 // 1MB
+
+	// Zona horaria de negocio para cortes diarios (APP_TIMEZONE, ej. America/Lima)
+	appLocation = time.Local
+
+	// Total máximo de un pedido (subtotal + delivery); nil = sin límite
+	maxOrderTotal *float64
+)
+
 func init() {
 	godotenv.Load()
 }
 
+func loadConfig() {
+	numDocMinLen = envInt("NUM_DOC_MIN_LEN", numDocMinLen)
+	numDocMaxLen = envInt("NUM_DOC_MAX_LEN", numDocMaxLen)
+	numDocDigitsOnly = envBool("NUM_DOC_DIGITS_ONLY", numDocDigitsOnly)
+	passwordMinLen = envInt("PASSWORD_MIN_LEN", passwordMinLen)
+	passwordRequireDigit = envBool("PASSWORD_REQUIRE_DIGIT", passwordRequireDigit)
+	passwordRequireLetter = envBool("PASSWORD_REQUIRE_LETTER", passwordRequireLetter)
+	maxNotesLen = envInt("MAX_NOTES_LEN", maxNotesLen)
+	maxStreetLen = envInt("MAX_STREET_LEN", maxStreetLen)
+	maxReferenceLen = envInt("MAX_REFERENCE_LEN", maxReferenceLen)
+	maxLabelLen = envInt("MAX_LABEL_LEN", maxLabelLen)
+	maxBodyBytes = int64(envInt("MAX_BODY_BYTES", int(maxBodyBytes)))
+	maxOrderTotal = envFloatPtr("MAX_ORDER_TOTAL")
+	if tz := os.Getenv("APP_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatal("APP_TIMEZONE inválida:", err)
+		}
+		appLocation = loc
+	}
+	loadDeliveryConfig()
+	loadRateLimitConfig()
+	loadGzipConfig()
+	loadJWTConfig()
+	loadRefreshConfig()
+	loadTrackingConfig()
+	loadTaxConfig()
+	loadStaleOrderConfig()
+	loadPriceSnapshotConfig()
+	loadPhoneConfig()
+	loadTxConfig()
+	loadPasswordResetConfig()
+	loadTimeoutConfig()
+	loadCurrencyConfig()
+	loadSessionConfig()
+	loadCurrencyRatesConfig()
+}
+
 func main() {
+	loadConfig()
+
 	// 1) Conexión a MySQL
 	dsn := os.Getenv("DB_DSN")
 	if dsn == "" {
@@ -195,53 +467,149 @@ func main() {
 	if err := db.Ping(); err != nil {
 		log.Fatal("Error al conectar DB:", err)
 	}
+	if _, err := loadDeliveryFeeTiers(); err != nil {
+		log.Println("No se pudieron cargar delivery_fee_tiers (delivery sin costo):", err)
+	}
+	if err := loadFeatureFlags(); err != nil {
+		log.Println("No se pudieron cargar feature_flags (solo se usan FEATURE_*):", err)
+	}
 
 	// 2) Router
+	r := newRouter()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	log.Println("API escuchando en :" + port)
+	if err := r.Run(":" + port); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newRouter arma el router con los middlewares y todas las rutas de la API.
+func newRouter() *gin.Engine {
 	r := gin.Default()
 	r.Use(simpleCORS())
+	if gzipEnabled {
+		r.Use(gzipResponses(gzipMinBytes))
+	}
+	r.Use(requestTimeoutMiddleware(requestTimeout))
+	r.Use(bodyLimit(maxBodyBytes))
+	r.Use(optionalAuth())
+	// 405 en vez de 404 cuando la ruta existe con otro método (Gin llena el header Allow)
+	r.HandleMethodNotAllowed = true
+	r.NoMethod(methodNotAllowedHandler)
+	r.NoRoute(notFoundHandler)
 
 	// Healthcheck
 	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	r.GET("/version", versionHandler)
 
 	// Users (crear mínimo)
-	r.GET("/api/v1/users", listUserHandler)
-	r.POST("/api/v1/users", createUserHandler)
-	r.PUT("/api/v1/users/:id", updateUserHandler)
+	r.GET("/api/v1/users", requireAuth(), requireRole(roleAdmin), listUserHandler) // paginado; opcional: ?q= (nombre, email o teléfono), ?role_id=, ?is_active=, ?created_from=&created_to=
+	r.POST("/api/v1/users", createUserHandler) // registro público como cliente; solo un admin elige role_id
+	r.POST("/api/v1/users/bulk", requireAuth(), requireRole(roleAdmin), bulkCreateUsersHandler)
+	r.PUT("/api/v1/users/:id", requireAuth(), updateUserHandler) // el propio usuario o admin; ?force=true para desactivar un cliente con pedidos en curso
+	r.GET("/api/v1/users/:id/stats", requireAuth(), userStatsHandler) // el propio cliente o admin
 
 	// Auth básica (login)
 	r.GET("/api/v1/login", basicAuthLoginHandler)
+	r.POST("/api/v1/login", bodyLoginHandler) // JSON o form {username, password}
+	r.POST("/api/v1/token/refresh", refreshTokenHandler) // {refresh_token}; rota el refresh token
+	r.POST("/api/v1/logout", logoutHandler)              // {refresh_token}; revoca la sesión
+	r.GET("/api/v1/me/sessions", requireAuth(), listSessionsHandler)
+	r.DELETE("/api/v1/me/sessions/:id", requireAuth(), revokeSessionHandler)
+	r.PUT("/api/v1/me/password", requireAuth(), changePasswordHandler) // {current_password, new_password}
+	r.POST("/api/v1/password-reset/request", passwordResetRequestHandler) // {username}; siempre 200
+	r.POST("/api/v1/password-reset/confirm", passwordResetConfirmHandler) // {token, password}
+
+	// Seguimiento público por token (sin auth)
+	r.GET("/api/v1/track/:token", trackOrderHandler)
 
 	// Products
-	r.GET("/api/v1/products", listProductsHandler) // opcional: ?customer_id= para precio efectivo
-	r.POST("/api/v1/products", createProductHandler)
-	r.PUT("/api/v1/products/:id", updateProductHandler)
-	r.DELETE("/api/v1/products/:id", deleteProductHandler)
+	r.GET("/api/v1/products", listProductsHandler) // opcional: ?customer_id= para precio efectivo, ?branch_id=, ?in_stock=true, ?q=, ?min_capacity=&max_capacity=, ?address_id= (con customer_id) para la tarifa de delivery
+	r.GET("/api/v1/products/:id", branchScoped(branchTableProducts, "producto no encontrado"), getProductHandler) // ETag / If-None-Match
+	r.GET("/api/v1/products/:id/pending-demand", requireAuth(), requireRole(roleAdmin), pendingDemandHandler) // opcional ?group_by=status, ?branch_id=
+	r.POST("/api/v1/products", requireAuth(), requireRole(roleAdmin), createProductHandler)
+	r.POST("/api/v1/products/:id/clone", requireAuth(), requireRole(roleAdmin), cloneProductHandler) // {name, copy_customer_prices?}
+	r.PUT("/api/v1/products/:id", requireAuth(), requireRole(roleAdmin), branchScoped(branchTableProducts, "producto no encontrado"), updateProductHandler) // If-Match: <version> o el ETag del GET, obligatorio
+	r.DELETE("/api/v1/products/:id", requireAuth(), requireRole(roleAdmin), branchScoped(branchTableProducts, "producto no encontrado"), deleteProductHandler)
+	r.PUT("/api/v1/products/:id/prices/:currency", requireAuth(), requireRole(roleAdmin), upsertProductPriceHandler) // {price}
+	r.DELETE("/api/v1/products/:id/prices/:currency", requireAuth(), requireRole(roleAdmin), deleteProductPriceHandler)
+	r.GET("/api/v1/promotions", requireAuth(), requireRole(roleAdmin), listPromotionsHandler) // ?product_id=, ?current=true
+	r.POST("/api/v1/promotions", requireAuth(), requireRole(roleAdmin), createPromotionHandler)
+	r.DELETE("/api/v1/promotions/:id", requireAuth(), requireRole(roleAdmin), deletePromotionHandler)
 
 	// Customer Prices (precios personalizados)
-	r.GET("/api/v1/customer_prices", listCustomerPricesHandler) // requiere ?customer_id=
-	r.POST("/api/v1/customer_prices", upsertCustomerPriceHandler)
-	r.DELETE("/api/v1/customer_prices", deleteCustomerPriceHandler) // requiere ?customer_id=&product_id=
+	r.GET("/api/v1/customer_prices", requireAuth(), listCustomerPricesHandler) // requiere ?customer_id=; el propio cliente o admin
+	r.POST("/api/v1/customer_prices", requireAuth(), requireRole(roleAdmin), upsertCustomerPriceHandler)
+	r.DELETE("/api/v1/customer_prices", requireAuth(), requireRole(roleAdmin), deleteCustomerPriceHandler) // requiere ?customer_id=&product_id=
+	r.DELETE("/api/v1/customer_prices/:id", requireAuth(), requireRole(roleAdmin), deleteCustomerPriceByIDHandler)
 
 	// Addresses
 	r.GET("/api/v1/addresses", listAddressesHandler) // ?user_id=123
+	r.GET("/api/v1/addresses/autocomplete", autocompleteAddressesHandler) // ?user_id=&q=
+	r.GET("/api/v1/addresses/stats", addressStatsHandler)                 // ?user_id=
 	r.POST("/api/v1/addresses", createAddressHandler)
+	r.PATCH("/api/v1/addresses/:id/default", setDefaultAddressHandler)
+	r.PUT("/api/v1/addresses/defaults", replaceDefaultAddressHandler) // ?user_id=, {default_address_id}
 
 	// Orders
+	orderBranch := branchScoped(branchTableOrders, "pedido no existe") // rutas por :id, solo la sucursal de la petición
 	r.POST("/api/v1/orders", createOrderHandler)
-	r.GET("/api/v1/orders", listOrdersHandler) // ?customer_id=, ?driver_id=
-	r.GET("/api/v1/orders/:id", getOrderHandler)
-	r.PATCH("/api/v1/orders/:id/assign", assignOrderHandler)
-	r.PATCH("/api/v1/orders/:id/status", updateOrderStatusHandler)
-	r.GET("/api/v1/orders/:id/history", listOrderHistoryHandler)
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Println("API escuchando en :" + port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal(err)
-	}
+	r.POST("/api/v1/orders/quote", quoteOrderHandler) // mismo cálculo que la creación, sin insertar
+	r.GET("/api/v1/orders", listOrdersHandler) // ?customer_id=, ?driver_id=, ?q= (nombre del cliente), ?customer_phone=, ?status=, ?scheduled_from=&scheduled_to=, opcional ?money=, ?currency_format=true
+	r.GET("/api/v1/orders/stream", requireFeature(flagOrderStream), requireAuth(), requireRole(roleAdmin, roleDriver), orderStreamHandler) // SSE; ?driver_id=, ?status=
+	r.GET("/api/v1/orders/batch", requireAuth(), batchOrdersHandler) // ?ids=1,2,3 (máx. 50)
+	r.GET("/api/v1/orders/unassigned", requireAuth(), requireRole(roleAdmin), unassignedOrdersHandler) // cola de despacho; paginado, ?branch_id=
+	r.GET("/api/v1/orders/transition-graph", transitionGraphHandler) // opcional: ?lang=es|en
+	r.GET("/api/v1/orders/:id", requireAuth(), orderBranch, getOrderHandler) // cliente dueño, repartidor asignado o admin; opcional: ?expand=customer,address,cancellation, ?money=, ?currency_format=true; ETag / If-None-Match
+	r.PUT("/api/v1/orders/:id/items", requireAuth(), orderBranch, updateOrderItemsHandler) // solo 'por_atender'; cliente dueño o admin
+	r.PATCH("/api/v1/orders/:id/address", requireAuth(), orderBranch, updateOrderAddressHandler) // solo 'por_atender' o 'asignado'; cliente dueño o admin
+	r.PATCH("/api/v1/orders/:id/assign", requireAuth(), requireRole(roleAdmin), orderBranch, assignOrderHandler)
+	r.PATCH("/api/v1/orders/:id/reassign", requireAuth(), requireRole(roleAdmin), reassignOrderHandler)
+	r.PATCH("/api/v1/orders/:id/status", requireAuth(), orderBranch, updateOrderStatusHandler) // cliente dueño, repartidor asignado o admin
+	r.POST("/api/v1/orders/:id/cancel", requireAuth(), orderBranch, cancelOrderHandler) // cliente dueño del pedido, repartidor asignado o admin
+	r.POST("/api/v1/orders/:id/proof", requireAuth(), orderBranch, orderProofHandler) // repartidor asignado o admin
+	r.POST("/api/v1/orders/:id/start-transit", requireAuth(), orderBranch, startTransitHandler) // solo el repartidor asignado
+	r.GET("/api/v1/orders/:id/history", requireAuth(), orderBranch, listOrderHistoryHandler) // cliente dueño, repartidor asignado o admin; paginado; opcional ?new_status=, ?expand=actor
+
+	// Branches (sucursales)
+	r.GET("/api/v1/branches", listBranchesHandler)
+
+	// Drivers
+	r.GET("/api/v1/drivers/:id/today", requireAuth(), driverTodayHandler) // el propio repartidor o admin
+	r.GET("/api/v1/drivers/:id/route", requireAuth(), driverRouteHandler) // opcional: ?from_lat=&from_lng=
+	r.GET("/api/v1/drivers/:id/manifest", requireAuth(), driverManifestHandler) // ?date=YYYY-MM-DD (por defecto hoy)
+	r.PATCH("/api/v1/drivers/:id/availability", requireAuth(), updateDriverAvailabilityHandler)
+	r.GET("/api/v1/drivers/workload", requireAuth(), requireRole(roleAdmin), driverWorkloadHandler)
+
+	// Admin
+	r.POST("/api/v1/admin/delivery-fee-tiers/reload", requireAuth(), requireRole(roleAdmin), reloadDeliveryFeeTiersHandler)
+	r.GET("/api/v1/flags", requireAuth(), requireRole(roleAdmin), listFlagsHandler)
+	r.POST("/api/v1/admin/flags/reload", requireAuth(), requireRole(roleAdmin), reloadFlagsHandler)
+	r.POST("/api/v1/admin/orders/recompute-totals", requireAuth(), requireRole(roleAdmin), recomputeTotalsHandler)
+	r.POST("/api/v1/admin/recompute-subtotals", requireAuth(), requireRole(roleAdmin), recomputeSubtotalsHandler)
+	r.POST("/api/v1/admin/orders/expire-stale", requireAuth(), requireRole(roleAdmin), expireStaleOrdersHandler)
+	r.GET("/api/v1/admin/data-check", requireAuth(), requireRole(roleAdmin), dataCheckHandler)
+	r.GET("/api/v1/admin/stock-reconciliation", requireAuth(), requireRole(roleAdmin), stockReconciliationHandler) // ?branch_id=, ?only_oversold=true
+	r.POST("/api/v1/admin/normalize-phones", requireAuth(), requireRole(roleAdmin), normalizePhonesHandler)
+	r.GET("/debug/db", requireAuth(), requireRole(roleAdmin), dbStatsHandler)
+
+	// Reportes (solo admin; pedidos entregados)
+	r.GET("/api/v1/reports/sales", requireAuth(), requireRole(roleAdmin), salesReportHandler)          // ?from=&to=, ?branch_id=
+	r.GET("/api/v1/reports/top-products", requireAuth(), requireRole(roleAdmin), topProductsReportHandler) // ?from=&to=, ?limit=
+	r.GET("/api/v1/reports/payments", requireAuth(), requireRole(roleAdmin), paymentsReportHandler)        // ?from=&to=, ?branch_id=
+	r.GET("/api/v1/reports/sources", requireAuth(), requireRole(roleAdmin), sourcesReportHandler)          // ?from=&to=, ?branch_id=
+	r.GET("/api/v1/reports/forecast", requireAuth(), requireRole(roleAdmin), forecastReportHandler)        // ?days= (por defecto 7)
+
+	// Desarrollo (solo APP_ENV=development)
+	r.POST("/api/v1/dev/seed", requireDevMode(), seedHandler)
+
+	// Statuses (etiquetas para el frontend)
+	r.GET("/api/v1/statuses", listStatusesHandler) // opcional: ?lang=es|en
+	return r
 }
 
 // ==== MIDDLEWARE CORS MUY SIMPLE (solo para desarrollo) ====
@@ -249,7 +617,7 @@ func simpleCORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Allow-Large-Order")
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
@@ -258,26 +626,229 @@ func simpleCORS() gin.HandlerFunc {
 	}
 }
 
+// ==== LÍMITE DE TAMAÑO DEL BODY ====
+// Lee el body completo con http.MaxBytesReader antes del handler: si excede el
+// límite responde 413 en vez del 400 genérico de BindJSON.
+func bodyLimit(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "cuerpo demasiado grande"})
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "cuerpo demasiado grande"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no se pudo leer el cuerpo"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
 // ==== HANDLERS ====
 
+// 405: Gin ya dejó en Allow los métodos soportados para la ruta
+func methodNotAllowedHandler(c *gin.Context) {
+	allow := c.Writer.Header().Get("Allow")
+	c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "método no permitido", "allow": strings.Split(allow, ", ")})
+}
+
+// Rutas inexistentes: JSON en vez del texto plano "404 page not found" de Gin
+func notFoundHandler(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{"error": gin.H{"code": "NOT_FOUND", "message": "ruta no encontrada"}})
+}
+
+// VERSION (sin autenticación, para ops)
+func versionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"version": version, "commit": commit, "build_time": buildTime})
+}
+
+// ADMIN: corrige los pedidos cuyo total guardado no coincide con subtotal + delivery_fee + tax
+func recomputeTotalsHandler(c *gin.Context) {
+	res, err := db.ExecContext(c.Request.Context(), `UPDATE orders SET total = subtotal + delivery_fee + tax WHERE total <> subtotal + delivery_fee + tax`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	n, _ := res.RowsAffected()
+	c.JSON(http.StatusOK, gin.H{"ok": true, "corrected": n})
+}
+
+// Pedidos por transacción al recalcular subtotales
+const recomputeBatchSize = 200
+
+// ADMIN: recalcula el subtotal de los pedidos no terminados desde order_items.
+// Recorre por id en lotes acotados, cada uno en su propia transacción, para no
+// bloquear la tabla entera. Los pedidos corregidos sincronizan también su total.
+func recomputeSubtotalsHandler(c *gin.Context) {
+	var corrected int64
+	var lastID int64
+	for {
+		var batchEnd sql.NullInt64
+		if err := db.QueryRowContext(c.Request.Context(), `
+            SELECT MAX(id) FROM (
+              SELECT id FROM orders
+              WHERE id > ? AND status NOT IN (`+closedStatusesSQL+`)
+              ORDER BY id LIMIT ?) b`, lastID, recomputeBatchSize).Scan(&batchEnd); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !batchEnd.Valid {
+			break
+		}
+		n, err := recomputeSubtotalsBatch(c.Request.Context(), lastID, batchEnd.Int64)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "corrected": corrected})
+			return
+		}
+		corrected += n
+		lastID = batchEnd.Int64
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "corrected": corrected})
+}
+
+// recomputeSubtotalsBatch corrige los pedidos no terminados con id en (from, to].
+func recomputeSubtotalsBatch(ctx context.Context, from, to int64) (n int64, err error) {
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+        UPDATE orders o
+        JOIN (
+          SELECT o2.id, COALESCE(SUM(oi.qty * oi.unit_price), 0) AS subtotal
+          FROM orders o2
+          LEFT JOIN order_items oi ON oi.order_id = o2.id
+          WHERE o2.id > ? AND o2.id <= ? AND o2.status NOT IN (`+closedStatusesSQL+`)
+          GROUP BY o2.id) calc ON calc.id = o.id
+        SET o.subtotal = calc.subtotal
+        WHERE o.subtotal <> calc.subtotal`, from, to)
+		if err != nil {
+			return err
+		}
+		n, _ = res.RowsAffected()
+		if n > 0 {
+			// Impuesto y total dependen del subtotal; se recalculan en todo el lote
+			_, err = tx.Exec(`UPDATE orders SET tax = `+taxSQL+`, total = subtotal + delivery_fee + tax
+            WHERE id > ? AND id <= ? AND status NOT IN (`+closedStatusesSQL+`)`, from, to)
+		}
+		return err
+	})
+	return n, err
+}
+
+// DEBUG: estado del pool de conexiones, leído en vivo de db.Stats()
+func dbStatsHandler(c *gin.Context) {
+	st := db.Stats()
+	c.JSON(http.StatusOK, gin.H{
+		"max_open_connections": st.MaxOpenConnections,
+		"open_connections":     st.OpenConnections,
+		"in_use":               st.InUse,
+		"idle":                 st.Idle,
+		"wait_count":           st.WaitCount,
+		"wait_duration_ms":     st.WaitDuration.Milliseconds(),
+		"max_idle_closed":      st.MaxIdleClosed,
+		"max_idle_time_closed": st.MaxIdleTimeClosed,
+		"max_lifetime_closed":  st.MaxLifetimeClosed,
+	})
+}
+
+// Campos aceptados en ?sort= de cada listado (nombre público → columna SQL).
+// El desempate siempre es el id, en la misma dirección.
+var (
+	productSortFields = map[string]string{"name": "p.name", "price": "price"}
+	userSortFields    = map[string]string{"created_at": "created_at", "full_name": "full_name"}
+	orderSortFields   = map[string]string{"created_at": "o.created_at", "total": "o.total", "status": "o.status", "scheduled_at": "o.scheduled_at"}
+)
+
 // PRODUCTS
 func listProductsHandler(c *gin.Context) {
 	customerID := c.Query("customer_id")
-	var rows *sql.Rows
-	var err error
+	if !numericQuery(c, "customer_id", "address_id") {
+		return
+	}
 	if customerID != "" {
-		rows, err = db.Query(`
-            SELECT p.id, p.name, p.capacity_liters,
-                   COALESCE(cpp.price, p.price) AS price,
-                   p.is_active
-            FROM products p
-            LEFT JOIN customer_product_prices cpp
-              ON cpp.product_id = p.id AND cpp.customer_id = ? AND cpp.is_active = TRUE
-            WHERE p.is_active = TRUE
-            ORDER BY p.id`, customerID)
-	} else {
-		rows, err = db.Query(`SELECT id, name, capacity_liters, price, is_active FROM products WHERE is_active=TRUE ORDER BY id`)
+		cid, _ := strconv.ParseInt(customerID, 10, 64)
+		if !canSeeCustomerPrices(c, cid) {
+			return
+		}
+	}
+	branchID, ok := branchFromRequest(c)
+	if !ok {
+		return
+	}
+	orderBy, ok := orderByClause(c, productSortFields, "p.id", "ASC")
+	if !ok {
+		return
+	}
+	currency, ok := requestedCurrency(c)
+	if !ok {
+		return
+	}
+	// ?address_id= (con customer_id) agrega la tarifa de delivery a esa dirección
+	var deliveryFee *float64
+	if addressID := c.Query("address_id"); addressID != "" {
+		if customerID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "address_id requiere customer_id"})
+			return
+		}
+		var lat, lng *float64
+		err := db.QueryRowContext(c.Request.Context(), `SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`, addressID, customerID).Scan(&lat, &lng)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "address_id inválido para este cliente"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		fee := deliveryFeeFor(lat, lng)
+		deliveryFee = &fee
+	}
+	// ?in_stock=true oculta los productos sin stock (stock NULL = ilimitado, se muestran)
+	filters := ""
+	var filterArgs []any
+	if v := c.Query("in_stock"); v != "" {
+		inStock, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "in_stock debe ser true o false"})
+			return
+		}
+		if inStock {
+			filters += " AND (p.stock IS NULL OR p.stock > 0)"
+		}
+	}
+	// ?min_capacity= / ?max_capacity= en litros; con rango, los productos sin capacidad quedan fuera
+	for _, f := range []struct{ param, op string }{{"min_capacity", ">="}, {"max_capacity", "<="}} {
+		v := c.Query(f.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": f.param + " debe ser un número >= 0"})
+			return
+		}
+		filters += " AND p.capacity_liters " + f.op + " ?"
+		filterArgs = append(filterArgs, n)
+	}
+	if q := normalizeSearch(c.Query("q")); q != "" {
+		filters += " AND p.name COLLATE " + searchCollation + " LIKE ?"
+		filterArgs = append(filterArgs, likeContains(q))
 	}
+	// Sin customer_id el JOIN de precios del cliente no encuentra nada: precio de promoción o base
+	rows, err := db.QueryContext(c.Request.Context(), `
+            SELECT p.id, p.name, p.capacity_liters,
+                   `+effectivePriceSQL+` AS price, `+productCurrencyColumns+`,
+                   p.is_active, p.min_qty, p.qty_multiple, p.branch_id, p.stock, `+appliedPromoColumns+`
+            FROM products p`+customerPriceJoin+activePromoJoin+`
+            WHERE p.is_active = TRUE AND p.branch_id = ?`+filters+orderBy, append([]any{customerID, branchID}, filterArgs...)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -286,15 +857,83 @@ func listProductsHandler(c *gin.Context) {
 	var items []Product
 	for rows.Next() {
 		var p Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.CapacityLiters, &p.Price, &p.IsActive); err != nil {
+		var promo promoScan
+		if err := rows.Scan(append([]any{&p.ID, &p.Name, &p.CapacityLiters, &p.Price, &p.priceSource, &p.Currency, &p.IsActive, &p.MinQty, &p.QtyMultiple, &p.BranchID, &p.Stock}, promo.dest()...)...); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		p.Promotion = promo.applied()
 		items = append(items, p)
 	}
+	// Todos los precios quedan en una sola moneda (la pedida o la base) para que
+	// el orden por precio y price_with_delivery no mezclen monedas
+	if err := localizeProducts(c.Request.Context(), items, currency); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("sort") == "price" {
+		sortProductsByPrice(items, strings.EqualFold(c.Query("order"), "desc"))
+	}
+	if deliveryFee != nil {
+		// La tarifa de delivery está en la moneda base
+		fee, ok := convertPrice(*deliveryFee, baseCurrency, currency)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "sin tasa de cambio para " + currency})
+			return
+		}
+		deliveryFee = &fee
+		for i := range items {
+			total := items[i].Price + *deliveryFee
+			items[i].DeliveryFee, items[i].PriceWithDelivery = deliveryFee, &total
+		}
+	}
 	c.JSON(http.StatusOK, items)
 }
 
+// Un producto (incluye inactivos); opcional ?customer_id= para precio efectivo y
+// ?currency= para verlo en otra moneda
+func getProductHandler(c *gin.Context) {
+	id := c.Param("id")
+	customerID := c.Query("customer_id")
+	if !numericQuery(c, "customer_id") {
+		return
+	}
+	if customerID != "" {
+		cid, _ := strconv.ParseInt(customerID, 10, 64)
+		if !canSeeCustomerPrices(c, cid) {
+			return
+		}
+	}
+	currency, ok := requestedCurrency(c)
+	if !ok {
+		return
+	}
+	var p Product
+	var promo promoScan
+	err := db.QueryRowContext(c.Request.Context(), `
+        SELECT p.id, p.name, p.capacity_liters,
+               `+effectivePriceSQL+` AS price, `+productCurrencyColumns+`,
+               p.is_active, p.min_qty, p.qty_multiple, p.branch_id, p.stock, p.version, `+appliedPromoColumns+`
+        FROM products p`+customerPriceJoin+activePromoJoin+`
+        WHERE p.id = ?`, customerID, id).
+		Scan(append([]any{&p.ID, &p.Name, &p.CapacityLiters, &p.Price, &p.priceSource, &p.Currency, &p.IsActive, &p.MinQty, &p.QtyMultiple, &p.BranchID, &p.Stock, &p.Version}, promo.dest()...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "producto no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	p.Promotion = promo.applied()
+	items := []Product{p}
+	if err := localizeProducts(c.Request.Context(), items, currency); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondWithVersionETag(c, p.Version, items[0])
+}
+
 func createProductHandler(c *gin.Context) {
 	var req CreateProductReq
 	if err := c.BindJSON(&req); err != nil {
@@ -305,7 +944,24 @@ func createProductHandler(c *gin.Context) {
 	if req.IsActive != nil {
 		active = *req.IsActive
 	}
-	res, err := db.Exec(`INSERT INTO products(name, capacity_liters, price, is_active) VALUES (?,?,?,?)`, req.Name, req.CapacityLiters, req.Price, active)
+	if !validQtyConstraints(req.MinQty, req.QtyMultiple) {
+		respondInvalid(c, "min_qty", "min_qty y qty_multiple deben ser mayores a 0")
+		return
+	}
+	branchID, ok := resolveBranch(c, req.BranchID)
+	if !ok {
+		return
+	}
+	if req.Stock != nil && *req.Stock < 0 {
+		respondInvalid(c, "stock", "stock no puede ser negativo")
+		return
+	}
+	currency, ok := currencyParam(req.Currency)
+	if !ok {
+		respondInvalid(c, "currency", "currency no soportada")
+		return
+	}
+	res, err := db.ExecContext(c.Request.Context(), `INSERT INTO products(name, capacity_liters, price, currency, is_active, stock, min_qty, qty_multiple, branch_id) VALUES (?,?,?,?,?,?,?,?,?)`, req.Name, req.CapacityLiters, req.Price, currency, active, req.Stock, req.MinQty, req.QtyMultiple, branchID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -314,8 +970,91 @@ func createProductHandler(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
+type CloneProductReq struct {
+	Name               string `json:"name"`
+	CopyCustomerPrices bool   `json:"copy_customer_prices"` // opcional: copia también los precios por cliente activos
+}
+
+// POST /api/v1/products/:id/clone (admin)
+// Crea un producto nuevo con el nombre dado y la capacidad, precio, estado,
+// restricciones de cantidad y sucursal del original. El stock no se copia: si el
+// original lleva stock el clon empieza en 0, si no queda ilimitado.
+func cloneProductHandler(c *gin.Context) {
+	srcID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	var req CloneProductReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondInvalid(c, "name", "name requerido")
+		return
+	}
+
+	var p Product
+	var copied int64
+	err = withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		p = Product{}
+		err := tx.QueryRow(`SELECT capacity_liters, price, currency, is_active, min_qty, qty_multiple, branch_id, stock FROM products WHERE id=?`, srcID).
+			Scan(&p.CapacityLiters, &p.Price, &p.Currency, &p.IsActive, &p.MinQty, &p.QtyMultiple, &p.BranchID, &p.Stock)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "producto no encontrado"})
+			return errResponded
+		}
+		if err != nil {
+			return err
+		}
+		if p.Stock != nil {
+			zero := 0
+			p.Stock = &zero
+		}
+		p.Name = req.Name
+		res, err := tx.Exec(`INSERT INTO products(name, capacity_liters, price, currency, is_active, stock, min_qty, qty_multiple, branch_id) VALUES (?,?,?,?,?,?,?,?,?)`,
+			p.Name, p.CapacityLiters, p.Price, p.Currency, p.IsActive, p.Stock, p.MinQty, p.QtyMultiple, p.BranchID)
+		if err != nil {
+			return err
+		}
+		p.ID, _ = res.LastInsertId()
+		p.Version = 1
+		// Los precios directos en otras monedas son parte del precio del producto
+		if _, err := tx.Exec(`INSERT INTO product_prices(product_id, currency, price) SELECT ?, currency, price FROM product_prices WHERE product_id=?`, p.ID, srcID); err != nil {
+			return err
+		}
+
+		copied = 0
+		if req.CopyCustomerPrices {
+			res, err := tx.Exec(`INSERT INTO customer_product_prices(customer_id, product_id, price, currency, is_active)
+            SELECT customer_id, ?, price, currency, is_active FROM customer_product_prices WHERE product_id=? AND is_active=TRUE`, p.ID, srcID)
+			if err != nil {
+				return err
+			}
+			copied, _ = res.RowsAffected()
+		}
+		return nil
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"product": p, "cloned_from": srcID, "customer_prices_copied": copied})
+}
+
+// PUT con control de concurrencia optimista: If-Match debe traer la version actual
+// del producto (la de GET /products/:id). Si otro admin lo editó antes → 412.
 func updateProductHandler(c *gin.Context) {
 	id := c.Param("id")
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 	var req CreateProductReq
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
@@ -327,23 +1066,54 @@ func updateProductHandler(c *gin.Context) {
 		active = *req.IsActive
 	}
 
-	res, err := db.Exec(`UPDATE products SET name=?, capacity_liters=?, price=?, is_active=? WHERE id=?`, req.Name, req.CapacityLiters, req.Price, active, id)
+	if !validQtyConstraints(req.MinQty, req.QtyMultiple) {
+		respondInvalid(c, "min_qty", "min_qty y qty_multiple deben ser mayores a 0")
+		return
+	}
+
+	if req.Stock != nil && *req.Stock < 0 {
+		respondInvalid(c, "stock", "stock no puede ser negativo")
+		return
+	}
+
+	// currency vacía = mantener la actual
+	var currency *string
+	if req.Currency != "" {
+		cur, ok := currencyParam(req.Currency)
+		if !ok {
+			respondInvalid(c, "currency", "currency no soportada")
+			return
+		}
+		currency = &cur
+	}
+
+	res, err := db.ExecContext(c.Request.Context(), `UPDATE products SET name=?, capacity_liters=?, price=?, currency=COALESCE(?, currency), is_active=?, stock=COALESCE(?, stock), min_qty=?, qty_multiple=?, version=version+1 WHERE id=? AND version=?`, req.Name, req.CapacityLiters, req.Price, currency, active, req.Stock, req.MinQty, req.QtyMultiple, id, version)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "producto no encontrado"})
+		// Sin filas: o no existe o la versión ya no es la actual
+		var exists bool
+		if err := db.QueryRowContext(c.Request.Context(), `SELECT EXISTS(SELECT 1 FROM products WHERE id=?)`, id).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "producto no encontrado"})
+			return
+		}
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "el recurso fue modificado"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"ok": true})
+	c.JSON(http.StatusOK, gin.H{"ok": true, "version": version + 1})
 }
 
 func deleteProductHandler(c *gin.Context) {
 	id := c.Param("id")
 	// Borrado lógico para no romper historiales y joins: is_active = FALSE
-	res, err := db.Exec(`UPDATE products SET is_active=FALSE WHERE id=?`, id)
+	res, err := db.ExecContext(c.Request.Context(), `UPDATE products SET is_active=FALSE WHERE id=?`, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -357,19 +1127,27 @@ func deleteProductHandler(c *gin.Context) {
 }
 
 // USERS
+// Sin credenciales de admin el alta es un registro de cliente: role_id se ignora.
 func createUserHandler(c *gin.Context) {
 	var req CreateUserReq
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondUserBindError(c, err)
+		return
+	}
+	if u, ok := currentUser(c); !ok || u.RoleID != roleAdmin {
+		req.RoleID = roleCustomer
+	}
+	if verr := validateCreateUser(c.Request.Context(), &req); verr != nil {
+		c.JSON(verr.Status, verr)
 		return
 	}
-	if req.FullName == "" || req.RoleID == 0 || req.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "full_name, role_id y password requeridos"})
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	// Para MVP no hasheamos (peligroso en producción). Dejamos password_hash como el password directo.
-	res, err := db.Exec(`INSERT INTO users(role_id, full_name, phone, email, num_doc, password_hash, is_active) VALUES (?,?,?,?,?,?,TRUE)`,
-		req.RoleID, req.FullName, req.Phone, req.Email, req.NumDoc, req.Password)
+	res, err := db.ExecContext(c.Request.Context(), `INSERT INTO users(role_id, full_name, phone, email, num_doc, password_hash, is_active, timezone) VALUES (?,?,?,?,?,?,TRUE,?)`,
+		req.RoleID, req.FullName, req.Phone, req.Email, req.NumDoc, hash, req.Timezone)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -378,15 +1156,235 @@ func createUserHandler(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
-func updateUserHandler(c *gin.Context) {
-	id := c.Param("id")
+// userError es el resultado de una validación fallida de usuario.
+type userError struct {
+	Status int      `json:"-"`
+	Error  string   `json:"error"`
+	Field  string   `json:"field,omitempty"`
+	Rules  []string `json:"rules,omitempty"`
+}
+
+// validateCreateUser aplica las validaciones de alta (normaliza num_doc en req).
+func validateCreateUser(ctx context.Context, req *CreateUserReq) *userError {
+	switch {
+	case req.FullName == "":
+		return &userError{Status: http.StatusUnprocessableEntity, Field: "full_name", Error: "full_name requerido"}
+	case req.RoleID == 0:
+		return &userError{Status: http.StatusUnprocessableEntity, Field: "role_id", Error: "role_id requerido"}
+	case !validRole(req.RoleID):
+		return &userError{Status: http.StatusUnprocessableEntity, Field: "role_id", Error: errRoleInvalid}
+	case req.Password == "":
+		return &userError{Status: http.StatusUnprocessableEntity, Field: "password", Error: "password requerido"}
+	}
+	if failed := validatePassword(req.Password); len(failed) > 0 {
+		return &userError{Status: http.StatusUnprocessableEntity, Error: "contraseña no cumple la política", Field: "password", Rules: failed}
+	}
+	req.Phone = normalizePhoneInput(req.Phone)
+	req.NumDoc = normalizeNumDoc(req.NumDoc)
+	if req.NumDoc != nil && !validNumDoc(*req.NumDoc) {
+		return &userError{Status: http.StatusUnprocessableEntity, Field: "num_doc", Error: fmt.Sprintf("num_doc inválido: debe tener entre %d y %d caracteres", numDocMinLen, numDocMaxLen)}
+	}
+	if taken, err := numDocTaken(ctx, req.NumDoc, ""); err != nil {
+		return &userError{Status: http.StatusInternalServerError, Error: err.Error()}
+	} else if taken {
+		return &userError{Status: http.StatusConflict, Error: "num_doc ya registrado"}
+	}
+	req.Timezone = trimOptional(req.Timezone)
+	if !validTimezone(req.Timezone) {
+		return &userError{Status: http.StatusUnprocessableEntity, Field: "timezone", Error: "timezone inválida: debe ser un nombre IANA (ej. America/Lima)"}
+	}
+	return nil
+}
+
+const errRoleInvalid = "role_id inválido: debe ser 1 (encargado), 2 (repartidor) o 3 (cliente)"
+
+// respondUserBindError: un role_id que no es un entero (ej. "2", true, 2.5) es un
+// error de validación del campo (422); cualquier otro JSON mal formado sigue siendo 400.
+// Requiere ShouldBindJSON: BindJSON ya habría escrito el 400.
+func respondUserBindError(c *gin.Context, err error) {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && (typeErr.Field == "role_id" || strings.HasSuffix(typeErr.Field, ".role_id")) {
+		respondInvalid(c, "role_id", "role_id debe ser un número entero")
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+}
+
+// validTimezone acepta nil o un nombre IANA real. "Local" no se acepta porque
+// depende del servidor.
+func validTimezone(tz *string) bool {
+	if tz == nil {
+		return true
+	}
+	if *tz == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(*tz)
+	return err == nil
+}
+
+// Resultado por fila de POST /api/v1/users/bulk
+type BulkUserResult struct {
+	Index int      `json:"index"`
+	ID    *int64   `json:"id,omitempty"`
+	Error string   `json:"error,omitempty"`
+	Field string   `json:"field,omitempty"`
+	Rules []string `json:"rules,omitempty"`
+}
+
+const maxBulkUsers = 500
+
+// Alta masiva: todo o nada. Si alguna fila falla no se inserta ninguna y se
+// devuelve el detalle de todas las filas con error.
+func bulkCreateUsersHandler(c *gin.Context) {
+	var reqs []CreateUserReq
+	if err := c.ShouldBindJSON(&reqs); err != nil {
+		respondUserBindError(c, err)
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxBulkUsers {
+		respondInvalid(c, "items", fmt.Sprintf("se requieren entre 1 y %d usuarios", maxBulkUsers))
+		return
+	}
+
+	results := make([]BulkUserResult, len(reqs))
+	failed := false
+	// Duplicados dentro del mismo lote (además del chequeo contra la BD)
+	seen := map[string]int{}
+	for i := range reqs {
+		results[i].Index = i
+		if verr := validateCreateUser(c.Request.Context(), &reqs[i]); verr != nil {
+			if verr.Status == http.StatusInternalServerError {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": verr.Error})
+				return
+			}
+			results[i].Error, results[i].Field, results[i].Rules = verr.Error, verr.Field, verr.Rules
+			failed = true
+			continue
+		}
+		for field, v := range map[string]*string{"email": reqs[i].Email, "phone": reqs[i].Phone, "num_doc": reqs[i].NumDoc} {
+			if v == nil || *v == "" {
+				continue
+			}
+			key := field + ":" + strings.ToLower(*v)
+			if j, dup := seen[key]; dup {
+				results[i].Error, results[i].Field = fmt.Sprintf("%s duplicado en el lote (fila %d)", field, j), field
+				failed = true
+				break
+			}
+			seen[key] = i
+		}
+	}
+	if failed {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "lote inválido, no se creó ningún usuario", "results": results})
+		return
+	}
+
+	// bcrypt es lento: se hashea antes de abrir la transacción (y una sola vez si se reintenta)
+	hashes := make([]string, len(reqs))
+	for i, req := range reqs {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hashes[i] = hash
+	}
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		for i, req := range reqs {
+			res, err := tx.Exec(`INSERT INTO users(role_id, full_name, phone, email, num_doc, password_hash, is_active, timezone) VALUES (?,?,?,?,?,?,TRUE,?)`,
+				req.RoleID, req.FullName, req.Phone, req.Email, req.NumDoc, hashes[i], req.Timezone)
+			if isRetryableTxError(err) {
+				return err
+			}
+			if err != nil {
+				// Ej. email ya existente en la BD: se reporta la fila y se revierte todo
+				results[i].Error = err.Error()
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "lote inválido, no se creó ningún usuario", "results": results})
+				return errResponded
+			}
+			id, _ := res.LastInsertId()
+			results[i].ID = &id
+		}
+		return nil
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"ok": true, "results": results})
+}
+
+// Desactivar un cliente con pedidos en curso responde 409 salvo ?force=true; con
+// force se desactiva igual e informa active_orders. Un usuario puede editarse a sí
+// mismo, pero cambiar role_id o is_active es solo de admin.
+func updateUserHandler(c *gin.Context) {
+	id := c.Param("id")
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	if !isSelfOrAdmin(c, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return
+	}
+	force := false
+	if v := c.Query("force"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "force debe ser true o false"})
+			return
+		}
+		force = b
+	}
 	var req UpdateUserReq
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondUserBindError(c, err)
+		return
+	}
+	if req.FullName == "" {
+		respondInvalid(c, "full_name", "full_name requerido")
+		return
+	}
+	if req.RoleID == 0 {
+		respondInvalid(c, "role_id", "role_id requerido")
+		return
+	}
+	if !validRole(req.RoleID) {
+		respondInvalid(c, "role_id", errRoleInvalid)
+		return
+	}
+	if u, _ := currentUser(c); u.RoleID != roleAdmin &&
+		(req.RoleID != u.RoleID || (req.IsActive != nil && *req.IsActive != u.IsActive)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "solo un admin puede cambiar role_id o is_active"})
+		return
+	}
+	if req.Password != nil {
+		if failed := validatePassword(*req.Password); len(failed) > 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "contraseña no cumple la política", "field": "password", "rules": failed})
+			return
+		}
+	}
+	req.Phone = normalizePhoneInput(req.Phone)
+	req.NumDoc = normalizeNumDoc(req.NumDoc)
+	if req.NumDoc != nil && !validNumDoc(*req.NumDoc) {
+		respondInvalid(c, "num_doc", fmt.Sprintf("num_doc inválido: debe tener entre %d y %d caracteres", numDocMinLen, numDocMaxLen))
+		return
+	}
+	if taken, err := numDocTaken(c.Request.Context(), req.NumDoc, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "num_doc ya registrado"})
 		return
 	}
-	if req.FullName == "" || req.RoleID == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "full_name y role_id requeridos"})
+	req.Timezone = trimOptional(req.Timezone)
+	if !validTimezone(req.Timezone) {
+		respondInvalid(c, "timezone", "timezone inválida: debe ser un nombre IANA (ej. America/Lima)")
 		return
 	}
 
@@ -395,32 +1393,224 @@ func updateUserHandler(c *gin.Context) {
 		active = *req.IsActive
 	}
 
-	var (
-		res sql.Result
-		err error
-	)
+	var hash string
 	if req.Password != nil {
-		res, err = db.Exec(`UPDATE users SET role_id=?, full_name=?, phone=?, email=?, num_doc=?, password_hash=?, is_active=? WHERE id=?`,
-			req.RoleID, req.FullName, req.Phone, req.Email, req.NumDoc, *req.Password, active, id)
-	} else {
-		res, err = db.Exec(`UPDATE users SET role_id=?, full_name=?, phone=?, email=?, num_doc=?, is_active=? WHERE id=?`,
-			req.RoleID, req.FullName, req.Phone, req.Email, req.NumDoc, active, id)
+		h, err := hashPassword(*req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hash = h
+	}
+
+	var activeOrders int
+	err = withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		if err := ensureAdminRemains(tx, id, req.RoleID, active); err != nil {
+			if errors.Is(err, errLastAdmin) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return errResponded
+			}
+			return err
+		}
+		var err error
+		if activeOrders, err = openOrdersOnDeactivation(tx, id, active); err != nil {
+			return err
+		}
+		if activeOrders > 0 && !force {
+			c.JSON(http.StatusConflict, gin.H{"error": "el cliente tiene pedidos en curso; use ?force=true para desactivarlo igual", "active_orders": activeOrders})
+			return errResponded
+		}
+
+		var res sql.Result
+		if req.Password != nil {
+			res, err = tx.Exec(`UPDATE users SET role_id=?, full_name=?, phone=?, email=?, num_doc=?, password_hash=?, is_active=?, timezone=? WHERE id=?`,
+				req.RoleID, req.FullName, req.Phone, req.Email, req.NumDoc, hash, active, req.Timezone, id)
+		} else {
+			res, err = tx.Exec(`UPDATE users SET role_id=?, full_name=?, phone=?, email=?, num_doc=?, is_active=?, timezone=? WHERE id=?`,
+				req.RoleID, req.FullName, req.Phone, req.Email, req.NumDoc, active, req.Timezone, id)
+		}
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+			return errResponded
+		}
+		return nil
+	})
+	if errors.Is(err, errResponded) {
+		return
 	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+	if activeOrders > 0 {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "active_orders": activeOrders})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// openOrdersOnDeactivation cuenta los pedidos sin terminar (ni entregado ni
+// cancelado) de un cliente activo que el cambio dejaría inactivo. Devuelve 0 si
+// no es un cliente, si ya estaba inactivo o si sigue activo.
+func openOrdersOnDeactivation(tx *sql.Tx, userID string, newActive bool) (int, error) {
+	if newActive {
+		return 0, nil
+	}
+	var role int8
+	var active bool
+	err := tx.QueryRow(`SELECT role_id, is_active FROM users WHERE id=? FOR UPDATE`, userID).Scan(&role, &active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil || role != roleCustomer || !active {
+		return 0, err
+	}
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM orders WHERE customer_id=? AND status NOT IN (`+closedStatusesSQL+`)`, userID).Scan(&n)
+	return n, err
+}
+
+var errLastAdmin = errors.New("debe existir al menos un administrador activo")
+
+// ensureAdminRemains devuelve errLastAdmin si el cambio dejaría sin ningún admin
+// activo (degradar o desactivar al último). Bloquea las filas de admins activos
+// con FOR UPDATE para que dos cambios simultáneos no pasen ambos el control.
+func ensureAdminRemains(tx *sql.Tx, userID string, newRole int8, newActive bool) error {
+	if newRole == roleAdmin && newActive {
+		return nil
+	}
+	var role int8
+	var active bool
+	err := tx.QueryRow(`SELECT role_id, is_active FROM users WHERE id=? FOR UPDATE`, userID).Scan(&role, &active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if role != roleAdmin || !active {
+		return nil
+	}
+	var others int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM (SELECT id FROM users WHERE role_id=? AND is_active=TRUE AND id<>? FOR UPDATE) a`, roleAdmin, userID).Scan(&others); err != nil {
+		return err
+	}
+	if others == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+// CustomerStats es el valor acumulado de un cliente (solo pedidos entregados).
+type CustomerStats struct {
+	CustomerID     int64        `json:"customer_id"`
+	DeliveredCount int          `json:"delivered_count"`
+	TotalRevenue   float64      `json:"total_revenue"`
+	AvgOrderValue  float64      `json:"avg_order_value"`
+	LastOrderAt    sql.NullTime `json:"last_order_at"`
+}
+
+// GET /api/v1/users/:id/stats: el propio cliente o un admin
+func userStatsHandler(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	if !isSelfOrAdmin(c, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return
+	}
+	var role int8
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT role_id FROM users WHERE id=?`, userID).Scan(&role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "usuario no encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if role != roleCustomer {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "el usuario no es cliente"})
+		return
+	}
+
+	st := CustomerStats{CustomerID: userID}
+	if err := db.QueryRowContext(c.Request.Context(), `
+        SELECT COUNT(*), COALESCE(SUM(total), 0), MAX(created_at)
+        FROM orders
+        WHERE customer_id=? AND status='entregado'`, userID).Scan(&st.DeliveredCount, &st.TotalRevenue, &st.LastOrderAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if st.DeliveredCount > 0 {
+		st.AvgOrderValue = math.Round(st.TotalRevenue/float64(st.DeliveredCount)*100) / 100
+	}
+	c.JSON(http.StatusOK, st)
+}
+
 // USERS
 func listUserHandler(c *gin.Context) {
-	rows, err := db.Query(`select id, role_id, full_name, phone, email, num_doc from users order by id`)
+	page, pageSize, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+	if !numericQuery(c, "role_id") {
+		return
+	}
+	createdFrom, createdTo, ok := dateWindowQuery(c, "created_from", "created_to")
+	if !ok {
+		return
+	}
+	var where []string
+	var args []any
+	if q := normalizeSearch(c.Query("q")); q != "" {
+		// Sin distinguir tildes ni mayúsculas: "jose" encuentra "José"
+		where = append(where, `(full_name COLLATE `+searchCollation+` LIKE ? or email LIKE ? or phone LIKE ?)`)
+		pattern := likeContains(q)
+		args = append(args, pattern, pattern, pattern)
+	}
+	if v := c.Query("role_id"); v != "" {
+		where = append(where, "role_id=?")
+		args = append(args, v)
+	}
+	switch c.Query("is_active") {
+	case "":
+	case "true":
+		where = append(where, "is_active=TRUE")
+	case "false":
+		where = append(where, "is_active=FALSE")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "is_active debe ser true o false"})
+		return
+	}
+	if createdFrom != nil {
+		where = append(where, "created_at>=?")
+		args = append(args, *createdFrom)
+	}
+	if createdTo != nil {
+		where = append(where, "created_at<?")
+		args = append(args, *createdTo)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " where " + strings.Join(where, " and ")
+	}
+	orderBy, ok := orderByClause(c, userSortFields, "id", "ASC")
+	if !ok {
+		return
+	}
+
+	var total int
+	if err := db.QueryRowContext(c.Request.Context(), `select count(*) from users`+cond, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `select id, role_id, full_name, phone, email, num_doc, is_active, created_at, timezone from users`+cond+orderBy+` limit ? offset ?`,
+		append(args, pageSize, (page-1)*pageSize)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -429,13 +1619,15 @@ func listUserHandler(c *gin.Context) {
 	var items []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.RoleID, &u.FullName, &u.Phone, &u.Email, &u.NumDoc); err != nil {
+		if err := rows.Scan(&u.ID, &u.RoleID, &u.FullName, &u.Phone, &u.Email, &u.NumDoc, &u.IsActive, &u.CreatedAt, &u.Timezone); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		u.Phone = displayPhone(u.Phone)
 		items = append(items, u)
 	}
-	c.JSON(http.StatusOK, items)
+	setLinkHeader(c, page, pageSize, total)
+	c.JSON(http.StatusOK, newPaginated(items, page, pageSize, total))
 }
 
 // CUSTOMER PRICES
@@ -445,8 +1637,15 @@ func listCustomerPricesHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id requerido"})
 		return
 	}
-	rows, err := db.Query(`
-        SELECT customer_id, product_id, price, is_active
+	if !numericQuery(c, "customer_id") {
+		return
+	}
+	cid, _ := strconv.ParseInt(customerID, 10, 64)
+	if !canSeeCustomerPrices(c, cid) {
+		return
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT id, customer_id, product_id, price, currency, is_active
         FROM customer_product_prices
         WHERE customer_id = ?
         ORDER BY product_id`, customerID)
@@ -458,7 +1657,7 @@ func listCustomerPricesHandler(c *gin.Context) {
 	var list []CustomerPrice
 	for rows.Next() {
 		var cp CustomerPrice
-		if err := rows.Scan(&cp.CustomerID, &cp.ProductID, &cp.Price, &cp.IsActive); err != nil {
+		if err := rows.Scan(&cp.ID, &cp.CustomerID, &cp.ProductID, &cp.Price, &cp.Currency, &cp.IsActive); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -473,30 +1672,39 @@ func upsertCustomerPriceHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
 		return
 	}
-	if req.CustomerID == 0 || req.ProductID == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id y product_id requeridos"})
+	if req.CustomerID == 0 {
+		respondInvalid(c, "customer_id", "customer_id requerido")
+		return
+	}
+	if req.ProductID == 0 {
+		respondInvalid(c, "product_id", "product_id requerido")
 		return
 	}
 	active := true
 	if req.IsActive != nil {
 		active = *req.IsActive
 	}
+	currency, ok := currencyParam(req.Currency)
+	if !ok {
+		respondInvalid(c, "currency", "currency no soportada")
+		return
+	}
 	// Validar que el producto exista y esté activo (MVP: existencia basta)
 	var exists int
-	if err := db.QueryRow(`SELECT COUNT(1) FROM products WHERE id=?`, req.ProductID).Scan(&exists); err != nil || exists == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "product_id inválido"})
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT COUNT(1) FROM products WHERE id=?`, req.ProductID).Scan(&exists); err != nil || exists == 0 {
+		respondInvalid(c, "product_id", "product_id inválido")
 		return
 	}
-	if err := db.QueryRow(`SELECT COUNT(1) FROM users WHERE id=?`, req.CustomerID).Scan(&exists); err != nil || exists == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id inválido"})
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT COUNT(1) FROM users WHERE id=?`, req.CustomerID).Scan(&exists); err != nil || exists == 0 {
+		respondInvalid(c, "customer_id", "customer_id inválido")
 		return
 	}
-	// Upsert
-	_, err := db.Exec(`
-        INSERT INTO customer_product_prices(customer_id, product_id, price, is_active)
-        VALUES (?,?,?,?)
-        ON DUPLICATE KEY UPDATE price=VALUES(price), is_active=VALUES(is_active)`,
-		req.CustomerID, req.ProductID, req.Price, active)
+	// Upsert: el UNIQUE (customer_id, product_id) (migraciones 012 y 031) deja una sola fila por par
+	_, err := db.ExecContext(c.Request.Context(), `
+        INSERT INTO customer_product_prices(customer_id, product_id, price, currency, is_active)
+        VALUES (?,?,?,?,?)
+        ON DUPLICATE KEY UPDATE price=VALUES(price), currency=VALUES(currency), is_active=VALUES(is_active)`,
+		req.CustomerID, req.ProductID, req.Price, currency, active)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -511,44 +1719,104 @@ func deleteCustomerPriceHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id y product_id requeridos"})
 		return
 	}
-	_, err := db.Exec(`DELETE FROM customer_product_prices WHERE customer_id=? AND product_id=?`, customerID, productID)
+	if !numericQuery(c, "customer_id", "product_id") {
+		return
+	}
+	_, err := db.ExecContext(c.Request.Context(), `DELETE FROM customer_product_prices WHERE customer_id=? AND product_id=?`, customerID, productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func deleteCustomerPriceByIDHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	res, err := db.ExecContext(c.Request.Context(), `DELETE FROM customer_product_prices WHERE id=?`, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "precio personalizado no encontrado"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
 // AUTH BÁSICA
 func basicAuthLoginHandler(c *gin.Context) {
-	username, password, ok := c.Request.BasicAuth()
+	// optionalAuth ya validó las credenciales (401 si eran inválidas)
+	u, ok := currentUser(c)
 	if !ok {
 		c.Header("WWW-Authenticate", "Basic realm=Login")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "credenciales requeridas"})
 		return
 	}
+	respondLogin(c, u)
+}
 
-	var u User
-	var stored string
-	var active bool
-	err := db.QueryRow(`SELECT id, role_id, full_name, phone, email, num_doc, password_hash, is_active FROM users WHERE (email=? OR phone=? OR num_doc=?) LIMIT 1`, username, username, username).
-		Scan(&u.ID, &u.RoleID, &u.FullName, &u.Phone, &u.Email, &u.NumDoc, &stored, &active)
-	if errors.Is(err, sql.ErrNoRows) {
-		c.Header("WWW-Authenticate", "Basic realm=Login")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "usuario o contraseña inválidos"})
+// respondLogin devuelve el usuario, un token de acceso para usar como Bearer y un
+// refresh token (sesión nueva) para renovarlo con POST /api/v1/token/refresh.
+// Si el usuario supera MAX_SESSIONS_PER_USER se cierran sus sesiones más antiguas.
+func respondLogin(c *gin.Context, u User) {
+	u.Phone = displayPhone(u.Phone)
+	token, exp, err := issueToken(u)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	refresh, refreshExp, err := startSession(c.Request.Context(), u.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if !active || stored != password {
-		c.Header("WWW-Authenticate", "Basic realm=Login")
+	c.JSON(http.StatusOK, gin.H{
+		"ok": true, "user": u,
+		"token": token, "token_type": "Bearer", "expires_at": exp,
+		"refresh_token": refresh, "refresh_token_expires_at": refreshExp,
+	})
+}
+
+// Login por body para formularios HTML antiguos y clientes sin Basic:
+// JSON o form con {username, password}. Si viene Authorization Basic, se usa ese.
+func bodyLoginHandler(c *gin.Context) {
+	if u, ok := currentUser(c); ok {
+		respondLogin(c, u)
+		return
+	}
+	var req LoginReq
+	switch c.ContentType() {
+	case gin.MIMEJSON:
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+			return
+		}
+	case gin.MIMEPOSTForm, gin.MIMEMultipartPOSTForm:
+		req.Username, req.Password = c.PostForm("username"), c.PostForm("password")
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "use JSON, form o HTTP Basic"})
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "credenciales requeridas"})
+		return
+	}
+	u, err := authenticate(c.Request.Context(), req.Username, req.Password)
+	if errors.Is(err, errInvalidCredentials) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "usuario o contraseña inválidos"})
 		return
 	}
-	u.IsActive = active
-	c.JSON(http.StatusOK, gin.H{"ok": true, "user": u})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondLogin(c, u)
 }
 
 // ADDRESSES
@@ -558,7 +1826,10 @@ func listAddressesHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id requerido"})
 		return
 	}
-	rows, err := db.Query(`SELECT id, user_id, label, street, reference, lat, lng, is_default FROM addresses WHERE user_id=? ORDER BY id`, userID)
+	if !numericQuery(c, "user_id") {
+		return
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT id, user_id, label, street, reference, lat, lng, is_default FROM addresses WHERE user_id=? ORDER BY id`, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -576,302 +1847,1936 @@ func listAddressesHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, list)
 }
 
-func createAddressHandler(c *gin.Context) {
-	var req CreateAddressReq
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+// Sugerencia de autocompletado: la dirección y cuándo se usó por última vez en un pedido
+type AddressSuggestion struct {
+	Address
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+const (
+	autocompleteDefaultLimit = 10
+	autocompleteMaxLimit     = 20
+)
+
+// GET /api/v1/addresses/autocomplete?user_id=&q= (opcional ?limit=)
+// Direcciones del usuario cuyo label o street contiene q (sin tildes ni mayúsculas),
+// primero las usadas más recientemente en pedidos.
+func autocompleteAddressesHandler(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id requerido"})
+		return
+	}
+	if !numericQuery(c, "user_id") {
 		return
 	}
-	if req.UserID == 0 || req.Street == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id y street requeridos"})
+	q := normalizeSearch(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q requerido"})
 		return
 	}
-	res, err := db.Exec(`INSERT INTO addresses(user_id, label, street, reference, lat, lng, is_default) VALUES (?,?,?,?,?,?,?)`,
-		req.UserID, req.Label, req.Street, req.Reference, req.Lat, req.Lng, req.IsDefault)
+	limit := autocompleteDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > autocompleteMaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit debe estar entre 1 y " + strconv.Itoa(autocompleteMaxLimit)})
+			return
+		}
+		limit = n
+	}
+	like := likeContains(q)
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT a.id, a.user_id, a.label, a.street, a.reference, a.lat, a.lng, a.is_default, MAX(o.created_at)
+        FROM addresses a
+        LEFT JOIN orders o ON o.address_id = a.id
+        WHERE a.user_id=?
+          AND (a.label COLLATE `+searchCollation+` LIKE ? OR a.street COLLATE `+searchCollation+` LIKE ?)
+        GROUP BY a.id, a.user_id, a.label, a.street, a.reference, a.lat, a.lng, a.is_default
+        ORDER BY MAX(o.created_at) IS NULL, MAX(o.created_at) DESC, a.id DESC
+        LIMIT ?`, userID, like, like, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	id, _ := res.LastInsertId()
-	c.JSON(http.StatusCreated, gin.H{"id": id})
+	defer rows.Close()
+	list := []AddressSuggestion{}
+	for rows.Next() {
+		var s AddressSuggestion
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Label, &s.Street, &s.Reference, &s.Lat, &s.Lng, &s.IsDefault, &s.LastUsedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, s)
+	}
+	c.JSON(http.StatusOK, list)
 }
 
-// ORDERS
-func createOrderHandler(c *gin.Context) {
-	var req CreateOrderReq
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+// Uso de una dirección en pedidos entregados
+type AddressStats struct {
+	Address
+	DeliveredCount int        `json:"delivered_count"`
+	LastUsedAt     *time.Time `json:"last_used_at"` // último pedido entregado; null = nunca
+}
+
+// GET /api/v1/addresses/stats?user_id=
+// Todas las direcciones del usuario (también las nunca usadas) con cuántos pedidos
+// se entregaron en cada una y cuándo fue el último, de la más usada a la menos.
+func addressStatsHandler(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id requerido"})
 		return
 	}
-	if req.CustomerID == 0 || req.AddressID == 0 || len(req.Items) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id, address_id e items requeridos"})
+	if !numericQuery(c, "user_id") {
 		return
 	}
-
-	tx, err := db.Begin()
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT a.id, a.user_id, a.label, a.street, a.reference, a.lat, a.lng, a.is_default,
+               COUNT(o.id), MAX(o.delivered_at)
+        FROM addresses a
+        LEFT JOIN orders o ON o.address_id = a.id AND o.status = 'entregado'
+        WHERE a.user_id=?
+        GROUP BY a.id, a.user_id, a.label, a.street, a.reference, a.lat, a.lng, a.is_default
+        ORDER BY COUNT(o.id) DESC, MAX(o.delivered_at) IS NULL, MAX(o.delivered_at) DESC, a.id`, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer tx.Rollback()
-
-	// Calcular subtotal con precio efectivo (personalizado si existe)
-	subtotal := 0.0
-	for _, it := range req.Items {
-		var effPrice float64
-		err := tx.QueryRow(`
-            SELECT COALESCE(cpp.price, p.price) AS price
-            FROM products p
-            LEFT JOIN customer_product_prices cpp
-              ON cpp.product_id=p.id AND cpp.customer_id=? AND cpp.is_active=TRUE
-            WHERE p.id=? AND p.is_active=TRUE`, req.CustomerID, it.ProductID).Scan(&effPrice)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("producto %d no válido", it.ProductID)})
+	defer rows.Close()
+	list := []AddressStats{}
+	for rows.Next() {
+		var s AddressStats
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Label, &s.Street, &s.Reference, &s.Lat, &s.Lng, &s.IsDefault, &s.DeliveredCount, &s.LastUsedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		subtotal += effPrice * float64(it.Qty)
+		list = append(list, s)
 	}
-	deliveryFee := 0.0 // MVP: tarifa plana 0
+	c.JSON(http.StatusOK, list)
+}
 
-	// Insert pedido
-	res, err := tx.Exec(`INSERT INTO orders(customer_id, address_id, assigned_driver_id, status, subtotal, delivery_fee, notes, scheduled_at) VALUES (?,?,?,?,?,?,?,?)`,
-		req.CustomerID, req.AddressID, nil, "por_atender", subtotal, deliveryFee, req.Notes, req.ScheduledAt)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+func createAddressHandler(c *gin.Context) {
+	var req CreateAddressReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
 		return
 	}
-	orderID, _ := res.LastInsertId()
-
-	// Insert items con precio efectivo
-	for _, it := range req.Items {
-		var unitPrice float64
-		err := tx.QueryRow(`
-            SELECT COALESCE(cpp.price, p.price) AS price
-            FROM products p
-            LEFT JOIN customer_product_prices cpp
-              ON cpp.product_id=p.id AND cpp.customer_id=? AND cpp.is_active=TRUE
-            WHERE p.id=?`, req.CustomerID, it.ProductID).Scan(&unitPrice)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		if _, err := tx.Exec(`INSERT INTO order_items(order_id, product_id, qty, unit_price) VALUES (?,?,?,?)`, orderID, it.ProductID, it.Qty, unitPrice); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	req.Street = strings.TrimSpace(req.Street)
+	req.Label = trimOptional(req.Label)
+	req.Reference = trimOptional(req.Reference)
+	if req.UserID == 0 {
+		respondInvalid(c, "user_id", "user_id requerido")
+		return
 	}
-	// Historial inicial
-	if _, err := tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, orderID, nil, "por_atender", req.CustomerID, "Pedido creado"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if req.Street == "" {
+		respondInvalid(c, "street", "street requerido")
+		return
+	}
+	if f, ok := firstTooLong(
+		lengthRule{"street", &req.Street, maxStreetLen},
+		lengthRule{"label", req.Label, maxLabelLen},
+		lengthRule{"reference", req.Reference, maxReferenceLen},
+	); ok {
+		respondTooLong(c, f)
 		return
 	}
 
-	if err := tx.Commit(); err != nil {
+	var id int64
+	var defaultID *int64
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		if err := lockAddressOwner(c, tx, req.UserID); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT INTO addresses(user_id, label, street, reference, lat, lng, is_default) VALUES (?,?,?,?,?,?,FALSE)`,
+			req.UserID, req.Label, req.Street, req.Reference, req.Lat, req.Lng)
+		if err != nil {
+			return err
+		}
+		id, _ = res.LastInsertId()
+		if req.IsDefault {
+			if err := setDefaultAddress(tx, req.UserID, id); err != nil {
+				return err
+			}
+		}
+		defaultID, err = defaultAddressID(tx, req.UserID)
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"order_id": orderID})
+	c.JSON(http.StatusCreated, gin.H{"id": id, "default_address_id": defaultID})
 }
 
-func listOrdersHandler(c *gin.Context) {
-	customerID := c.Query("customer_id")
-	driverID := c.Query("driver_id")
-	query := `SELECT id, customer_id, address_id, assigned_driver_id, status, subtotal, delivery_fee, (subtotal+delivery_fee) AS total, notes, scheduled_at, delivered_at, created_at FROM orders`
-	var args []any
-	if customerID != "" {
-		query += " WHERE customer_id=? ORDER BY id DESC"
-		args = append(args, customerID)
-	} else if driverID != "" {
-		query += " WHERE assigned_driver_id=? ORDER BY id DESC"
-		args = append(args, driverID)
-	} else {
-		query += " ORDER BY id DESC LIMIT 50"
-	}
-	rows, err := db.Query(query, args...)
+// Marca una dirección existente como la por defecto de su usuario.
+func setDefaultAddressHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
 		return
 	}
-	defer rows.Close()
-	var out []Order
-	for rows.Next() {
-		var o Order
-		if err := rows.Scan(&o.ID, &o.CustomerID, &o.AddressID, &o.AssignedDriverID, &o.Status, &o.Subtotal, &o.DeliveryFee, &o.Total, &o.Notes, &o.ScheduledAt, &o.DeliveredAt, &o.CreatedAt); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var userID int64
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT user_id FROM addresses WHERE id=?`, id).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dirección no encontrada"})
 			return
 		}
-		out = append(out, o)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-	c.JSON(http.StatusOK, out)
+	err = withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		if err := lockAddressOwner(c, tx, userID); err != nil {
+			return err
+		}
+		return setDefaultAddress(tx, userID, id)
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "default_address_id": id})
 }
 
-func getOrderHandler(c *gin.Context) {
-	id := c.Param("id")
-	var o Order
-	err := db.QueryRow(`SELECT id, customer_id, address_id, assigned_driver_id, status, subtotal, delivery_fee, (subtotal+delivery_fee) AS total, notes, scheduled_at, delivered_at, created_at FROM orders WHERE id=?`, id).
-		Scan(&o.ID, &o.CustomerID, &o.AddressID, &o.AssignedDriverID, &o.Status, &o.Subtotal, &o.DeliveryFee, &o.Total, &o.Notes, &o.ScheduledAt, &o.DeliveredAt, &o.CreatedAt)
-	if errors.Is(err, sql.ErrNoRows) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "no encontrado"})
+type SetDefaultAddressReq struct {
+	DefaultAddressID int64 `json:"default_address_id"`
+}
+
+// PUT /api/v1/addresses/defaults?user_id=
+// Deja default_address_id como la única por defecto del usuario y todas las demás
+// en false, en una transacción. La dirección debe ser del usuario (422 si no).
+func replaceDefaultAddressHandler(c *gin.Context) {
+	userIDParam := c.Query("user_id")
+	if userIDParam == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id requerido"})
 		return
 	}
+	userID, err := strconv.ParseInt(userIDParam, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id debe ser numérico"})
+		return
+	}
+	var req SetDefaultAddressReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.DefaultAddressID == 0 {
+		respondInvalid(c, "default_address_id", "default_address_id requerido")
 		return
 	}
 
-	// Items
-	rows, err := db.Query(`SELECT oi.id, oi.order_id, oi.product_id, oi.qty, oi.unit_price, (oi.qty*oi.unit_price) AS line_total, p.name, p.capacity_liters FROM order_items oi JOIN products p ON p.id=oi.product_id WHERE oi.order_id=?`, id)
+	var a Address
+	err = withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		if err := lockAddressOwner(c, tx, userID); err != nil {
+			return err
+		}
+		err := tx.QueryRow(`SELECT id, user_id, label, street, reference, lat, lng FROM addresses WHERE id=? AND user_id=?`, req.DefaultAddressID, userID).
+			Scan(&a.ID, &a.UserID, &a.Label, &a.Street, &a.Reference, &a.Lat, &a.Lng)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondInvalid(c, "default_address_id", "default_address_id inválido para este usuario")
+			return errResponded
+		}
+		if err != nil {
+			return err
+		}
+		return setDefaultAddress(tx, userID, a.ID)
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
-	var items []OrderItem
-	for rows.Next() {
-		var it OrderItem
-		if err := rows.Scan(&it.ID, &it.OrderID, &it.ProductID, &it.Qty, &it.UnitPrice, &it.LineTotal, &it.ProductName, &it.Capacity); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		items = append(items, it)
+	a.IsDefault = true
+	c.JSON(http.StatusOK, gin.H{"ok": true, "default_address_id": a.ID, "address": a})
+}
+
+// lockAddressOwner bloquea la fila del usuario para serializar los cambios de sus
+// direcciones: con dos altas "por defecto" simultáneas gana siempre la última en
+// obtener el bloqueo y queda exactamente una. Si el usuario no existe responde 422
+// y devuelve errResponded (se usa dentro de withTx).
+func lockAddressOwner(c *gin.Context, tx *sql.Tx, userID int64) error {
+	var locked int64
+	err := tx.QueryRow(`SELECT id FROM users WHERE id=? FOR UPDATE`, userID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondInvalid(c, "user_id", "user_id inválido")
+		return errResponded
 	}
-	c.JSON(http.StatusOK, OrderWithItems{Order: o, Items: items})
+	return err
 }
 
-func assignOrderHandler(c *gin.Context) {
-	id := c.Param("id")
-	var req AssignOrderReq
+// setDefaultAddress deja addressID como la única dirección por defecto del usuario.
+// Se llama con la fila del usuario bloqueada (lockAddressOwner).
+func setDefaultAddress(tx *sql.Tx, userID, addressID int64) error {
+	if _, err := tx.Exec(`UPDATE addresses SET is_default=FALSE WHERE user_id=? AND is_default=TRUE AND id<>?`, userID, addressID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE addresses SET is_default=TRUE WHERE id=? AND user_id=?`, addressID, userID)
+	return err
+}
+
+// defaultAddressID devuelve la dirección por defecto del usuario (nil si no tiene).
+func defaultAddressID(q querier, userID int64) (*int64, error) {
+	var id int64
+	err := q.QueryRow(`SELECT id FROM addresses WHERE user_id=? AND is_default=TRUE`, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// ORDERS
+func createOrderHandler(c *gin.Context) {
+	var req CreateOrderReq
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
 		return
 	}
-	if req.DriverID == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "driver_id requerido"})
+	if req.CustomerID == 0 {
+		respondInvalid(c, "customer_id", "customer_id requerido")
+		return
+	}
+	if len(req.Items) == 0 {
+		respondInvalid(c, "items", "items requeridos")
+		return
+	}
+	req.Notes = trimOptional(req.Notes)
+	if f, ok := firstTooLong(lengthRule{"notes", req.Notes, maxNotesLen}); ok {
+		respondTooLong(c, f)
+		return
+	}
+
+	// El intento se reserva aquí (para que pedidos simultáneos no pasen todos) y se
+	// devuelve si al final no se crea el pedido
+	created := false
+	if key, limited := orderRateKey(c); limited {
+		at := time.Now()
+		if ok, retry := orderCreateLimiter.allow(key, orderRateLimit, orderRateWindow, at); !ok {
+			respondRateLimited(c, retry)
+			return
+		}
+		defer func() {
+			if !created {
+				orderCreateLimiter.undo(key, at)
+			}
+		}()
+	}
+
+	branchID, ok := resolveBranch(c, req.BranchID)
+	if !ok {
 		return
 	}
+	// Un agente puede crear el pedido a nombre del cliente; sin autenticación es autoservicio
+	createdBy := req.CustomerID
+	if u, ok := currentUser(c); ok {
+		createdBy = u.ID
+	}
 
-	tx, err := db.Begin()
+	var po *preparedOrder
+	var orderID int64
+	var trackingToken string
+	// Se reintenta completo ante deadlock (withTx); las respuestas de error van dentro
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		var ok bool
+		if po, ok = prepareOrder(c, tx, &req, branchID); !ok {
+			return errResponded
+		}
+		// Montos muy altos suelen ser fraude o un bug del cliente; un admin puede forzarlos
+		if maxOrderTotal != nil && po.total() > *maxOrderTotal && !largeOrderOverride(c) {
+			c.JSON(http.StatusConflict, gin.H{"error": "monto de pedido excede el máximo", "max_order_total": *maxOrderTotal})
+			return errResponded
+		}
+		// Insert pedido
+		var err error
+		if trackingToken, err = newTrackingToken(); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT INTO orders(customer_id, address_id, branch_id, created_by, assigned_driver_id, status, priority, payment_method, source, subtotal, delivery_fee, tax, tax_rate, tax_delivery, total, notes, scheduled_at, delivery_window_start, delivery_window_end, tracking_token) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+			req.CustomerID, req.AddressID, branchID, createdBy, nil, "por_atender", po.priority, po.payment, po.source, po.subtotal, po.deliveryFee, po.tax, taxRate, taxDelivery, po.total(), req.Notes, po.scheduledAt, po.windowStart, po.windowEnd, trackingToken)
+		if err != nil {
+			return err
+		}
+		orderID, _ = res.LastInsertId()
+
+		// Insert items con precio efectivo
+		if err := insertOrderItems(tx, orderID, po.priced); err != nil {
+			return err
+		}
+		if err := reserveStock(tx, po.priced); err != nil {
+			return pricingTxError(c, err)
+		}
+		// Historial inicial
+		_, err = tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, orderID, nil, "por_atender", createdBy, "Pedido creado")
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer tx.Rollback()
+	created = true
+	orderEvents.publish(OrderEvent{Type: orderEventCreated, OrderID: orderID, Status: "por_atender"})
+	c.JSON(http.StatusCreated, gin.H{"order_id": orderID, "address_id": req.AddressID, "tracking_token": trackingToken, "warnings": po.warnings})
+}
 
-	// Leer estado actual
-	var old string
-	if err := tx.QueryRow(`SELECT status FROM orders WHERE id=? FOR UPDATE`, id).Scan(&old); err != nil {
+// largeOrderOverride: un admin autenticado puede saltarse MAX_ORDER_TOTAL con
+// el header X-Allow-Large-Order: true. Para otros roles el header se ignora.
+func largeOrderOverride(c *gin.Context) bool {
+	u, ok := currentUser(c)
+	return ok && u.RoleID == roleAdmin && c.GetHeader("X-Allow-Large-Order") == "true"
+}
+
+// preparedOrder es el resultado de validar y preciar un CreateOrderReq sin insertar nada.
+type preparedOrder struct {
+	priced      []pricedItem
+	subtotal    float64
+	deliveryFee float64
+	tax         float64
+	scheduledAt sql.NullTime
+	windowStart sql.NullTime
+	windowEnd   sql.NullTime
+	priority    string
+	payment     *string
+	source      string
+	warnings    []string
+}
+
+func (po *preparedOrder) total() float64 {
+	return po.subtotal + po.deliveryFee + po.tax
+}
+
+// prepareOrder aplica las validaciones y el cálculo de precios de la creación de pedidos:
+// cliente válido, dirección (o la por defecto), scheduled_at, precio efectivo por ítem,
+// tarifa de delivery y avisos. Lo comparten la creación y la cotización para que den
+// exactamente los mismos montos. Si algo falla responde y devuelve ok=false.
+func prepareOrder(c *gin.Context, q querier, req *CreateOrderReq, branchID int64) (*preparedOrder, bool) {
+	priority := priorityNormal
+	if req.Priority != nil && *req.Priority != "" {
+		priority = *req.Priority
+	}
+	if priority != priorityNormal && priority != priorityHigh {
+		respondInvalid(c, "priority", "priority debe ser normal o high")
+		return nil, false
+	}
+	req.PaymentMethod = trimOptional(req.PaymentMethod)
+	if req.PaymentMethod != nil && !paymentMethods[*req.PaymentMethod] {
+		respondInvalid(c, "payment_method", "payment_method debe ser cash, card o transfer")
+		return nil, false
+	}
+	source := defaultOrderSource
+	if v := trimOptional(req.Source); v != nil {
+		source = *v
+	}
+	if !orderSources[source] {
+		respondInvalid(c, "source", "source debe ser web, app o phone")
+		return nil, false
+	}
+	// El cliente debe existir, estar activo y tener rol cliente
+	var custRole int8
+	var custActive bool
+	var custTZ *string
+	err := q.QueryRow(`SELECT role_id, is_active, timezone FROM users WHERE id=?`, req.CustomerID).Scan(&custRole, &custActive, &custTZ)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil || !custActive || custRole != roleCustomer {
+		respondInvalid(c, "customer_id", "cliente inválido")
+		return nil, false
+	}
+	// Sin address_id se usa la dirección por defecto del cliente
+	if req.AddressID == 0 {
+		err := q.QueryRow(`SELECT id FROM addresses WHERE user_id=? AND is_default=1`, req.CustomerID).Scan(&req.AddressID)
 		if errors.Is(err, sql.ErrNoRows) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
-			return
+			respondInvalid(c, "address_id", "dirección requerida")
+			return nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	}
+	po := &preparedOrder{priority: priority, payment: req.PaymentMethod, source: source}
+	if po.scheduledAt, err = parseCustomerTime(req.ScheduledAt, custTZ, "scheduled_at"); err != nil {
+		respondInvalid(c, "scheduled_at", err.Error())
+		return nil, false
+	}
+	if po.windowStart, po.windowEnd, err = parseDeliveryWindow(req, custTZ, time.Now()); err != nil {
+		respondInvalid(c, "delivery_window_start", err.Error())
+		return nil, false
+	}
+
+	// Calcular subtotal con precio efectivo (personalizado si existe)
+	po.priced, po.subtotal, err = priceOrderItems(q, branchID, req.CustomerID, req.Items)
+	if err != nil {
+		respondPricingError(c, err)
+		return nil, false
+	}
+	var drifted int
+	if req.PriceSnapshot != nil && *req.PriceSnapshot != "" {
+		if drifted, err = applyPriceSnapshot(*req.PriceSnapshot, req.CustomerID, branchID, po.priced); err != nil {
+			respondSnapshotError(c, err)
+			return nil, false
+		}
+		po.subtotal = 0
+		for _, it := range po.priced {
+			po.subtotal += it.UnitPrice * float64(it.Qty)
+		}
+	}
+	// Tarifa de delivery según distancia almacén → dirección (del mismo cliente)
+	var addrLat, addrLng *float64
+	if err := q.QueryRow(`SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`, req.AddressID, req.CustomerID).Scan(&addrLat, &addrLng); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondInvalid(c, "address_id", "address_id inválido para este cliente")
+			return nil, false
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	po.deliveryFee = deliveryFeeFor(addrLat, addrLng)
+	po.tax = computeTax(po.subtotal, po.deliveryFee, taxRate, taxDelivery)
+
+	// Avisos no bloqueantes para el operador
+	if po.warnings, err = orderSoftWarnings(q, req.CustomerID, po.scheduledAt, addrLat, addrLng); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if drifted > 0 {
+		po.warnings = append(po.warnings, fmt.Sprintf("%d ítem(s) cobrados al precio cotizado: el precio actual cambió", drifted))
+	}
+	return po, true
+}
+
+// QuoteItem es una línea cotizada con su precio efectivo.
+type QuoteItem struct {
+	ProductID   int64   `json:"product_id"`
+	Qty         int     `json:"qty"`
+	UnitPrice   float64 `json:"unit_price"`
+	LineTotal   float64 `json:"line_total"`
+	PriceSource string  `json:"price_source"`
+	PromotionID *int64  `json:"promotion_id,omitempty"`
+}
+
+// Cotización: mismo cálculo que la creación, sin insertar ni reservar stock.
+func quoteOrderHandler(c *gin.Context) {
+	var req CreateOrderReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
 		return
 	}
-	if old != "por_atender" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "solo pedidos 'por_atender' pueden asignarse"})
+	if req.CustomerID == 0 {
+		respondInvalid(c, "customer_id", "customer_id requerido")
 		return
 	}
-
-	if _, err := tx.Exec(`UPDATE orders SET assigned_driver_id=?, status='asignado' WHERE id=?`, req.DriverID, id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// La cotización usa los precios negociados del cliente
+	if !canSeeCustomerPrices(c, req.CustomerID) {
 		return
 	}
-	// Historial
-	if _, err := tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, id, old, "asignado", req.DriverID, "Asignado a repartidor"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if len(req.Items) == 0 {
+		respondInvalid(c, "items", "items requeridos")
+		return
+	}
+	branchID, ok := resolveBranch(c, req.BranchID)
+	if !ok {
+		return
+	}
+	// Una cotización siempre usa los precios actuales: re-cotizar no extiende un snapshot viejo
+	req.PriceSnapshot = nil
+	po, ok := prepareOrder(c, ctxDB{c.Request.Context()}, &req, branchID)
+	if !ok {
 		return
 	}
-	if err := tx.Commit(); err != nil {
+	snapshot, snapshotExp, err := issuePriceSnapshot(req.CustomerID, branchID, po.priced)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"ok": true})
+	items := make([]QuoteItem, 0, len(po.priced))
+	for _, it := range po.priced {
+		items = append(items, QuoteItem{ProductID: it.ProductID, Qty: it.Qty, UnitPrice: it.UnitPrice, LineTotal: it.UnitPrice * float64(it.Qty), PriceSource: it.PriceSource, PromotionID: it.PromotionID})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"address_id":   req.AddressID,
+		"branch_id":    branchID,
+		"items":        items,
+		"subtotal":     po.subtotal,
+		"delivery_fee": po.deliveryFee,
+		"tax":          po.tax,
+		"total":        po.total(),
+		"warnings":     po.warnings,
+
+		"price_snapshot":            snapshot,
+		"price_snapshot_expires_at": snapshotExp,
+	})
 }
 
-func updateOrderStatusHandler(c *gin.Context) {
-	id := c.Param("id")
-	var req UpdateStatusReq
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+// orderSoftWarnings revisa condiciones que no impiden crear el pedido pero
+// conviene que el operador vea. Siempre devuelve un slice (vacío = sin avisos).
+func orderSoftWarnings(q querier, customerID int64, scheduledAt sql.NullTime, addrLat, addrLng *float64) ([]string, error) {
+	warnings := []string{}
+	var defaults int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM addresses WHERE user_id=? AND is_default=1`, customerID).Scan(&defaults); err != nil {
+		return nil, err
+	}
+	if defaults == 0 {
+		warnings = append(warnings, "el cliente no tiene dirección por defecto")
+	}
+	if addrLat == nil || addrLng == nil {
+		warnings = append(warnings, "delivery_fee es 0: la dirección no tiene coordenadas")
+	} else if warehouseLat == nil || warehouseLng == nil {
+		warnings = append(warnings, "delivery_fee es 0: faltan las coordenadas del almacén")
+	}
+	if scheduledAt.Valid && scheduledAt.Time.Before(time.Now()) {
+		warnings = append(warnings, "scheduled_at está en el pasado")
+	}
+	return warnings, nil
+}
+
+// Formatos de scheduled_at sin offset, interpretados en la zona del cliente
+var localScheduleLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// parseCustomerTime convierte una fecha-hora del cliente a UTC. Con offset (RFC3339)
+// se respeta; sin offset se interpreta en la zona del cliente, o en appLocation si no tiene.
+func parseCustomerTime(raw *string, customerTZ *string, field string) (sql.NullTime, error) {
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return sql.NullTime{}, nil
+	}
+	v := strings.TrimSpace(*raw)
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+	}
+	loc := appLocation
+	if customerTZ != nil {
+		if l, err := time.LoadLocation(*customerTZ); err == nil {
+			loc = l
+		}
+	}
+	for _, layout := range localScheduleLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+		}
+	}
+	return sql.NullTime{}, errors.New(field + " inválido: use RFC3339 o YYYY-MM-DDTHH:MM[:SS]")
+}
+
+// parseDeliveryWindow valida la franja de entrega: inicio y fin van juntos, no se
+// combinan con scheduled_at (es una hora exacta o una franja), inicio < fin y
+// ambos en el futuro.
+func parseDeliveryWindow(req *CreateOrderReq, customerTZ *string, now time.Time) (start, end sql.NullTime, err error) {
+	if start, err = parseCustomerTime(req.DeliveryWindowStart, customerTZ, "delivery_window_start"); err != nil {
 		return
 	}
-	if req.NewStatus == "" || req.ChangedBy == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "new_status y changed_by requeridos"})
+	if end, err = parseCustomerTime(req.DeliveryWindowEnd, customerTZ, "delivery_window_end"); err != nil {
 		return
 	}
+	switch {
+	case !start.Valid && !end.Valid:
+		return
+	case start.Valid != end.Valid:
+		err = errors.New("delivery_window_start y delivery_window_end van juntos")
+	case req.ScheduledAt != nil && strings.TrimSpace(*req.ScheduledAt) != "":
+		err = errors.New("use scheduled_at o la franja de entrega, no ambos")
+	case !start.Time.Before(end.Time):
+		err = errors.New("delivery_window_start debe ser anterior a delivery_window_end")
+	case !start.Time.After(now):
+		err = errors.New("la franja de entrega debe estar en el futuro")
+	}
+	return
+}
+
+// respondPricingError: 422 para rechazos de validación, 500 para errores de BD.
+func respondPricingError(c *gin.Context, err error) {
+	var perr *pricingError
+	if errors.As(err, &perr) {
+		body := gin.H{"error": perr.msg, "field": "items"}
+		if len(perr.items) > 0 {
+			body["invalid_items"] = perr.items
+		}
+		c.JSON(http.StatusUnprocessableEntity, body)
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
 
-	// Validaciones simples de transición
-	valid := map[string][]string{
-		"por_atender": {"asignado", "cancelado"},
-		"asignado":    {"en_camino", "cancelado"},
-		"en_camino":   {"entregado"},
+// pricingTxError se usa dentro de withTx: un pricingError se responde con 422 y
+// corta con errResponded; cualquier otro error se devuelve tal cual (reintento o 500).
+func pricingTxError(c *gin.Context, err error) error {
+	var perr *pricingError
+	if errors.As(err, &perr) {
+		respondPricingError(c, err)
+		return errResponded
 	}
+	return err
+}
 
-	tx, err := db.Begin()
+// Reemplaza los ítems de un pedido aún no asignado, re-preciando al precio
+// efectivo actual y recalculando el subtotal.
+func updateOrderItemsHandler(c *gin.Context) {
+	id := c.Param("id")
+	orderID, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	var req UpdateOrderItemsReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if len(req.Items) == 0 {
+		respondInvalid(c, "items", "items requeridos")
 		return
 	}
-	defer tx.Rollback()
+	u, _ := currentUser(c)
+
+	var deliveryFee, subtotal, tax, total float64
+	err = withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		var status string
+		var customerID, branchID int64
+		if err := tx.QueryRow(`SELECT status, customer_id, branch_id, delivery_fee FROM orders WHERE id=? FOR UPDATE`, orderID).Scan(&status, &customerID, &branchID, &deliveryFee); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+				return errResponded
+			}
+			return err
+		}
+		// Solo el cliente dueño del pedido o un admin
+		if u.RoleID != roleAdmin && u.ID != customerID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+			return errResponded
+		}
+		if status != "por_atender" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "solo pedidos 'por_atender' pueden editar sus ítems"})
+			return errResponded
+		}
 
-	var old string
-	if err := tx.QueryRow(`SELECT status FROM orders WHERE id=? FOR UPDATE`, id).Scan(&old); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
-			return
+		// Devolver el stock de los ítems anteriores antes de validar y reservar los nuevos
+		// (si algo falla, el rollback lo deja como estaba)
+		if err := releaseItemsStock(tx, orderID); err != nil {
+			return err
+		}
+		priced, sub, err := priceOrderItems(tx, branchID, customerID, req.Items)
+		if err != nil {
+			return pricingTxError(c, err)
+		}
+		subtotal = sub
+		if _, err := tx.Exec(`DELETE FROM order_items WHERE order_id=?`, orderID); err != nil {
+			return err
+		}
+		if err := insertOrderItems(tx, orderID, priced); err != nil {
+			return err
+		}
+		if err := reserveStock(tx, priced); err != nil {
+			return pricingTxError(c, err)
 		}
+		if _, err := tx.Exec(`UPDATE orders SET subtotal=? WHERE id=?`, subtotal, orderID); err != nil {
+			return err
+		}
+		if tax, total, err = syncOrderTotal(tx, orderID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, orderID, status, status, u.ID, "Ítems modificados")
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "subtotal": subtotal, "delivery_fee": deliveryFee, "tax": tax, "total": total})
+}
 
-	allowed := false
-	for _, s := range valid[old] {
-		if s == req.NewStatus {
-			allowed = true
-			break
-		}
+// Registra la prueba de entrega (URL de foto/firma) del pedido en camino o entregado.
+func orderProofHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req OrderProofReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
 	}
-	if !allowed {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("transición inválida %s → %s", old, req.NewStatus)})
+	req.ProofURL = strings.TrimSpace(req.ProofURL)
+	if !validProofURL(req.ProofURL) {
+		respondInvalid(c, "proof_url", "proof_url debe ser una URL http(s) válida")
 		return
 	}
-
-	q := `UPDATE orders SET status=?`
-	if req.NewStatus == "entregado" {
-		q += `, delivered_at=NOW()`
+	req.SignatureName = trimOptional(req.SignatureName)
+	if f, ok := firstTooLong(lengthRule{"proof_url", &req.ProofURL, 500}, lengthRule{"signature_name", req.SignatureName, 120}); ok {
+		respondTooLong(c, f)
+		return
 	}
-	q += ` WHERE id=?`
-	if _, err := tx.Exec(q, req.NewStatus, id); err != nil {
+
+	var status string
+	var driverID *int64
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT status, assigned_driver_id FROM orders WHERE id=?`, id).Scan(&status, &driverID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if _, err := tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, id, old, req.NewStatus, req.ChangedBy, req.Note); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	u, _ := currentUser(c)
+	if u.RoleID != roleAdmin && (driverID == nil || *driverID != u.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "solo el repartidor asignado o un admin"})
 		return
 	}
-	if err := tx.Commit(); err != nil {
+	if status != "en_camino" && status != "entregado" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "solo pedidos 'en_camino' o 'entregado' admiten prueba de entrega"})
+		return
+	}
+	if _, err := db.ExecContext(c.Request.Context(), `UPDATE orders SET proof_url=?, signature_name=?, proof_at=NOW() WHERE id=?`, req.ProofURL, req.SignatureName, id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func listOrderHistoryHandler(c *gin.Context) {
-	id := c.Param("id")
-	rows, err := db.Query(`SELECT id, order_id, old_status, new_status, changed_by, changed_at, note FROM order_status_history WHERE order_id=? ORDER BY id`, id)
+// validProofURL exige una URL absoluta http o https con host.
+func validProofURL(raw string) bool {
+	u, err := url.ParseRequestURI(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Cambia la dirección de entrega antes de que el repartidor salga, recalculando
+// la tarifa de delivery por distancia.
+func updateOrderAddressHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
 		return
 	}
-	defer rows.Close()
-	var hist []StatusHistory
-	for rows.Next() {
-		var h StatusHistory
-		if err := rows.Scan(&h.ID, &h.OrderID, &h.OldStatus, &h.NewStatus, &h.ChangedBy, &h.ChangedAt, &h.Note); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		hist = append(hist, h)
+	var req UpdateOrderAddressReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.AddressID == 0 {
+		respondInvalid(c, "address_id", "address_id requerido")
+		return
 	}
-	c.JSON(http.StatusOK, hist)
+	u, _ := currentUser(c)
+
+	var subtotal, deliveryFee, tax, total float64
+	err = withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		var status string
+		var customerID, oldAddressID int64
+		if err := tx.QueryRow(`SELECT status, customer_id, address_id, subtotal FROM orders WHERE id=? FOR UPDATE`, id).Scan(&status, &customerID, &oldAddressID, &subtotal); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+				return errResponded
+			}
+			return err
+		}
+		// Solo el cliente dueño del pedido o un admin
+		if u.RoleID != roleAdmin && u.ID != customerID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+			return errResponded
+		}
+		if status != "por_atender" && status != "asignado" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "solo pedidos 'por_atender' o 'asignado' pueden cambiar de dirección"})
+			return errResponded
+		}
+
+		// La dirección debe ser del mismo cliente
+		var addrLat, addrLng *float64
+		err := tx.QueryRow(`SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`, req.AddressID, customerID).Scan(&addrLat, &addrLng)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondInvalid(c, "address_id", "address_id inválido para este cliente")
+			return errResponded
+		}
+		if err != nil {
+			return err
+		}
+		deliveryFee = deliveryFeeFor(addrLat, addrLng)
+
+		if _, err := tx.Exec(`UPDATE orders SET address_id=?, delivery_fee=? WHERE id=?`, req.AddressID, deliveryFee, id); err != nil {
+			return err
+		}
+		if tax, total, err = syncOrderTotal(tx, id); err != nil {
+			return err
+		}
+		note := fmt.Sprintf("Dirección cambiada de %d a %d", oldAddressID, req.AddressID)
+		_, err = tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, id, status, status, u.ID, note)
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "address_id": req.AddressID, "subtotal": subtotal, "delivery_fee": deliveryFee, "tax": tax, "total": total})
+}
+
+func listOrdersHandler(c *gin.Context) {
+	customerID := c.Query("customer_id")
+	driverID := c.Query("driver_id")
+	if !numericQuery(c, "customer_id", "driver_id") {
+		return
+	}
+	money, ok := moneyMode(c)
+	if !ok {
+		return
+	}
+	formatted, ok := currencyFormat(c)
+	if !ok {
+		return
+	}
+	q := normalizeSearch(c.Query("q")) // búsqueda por nombre del cliente, sin distinguir tildes
+	status := c.Query("status")
+	if status != "" && !knownStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status inválido"})
+		return
+	}
+	schedFrom, schedTo, ok := dateWindowQuery(c, "scheduled_from", "scheduled_to")
+	if !ok {
+		return
+	}
+	branchID, ok := branchFromRequest(c)
+	if !ok {
+		return
+	}
+	query := `SELECT o.id, o.customer_id, o.address_id, o.branch_id, o.created_by, o.assigned_driver_id, o.status, o.priority, o.payment_method, o.source, o.subtotal, o.delivery_fee, o.tax, o.total, o.notes, o.scheduled_at, o.delivered_at, o.created_at, o.delivery_window_start, o.delivery_window_end FROM orders o`
+	where := []string{"o.branch_id=?"}
+	args := []any{branchID}
+	if customerID != "" {
+		where = append(where, "o.customer_id=?")
+		args = append(args, customerID)
+	} else if driverID != "" {
+		where = append(where, "o.assigned_driver_id=?")
+		args = append(args, driverID)
+	}
+	if status != "" {
+		where = append(where, "o.status=?")
+		args = append(args, status)
+	}
+	if schedFrom != nil || schedTo != nil {
+		// Ventana de programación; los pedidos sin scheduled_at se excluyen salvo ?include_unscheduled=true
+		var window []string
+		if schedFrom != nil {
+			window = append(window, "o.scheduled_at>=?")
+			args = append(args, *schedFrom)
+		}
+		if schedTo != nil {
+			window = append(window, "o.scheduled_at<?")
+			args = append(args, *schedTo)
+		}
+		cond := strings.Join(window, " AND ")
+		if c.Query("include_unscheduled") == "true" {
+			cond = "((" + cond + ") OR o.scheduled_at IS NULL)"
+		}
+		where = append(where, cond)
+	}
+	if q != "" {
+		where = append(where, "u.full_name COLLATE "+searchCollation+" LIKE ?")
+		args = append(args, likeContains(q))
+	}
+	if phone := c.Query("customer_phone"); phone != "" {
+		// Se comparan solo los dígitos, tal como vienen y en forma canónica (los
+		// teléfonos guardados ya normalizados llevan el código de país); si varios
+		// clientes comparten el teléfono salen todos
+		digits := phoneDigits(phone)
+		if digits == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer_phone inválido"})
+			return
+		}
+		where = append(where, "REGEXP_REPLACE(u.phone, '[^0-9]', '') IN (?,?)")
+		args = append(args, digits, phoneDigits(phoneLookupKey(phone)))
+	}
+	if q != "" || c.Query("customer_phone") != "" {
+		query += " JOIN users u ON u.id=o.customer_id"
+	}
+	orderBy, ok := orderByClause(c, orderSortFields, "o.id", "DESC")
+	if !ok {
+		return
+	}
+	if status == "por_atender" && c.Query("sort") == "" {
+		// Cola de despacho: los urgentes primero
+		orderBy = " ORDER BY o.priority='" + priorityHigh + "' DESC," + strings.TrimPrefix(orderBy, " ORDER BY")
+	}
+	query += " WHERE " + strings.Join(where, " AND ") + orderBy
+	if len(where) == 1 {
+		// Sin filtros aparte de la sucursal: solo los últimos 50
+		query += " LIMIT 50"
+	}
+	rows, err := db.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var out []Order
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(&o.ID, &o.CustomerID, &o.AddressID, &o.BranchID, &o.CreatedBy, &o.AssignedDriverID, &o.Status, &o.Priority, &o.PaymentMethod, &o.Source, &o.Subtotal, &o.DeliveryFee, &o.Tax, &o.Total, &o.Notes, &o.ScheduledAt, &o.DeliveredAt, &o.CreatedAt, &o.DeliveryWindowStart, &o.DeliveryWindowEnd); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		o.applyMoneyMode(money)
+		if formatted {
+			o.setFormatted()
+		}
+		out = append(out, o)
+	}
+	if parseExpand(c)["cancellation"] {
+		if err := loadCancellations(c.Request.Context(), out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// loadCancellations completa cancellation_reason y cancelled_at de los pedidos
+// cancelados con la última fila 'cancelado' de su historial (una sola consulta).
+func loadCancellations(ctx context.Context, orders []Order) error {
+	idx := map[int64]int{}
+	var args []any
+	for i, o := range orders {
+		if o.Status == "cancelado" {
+			idx[o.ID] = i
+			args = append(args, o.ID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, `
+        SELECT h.order_id, h.note, h.changed_at
+        FROM order_status_history h
+        JOIN (SELECT order_id, MAX(id) AS id FROM order_status_history
+              WHERE new_status='cancelado' AND order_id IN (?`+strings.Repeat(",?", len(args)-1)+`)
+              GROUP BY order_id) last ON last.id=h.id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orderID int64
+		var note *string
+		var at time.Time
+		if err := rows.Scan(&orderID, &note, &at); err != nil {
+			return err
+		}
+		o := &orders[idx[orderID]]
+		o.CancellationReason, o.CancelledAt = note, &at
+	}
+	return rows.Err()
+}
+
+func getOrderHandler(c *gin.Context) {
+	id := c.Param("id")
+	money, ok := moneyMode(c)
+	if !ok {
+		return
+	}
+	formatted, ok := currencyFormat(c)
+	if !ok {
+		return
+	}
+	var o Order
+	err := db.QueryRowContext(c.Request.Context(), `SELECT id, customer_id, address_id, branch_id, created_by, assigned_driver_id, status, priority, payment_method, source, subtotal, delivery_fee, tax, total, notes, scheduled_at, delivered_at, created_at, delivery_window_start, delivery_window_end, transit_started_at, proof_url, signature_name, proof_at FROM orders WHERE id=?`, id).
+		Scan(&o.ID, &o.CustomerID, &o.AddressID, &o.BranchID, &o.CreatedBy, &o.AssignedDriverID, &o.Status, &o.Priority, &o.PaymentMethod, &o.Source, &o.Subtotal, &o.DeliveryFee, &o.Tax, &o.Total, &o.Notes, &o.ScheduledAt, &o.DeliveredAt, &o.CreatedAt, &o.DeliveryWindowStart, &o.DeliveryWindowEnd, &o.TransitStartedAt, &o.ProofURL, &o.SignatureName, &o.ProofAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if u, _ := currentUser(c); !canSeeOrder(u, o.CustomerID, o.AssignedDriverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+		return
+	}
+
+	// Items
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT oi.id, oi.order_id, oi.product_id, oi.qty, oi.unit_price, (oi.qty*oi.unit_price) AS line_total, oi.price_source, oi.promotion_id, p.name, p.capacity_liters FROM order_items oi JOIN products p ON p.id=oi.product_id WHERE oi.order_id=?`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var items []OrderItem
+	for rows.Next() {
+		var it OrderItem
+		if err := rows.Scan(&it.ID, &it.OrderID, &it.ProductID, &it.Qty, &it.UnitPrice, &it.LineTotal, &it.PriceSource, &it.PromotionID, &it.ProductName, &it.Capacity); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		items = append(items, it)
+	}
+	o.applyMoneyMode(money)
+	for i := range items {
+		items[i].applyMoneyMode(money)
+	}
+	if formatted {
+		o.setFormatted()
+		for i := range items {
+			items[i].setFormatted()
+		}
+	}
+	out := OrderWithItems{Order: o, Items: items}
+
+	expand := parseExpand(c)
+	if expand["cancellation"] {
+		one := []Order{out.Order}
+		if err := loadCancellations(c.Request.Context(), one); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out.Order = one[0]
+	}
+	if expand["customer"] {
+		var cu OrderCustomer
+		err := db.QueryRowContext(c.Request.Context(), `SELECT id, full_name, phone FROM users WHERE id=?`, o.CustomerID).Scan(&cu.ID, &cu.FullName, &cu.Phone)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil {
+			cu.Phone = displayPhone(cu.Phone)
+			out.Customer = &cu
+		}
+	}
+	if expand["address"] {
+		// Sin filtrar por estado: una dirección dada de baja sigue siendo la del pedido
+		var ad OrderAddress
+		err := db.QueryRowContext(c.Request.Context(), `SELECT street, reference, lat, lng FROM addresses WHERE id=?`, o.AddressID).Scan(&ad.Street, &ad.Reference, &ad.Lat, &ad.Lng)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil {
+			out.Address = &ad
+		}
+	}
+	respondWithETag(c, out)
+}
+
+func assignOrderHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req AssignOrderReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.DriverID == 0 {
+		respondInvalid(c, "driver_id", "driver_id requerido")
+		return
+	}
+	admin, _ := currentUser(c)
+
+	var orderID int64
+	var old string
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		// Leer estado actual
+		if err := tx.QueryRow(`SELECT id, status FROM orders WHERE id=? FOR UPDATE`, id).Scan(&orderID, &old); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+				return errResponded
+			}
+			return err
+		}
+		if old != "por_atender" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "solo pedidos 'por_atender' pueden asignarse"})
+			return errResponded
+		}
+		if e, _ := findEdge(old, "asignado"); !e.allows(admin.RoleID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "no autorizado"})
+			return errResponded
+		}
+
+		if _, err := tx.Exec(`UPDATE orders SET assigned_driver_id=?, status='asignado' WHERE id=?`, req.DriverID, id); err != nil {
+			return err
+		}
+		// Historial
+		_, err := tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, id, old, "asignado", admin.ID, "Asignado a repartidor")
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	orderEvents.publish(OrderEvent{Type: orderEventAssigned, OrderID: orderID, OldStatus: &old, Status: "asignado", DriverID: &req.DriverID})
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// Reasignación forzada (solo admin): mueve un pedido asignado o en camino a otro
+// repartidor sin cambiar su estado.
+func reassignOrderHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req ReassignOrderReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.DriverID == 0 {
+		respondInvalid(c, "driver_id", "driver_id requerido")
+		return
+	}
+	admin, _ := currentUser(c)
+
+	var orderID int64
+	var status string
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		var oldDriver *int64
+		if err := tx.QueryRow(`SELECT id, status, assigned_driver_id FROM orders WHERE id=? FOR UPDATE`, id).Scan(&orderID, &status, &oldDriver); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+				return errResponded
+			}
+			return err
+		}
+		if status != "asignado" && status != "en_preparacion" && status != "en_camino" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "solo pedidos 'asignado', 'en_preparacion' o 'en_camino' pueden reasignarse"})
+			return errResponded
+		}
+		if oldDriver != nil && *oldDriver == req.DriverID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "el pedido ya está asignado a ese repartidor"})
+			return errResponded
+		}
+		var ok int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM users WHERE id=? AND role_id=? AND is_active=TRUE`, req.DriverID, roleDriver).Scan(&ok); err != nil {
+			return err
+		}
+		if ok == 0 {
+			respondInvalid(c, "driver_id", "driver_id inválido")
+			return errResponded
+		}
+
+		if _, err := tx.Exec(`UPDATE orders SET assigned_driver_id=? WHERE id=?`, req.DriverID, id); err != nil {
+			return err
+		}
+		note := "Reasignado a otro repartidor"
+		if req.Note != nil && *req.Note != "" {
+			note = *req.Note
+		}
+		_, err := tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note, old_driver_id, new_driver_id) VALUES (?,?,?,?,?,?,?)`,
+			id, status, status, admin.ID, note, oldDriver, req.DriverID)
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	orderEvents.publish(OrderEvent{Type: orderEventReassigned, OrderID: orderID, OldStatus: &status, Status: status, DriverID: &req.DriverID})
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// statusEdge es una transición permitida y los roles que la realizan en la práctica
+// (PATCH /assign, /start-transit, /cancel...). GET /api/v1/orders/transition-graph
+// se arma con esta misma tabla.
+type statusEdge struct {
+	To    string
+	Roles []int8
+}
+
+// Validaciones simples de transición
+var statusTransitions = map[string][]statusEdge{
+	"por_atender": {{"asignado", []int8{roleAdmin}}, {"cancelado", []int8{roleAdmin, roleDriver, roleCustomer}}},
+	"asignado":    {{"en_preparacion", []int8{roleAdmin, roleDriver}}, {"en_camino", []int8{roleAdmin, roleDriver}}, {"cancelado", []int8{roleAdmin, roleDriver, roleCustomer}}},
+	// en_preparacion es opcional: asignado → en_camino sigue siendo válido
+	"en_preparacion": {{"en_camino", []int8{roleAdmin, roleDriver}}, {"cancelado", []int8{roleAdmin, roleDriver, roleCustomer}}},
+	"en_camino":      {{"entregado", []int8{roleAdmin, roleDriver}}},
+}
+
+// findEdge devuelve la transición from → to de statusTransitions.
+func findEdge(from, to string) (statusEdge, bool) {
+	for _, e := range statusTransitions[from] {
+		if e.To == to {
+			return e, true
+		}
+	}
+	return statusEdge{}, false
+}
+
+// allows indica si el rol puede realizar la transición.
+func (e statusEdge) allows(role int8) bool {
+	return slices.Contains(e.Roles, role)
+}
+
+// Rango de cada estado en el ciclo de vida; una transición válida siempre sube de rango.
+var statusRank = map[string]int{
+	"por_atender":    1,
+	"asignado":       2,
+	"en_preparacion": 3,
+	"en_camino":      4,
+	"entregado":      5,
+}
+
+// knownStatus indica si el código es un estado de pedido conocido.
+func knownStatus(st string) bool {
+	_, ok := statusRank[st]
+	return ok || st == "cancelado"
+}
+
+// isForwardTransition rechaza retrocesos y transiciones al mismo rango,
+// independiente de statusTransitions. Cancelar es la única excepción explícita.
+func isForwardTransition(from, to string) bool {
+	if to == "cancelado" {
+		return true
+	}
+	fr, ok1 := statusRank[from]
+	tr, ok2 := statusRank[to]
+	return ok1 && ok2 && tr > fr
+}
+
+func updateOrderStatusHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req UpdateStatusReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.NewStatus == "" {
+		respondInvalid(c, "new_status", "new_status requerido")
+		return
+	}
+	req.Note = trimOptional(req.Note)
+	if f, ok := firstTooLong(lengthRule{"note", req.Note, maxNotesLen}); ok {
+		respondTooLong(c, f)
+		return
+	}
+	// Mismas reglas que /cancel: el historial registra a quien llama
+	if !canActOnOrder(c, id) {
+		return
+	}
+	u, _ := currentUser(c)
+	req.ChangedBy = u.ID
+	applyStatusChange(c, id, req)
+}
+
+// Cancelación explícita; mismo camino que el cambio de estado a 'cancelado'
+func cancelOrderHandler(c *gin.Context) {
+	id := c.Param("id")
+	var req CancelOrderReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	req.Note = trimOptional(req.Note)
+	if f, ok := firstTooLong(lengthRule{"note", req.Note, maxNotesLen}); ok {
+		respondTooLong(c, f)
+		return
+	}
+	// Un cliente solo cancela sus pedidos y un repartidor los asignados; quien cancela es quien llama
+	if !canActOnOrder(c, id) {
+		return
+	}
+	u, _ := currentUser(c)
+	applyStatusChange(c, id, UpdateStatusReq{NewStatus: "cancelado", Note: req.Note, ChangedBy: u.ID})
+}
+
+// Atajo del repartidor: asignado/en_preparacion → en_camino, registrando al repartidor en el historial.
+func startTransitHandler(c *gin.Context) {
+	id := c.Param("id")
+	var status string
+	var driverID *int64
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT status, assigned_driver_id FROM orders WHERE id=?`, id).Scan(&status, &driverID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	u, _ := currentUser(c)
+	if driverID == nil || *driverID != u.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "solo el repartidor asignado"})
+		return
+	}
+	if status != "asignado" && status != "en_preparacion" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "solo pedidos 'asignado' o 'en_preparacion' pueden salir en camino"})
+		return
+	}
+	note := "En camino"
+	applyStatusChange(c, id, UpdateStatusReq{NewStatus: "en_camino", Note: &note, ChangedBy: u.ID})
+}
+
+// applyStatusChange valida y aplica la transición en una transacción. Al cancelar
+// devuelve el stock reservado por el pedido.
+func applyStatusChange(c *gin.Context, id string, req UpdateStatusReq) {
+	var orderID int64
+	var old string
+	var driverID *int64
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`SELECT id, status, assigned_driver_id FROM orders WHERE id=? FOR UPDATE`, id).Scan(&orderID, &old, &driverID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "pedido no existe"})
+				return errResponded
+			}
+			return err
+		}
+
+		edge, allowed := findEdge(old, req.NewStatus)
+		if !allowed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("transición inválida %s → %s", old, req.NewStatus)})
+			return errResponded
+		}
+		// Los mismos roles que publica el grafo de transiciones
+		if u, _ := currentUser(c); !edge.allows(u.RoleID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("el rol no puede realizar la transición %s → %s", old, req.NewStatus)})
+			return errResponded
+		}
+		// Defensa adicional: aunque la tabla permita la transición, nunca retroceder de rango
+		if !isForwardTransition(old, req.NewStatus) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("transición regresiva %s(%d) → %s(%d)", old, statusRank[old], req.NewStatus, statusRank[req.NewStatus])})
+			return errResponded
+		}
+
+		q := `UPDATE orders SET status=?`
+		switch req.NewStatus {
+		case "en_camino":
+			q += `, transit_started_at=NOW()`
+		case "entregado":
+			// Siempre el reloj de la BD: el cliente nunca envía delivered_at
+			q += `, delivered_at=NOW()`
+		}
+		// Condicionado al estado leído: si otra transición ganó entre medio no se pisa
+		q += ` WHERE id=? AND status=?`
+		res, err := tx.Exec(q, req.NewStatus, id, old)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "el pedido cambió de estado, vuelva a intentarlo", "retryable": true})
+			return errResponded
+		}
+		if req.NewStatus == "cancelado" {
+			if err := restoreOrderStock(tx, id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, id, old, req.NewStatus, req.ChangedBy, req.Note)
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	orderEvents.publish(OrderEvent{Type: orderEventStatus, OrderID: orderID, OldStatus: &old, Status: req.NewStatus, DriverID: driverID})
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func listOrderHistoryHandler(c *gin.Context) {
+	id := c.Param("id")
+	page, pageSize, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+	if !canActOnOrder(c, id) {
+		return
+	}
+	where := `WHERE h.order_id=?`
+	args := []any{id}
+	if st := c.Query("new_status"); st != "" {
+		if !knownStatus(st) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new_status inválido"})
+			return
+		}
+		where += ` AND h.new_status=?`
+		args = append(args, st)
+	}
+
+	var total int
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT COUNT(*) FROM order_status_history h `+where, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// ?expand=actor agrega changed_by_user; LEFT JOIN porque el usuario puede ya no existir
+	expandActor := parseExpand(c)["actor"]
+	cols, from := `h.id, h.order_id, h.old_status, h.new_status, h.changed_by, h.changed_at, h.note, h.old_driver_id, h.new_driver_id`, `order_status_history h`
+	if expandActor {
+		cols += `, u.id, u.full_name, u.role_id`
+		from += ` LEFT JOIN users u ON u.id = h.changed_by`
+	}
+	// Orden cronológico (id ascendente)
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT `+cols+` FROM `+from+` `+where+` ORDER BY h.id LIMIT ? OFFSET ?`,
+		append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var hist []StatusHistory
+	var withActor []StatusHistoryWithActor
+	for rows.Next() {
+		var h StatusHistory
+		dest := []any{&h.ID, &h.OrderID, &h.OldStatus, &h.NewStatus, &h.ChangedBy, &h.ChangedAt, &h.Note, &h.OldDriverID, &h.NewDriverID}
+		var actorID *int64
+		var actorName *string
+		var actorRole *int8
+		if expandActor {
+			dest = append(dest, &actorID, &actorName, &actorRole)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !expandActor {
+			hist = append(hist, h)
+			continue
+		}
+		row := StatusHistoryWithActor{StatusHistory: h}
+		if actorID != nil {
+			row.ChangedByUser = &HistoryActor{ID: *actorID, FullName: *actorName, RoleID: *actorRole}
+		}
+		withActor = append(withActor, row)
+	}
+	setLinkHeader(c, page, pageSize, total)
+	if expandActor {
+		c.JSON(http.StatusOK, newPaginated(withActor, page, pageSize, total))
+		return
+	}
+	c.JSON(http.StatusOK, newPaginated(hist, page, pageSize, total))
+}
+
+// ==== HELPERS ====
+
+// validOrderQty verifica que la cantidad de una línea cumpla las restricciones
+// del producto. minQty/multiple en nil significan "sin restricción".
+func validOrderQty(qty int, minQty, multiple *int) bool {
+	if qty <= 0 {
+		return false
+	}
+	if minQty != nil && qty < *minQty {
+		return false
+	}
+	if multiple != nil && *multiple > 0 && qty%*multiple != 0 {
+		return false
+	}
+	return true
+}
+
+// validQtyConstraints valida los valores opcionales al crear/editar productos.
+func validQtyConstraints(minQty, multiple *int) bool {
+	if minQty != nil && *minQty <= 0 {
+		return false
+	}
+	if multiple != nil && *multiple <= 0 {
+		return false
+	}
+	return true
+}
+
+// validatePassword devuelve las reglas de la política que la contraseña no cumple
+// (vacío si es válida).
+func validatePassword(p string) []string {
+	var failed []string
+	if len([]rune(p)) < passwordMinLen {
+		failed = append(failed, fmt.Sprintf("mínimo %d caracteres", passwordMinLen))
+	}
+	hasDigit, hasLetter := false, false
+	for _, r := range p {
+		switch {
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsLetter(r):
+			hasLetter = true
+		}
+	}
+	if passwordRequireDigit && !hasDigit {
+		failed = append(failed, "debe incluir un número")
+	}
+	if passwordRequireLetter && !hasLetter {
+		failed = append(failed, "debe incluir una letra")
+	}
+	return failed
+}
+
+// hashPassword genera el hash bcrypt que se guarda en users.password_hash.
+func hashPassword(p string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(p), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+// checkPassword compara contra el hash guardado. Las filas antiguas del MVP
+// guardaban la contraseña en texto plano; se siguen aceptando hasta que se actualicen.
+func checkPassword(stored, given string) bool {
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(given)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(given)) == 1
+}
+
+// normalizeNumDoc recorta espacios y trata el documento vacío como ausente.
+func normalizeNumDoc(doc *string) *string {
+	if doc == nil {
+		return nil
+	}
+	d := strings.TrimSpace(*doc)
+	if d == "" {
+		return nil
+	}
+	return &d
+}
+
+// validNumDoc aplica el formato configurado (longitud y, opcionalmente, solo dígitos).
+func validNumDoc(doc string) bool {
+	if len(doc) < numDocMinLen || len(doc) > numDocMaxLen {
+		return false
+	}
+	if numDocDigitsOnly {
+		for _, r := range doc {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// numDocTaken indica si otro usuario (distinto de excludeID) ya usa el documento.
+func numDocTaken(ctx context.Context, doc *string, excludeID string) (bool, error) {
+	if doc == nil {
+		return false, nil
+	}
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(1) FROM users WHERE num_doc=? AND (?='' OR id<>?)`, *doc, excludeID, excludeID).Scan(&n)
+	return n > 0, err
+}
+
+// trimOptional recorta espacios de un campo opcional; vacío se guarda como NULL.
+func trimOptional(v *string) *string {
+	if v == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*v)
+	if t == "" {
+		return nil
+	}
+	return &t
+}
+
+// lengthRule es un límite de longitud (en caracteres) para un campo de texto.
+type lengthRule struct {
+	field string
+	value *string
+	max   int
+}
+
+// firstTooLong devuelve la primera regla cuyo valor excede el máximo.
+func firstTooLong(rules ...lengthRule) (lengthRule, bool) {
+	for _, r := range rules {
+		if r.value != nil && len([]rune(*r.value)) > r.max {
+			return r, true
+		}
+	}
+	return lengthRule{}, false
+}
+
+// respondInvalid responde 422 a un cuerpo bien formado que no pasa la validación
+// de negocio, indicando el campo. El JSON mal formado sigue siendo 400.
+func respondInvalid(c *gin.Context, field, msg string) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": msg, "field": field})
+}
+
+func respondTooLong(c *gin.Context, r lengthRule) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%s excede el máximo de %d caracteres", r.field, r.max), "field": r.field})
+}
+
+// toCents convierte un monto a céntimos redondeando al más cercano.
+func toCents(v float64) int64 {
+	return int64(math.Round(v * 100))
+}
+
+// setCents llena los campos *_cents. El total en céntimos se suma en enteros
+// para que subtotal_cents + delivery_fee_cents == total_cents siempre.
+func (o *Order) setCents() {
+	sub, fee, tax := toCents(o.Subtotal), toCents(o.DeliveryFee), toCents(o.Tax)
+	total := sub + fee + tax
+	o.SubtotalCents, o.DeliveryFeeCents, o.TaxCents, o.TotalCents = &sub, &fee, &tax, &total
+}
+
+func (it *OrderItem) setCents() {
+	unit := toCents(it.UnitPrice)
+	line := unit * int64(it.Qty)
+	it.UnitPriceCents, it.LineTotalCents = &unit, &line
+}
+
+// decimal es un monto en céntimos que se serializa como string con dos
+// decimales ("9.50"), para clientes que no quieren manejar float ni céntimos.
+type decimal int64
+
+func (d decimal) MarshalJSON() ([]byte, error) {
+	v, sign := int64(d), ""
+	if v < 0 {
+		v, sign = -v, "-"
+	}
+	return []byte(fmt.Sprintf(`"%s%d.%02d"`, sign, v/100, v%100)), nil
+}
+
+// setDecimals llena los campos *_decimal con la misma aritmética entera que setCents.
+func (o *Order) setDecimals() {
+	sub, fee, tax := decimal(toCents(o.Subtotal)), decimal(toCents(o.DeliveryFee)), decimal(toCents(o.Tax))
+	total := sub + fee + tax
+	o.SubtotalDecimal, o.DeliveryFeeDecimal, o.TaxDecimal, o.TotalDecimal = &sub, &fee, &tax, &total
+}
+
+func (it *OrderItem) setDecimals() {
+	unit := decimal(toCents(it.UnitPrice))
+	line := unit * decimal(it.Qty)
+	it.UnitPriceDecimal, it.LineTotalDecimal = &unit, &line
+}
+
+// Representaciones de montos seleccionables con ?money=
+const (
+	moneyFloat  = "float"
+	moneyCents  = "cents"
+	moneyString = "string"
+)
+
+func (o *Order) applyMoneyMode(mode string) {
+	switch mode {
+	case moneyCents:
+		o.setCents()
+	case moneyString:
+		o.setDecimals()
+	}
+}
+
+func (it *OrderItem) applyMoneyMode(mode string) {
+	switch mode {
+	case moneyCents:
+		it.setCents()
+	case moneyString:
+		it.setDecimals()
+	}
+}
+
+// moneyMode lee ?money= (float por defecto, cents o string). Responde 400 si el
+// valor no es válido y devuelve ok=false.
+func moneyMode(c *gin.Context) (mode string, ok bool) {
+	switch m := c.Query("money"); m {
+	case "", moneyFloat:
+		return moneyFloat, true
+	case moneyCents, moneyString:
+		return m, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "money debe ser float, cents o string"})
+	return "", false
+}
+
+// Collation insensible a tildes y mayúsculas para búsquedas con LIKE
+const searchCollation = "utf8mb4_unicode_ci"
+
+// normalizeSearch recorta, pasa a minúsculas y quita tildes ("José" → "jose"),
+// para que la búsqueda no dependa de cómo escribió el usuario.
+func normalizeSearch(q string) string {
+	q = strings.ToLower(strings.TrimSpace(q))
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	out, _, err := transform.String(t, q)
+	if err != nil {
+		return q
+	}
+	return out
+}
+
+// likeContains arma un patrón LIKE "%q%" escapando los comodines de q.
+func likeContains(q string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(q) + "%"
+}
+
+// phoneDigits deja solo los dígitos de un teléfono ("+51 999-888" → "51999888").
+func phoneDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// numericQuery valida que los query params indicados, si vienen, sean enteros.
+// Responde 400 con el primero que no lo sea y devuelve false.
+func numericQuery(c *gin.Context, names ...string) bool {
+	for _, name := range names {
+		if v := c.Query(name); v != "" {
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " debe ser numérico"})
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// dateWindowQuery lee un rango [from, to) de los query params indicados.
+// Acepta RFC3339 o YYYY-MM-DD (en appLocation); una fecha sola en "to"
+// incluye el día completo. Responde 400 si el formato o el orden es inválido.
+func dateWindowQuery(c *gin.Context, fromKey, toKey string) (from, to *time.Time, ok bool) {
+	parse := func(key string, endOfDay bool) (*time.Time, bool) {
+		v := c.Query(key)
+		if v == "" {
+			return nil, true
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return &t, true
+		}
+		t, err := time.ParseInLocation("2006-01-02", v, appLocation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": key + " debe ser RFC3339 o YYYY-MM-DD"})
+			return nil, false
+		}
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return &t, true
+	}
+	if from, ok = parse(fromKey, false); !ok {
+		return nil, nil, false
+	}
+	if to, ok = parse(toKey, true); !ok {
+		return nil, nil, false
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fromKey + " debe ser anterior a " + toKey})
+		return nil, nil, false
+	}
+	return from, to, true
+}
+
+// respondWithETag responde 200 con ETag (hash del JSON) o 304 sin cuerpo si el
+// cliente ya tiene esa versión (If-None-Match).
+func respondWithETag(c *gin.Context, body any) {
+	respondWithPrefixedETag(c, "", body)
+}
+
+// respondWithVersionETag antepone la versión del recurso al hash ("3.<hash>"): el
+// mismo ETag sirve para If-None-Match y como If-Match al editar.
+func respondWithVersionETag(c *gin.Context, version int, body any) {
+	respondWithPrefixedETag(c, strconv.Itoa(version)+".", body)
+}
+
+func respondWithPrefixedETag(c *gin.Context, prefix string, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + prefix + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// ifMatchVersion lee la versión esperada de If-Match ("3", 3, W/"3" o el ETag del
+// GET, "3.<hash>"; del ETag solo cuenta la versión). Sin cabecera responde 428. El
+// comodín * no se acepta (400): saltaría el control de concurrencia, que es
+// justamente lo que If-Match exige.
+func ifMatchVersion(c *gin.Context) (int, bool) {
+	v := strings.TrimSpace(c.GetHeader("If-Match"))
+	if v == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match requerido con la version del recurso"})
+		return 0, false
+	}
+	version, _, _ := strings.Cut(strings.Trim(strings.TrimPrefix(v, "W/"), `"`), ".")
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "If-Match debe ser la version del recurso"})
+		return 0, false
+	}
+	return n, true
+}
+
+// etagMatches soporta listas ("a", "b"), el comodín * y ETags débiles (W/"...").
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExpand interpreta ?expand=a,b como conjunto {a, b}.
+func parseExpand(c *gin.Context) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range strings.Split(c.Query("expand"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// ==== STATUSES ====
+
+// Columnas de etiqueta por idioma; un lang desconocido cae en defaultStatusLang.
+var statusLabelColumns = map[string]string{
+	"es": "label_es",
+	"en": "label_en",
+}
+
+const defaultStatusLang = "es"
+
+func listStatusesHandler(c *gin.Context) {
+	col, ok := statusLabelColumns[c.Query("lang")]
+	if !ok {
+		col = statusLabelColumns[defaultStatusLang]
+	}
+	// col sale de un mapa fijo, no del request, por eso es seguro interpolarlo.
+	// Si falta la traducción usamos la etiqueta por defecto.
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT code, COALESCE(` + col + `, label_es), color FROM statuses ORDER BY sort_order, code`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var list []OrderStatus
+	for rows.Next() {
+		var st OrderStatus
+		if err := rows.Scan(&st.Code, &st.Label, &st.Color); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, st)
+	}
+	c.JSON(http.StatusOK, list)
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	gin.DefaultErrorWriter = io.Discard
+	log.SetOutput(io.Discard)
+	jwtSecret = []byte("test-secret")
+	os.Exit(m.Run())
+}
+
+// Usuarios de prueba
+var (
+	testAdmin    = User{ID: 1, RoleID: roleAdmin, FullName: "Admin", IsActive: true}
+	testDriver   = User{ID: 2, RoleID: roleDriver, FullName: "Repartidor", IsActive: true}
+	testCustomer = User{ID: 3, RoleID: roleCustomer, FullName: "Cliente", IsActive: true}
+	otherUser    = User{ID: 4, RoleID: roleCustomer, FullName: "Otro cliente", IsActive: true}
+)
+
+// testNow es un instante fijo para filas con fechas (respuestas comparables entre peticiones).
+var testNow = time.Date(2026, 10, 15, 14, 30, 0, 0, time.UTC)
+
+// newMock reemplaza db por un sqlmock durante el test y al final verifica que se
+// ejecutaron todas las consultas esperadas.
+func newMock(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db
+	db = mockDB
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db = prev
+		mockDB.Close()
+	})
+	return mock
+}
+
+// sqlText escapa un fragmento de SQL para usarlo como patrón de sqlmock.
+func sqlText(s string) string { return regexp.QuoteMeta(s) }
+
+// setVar cambia una variable de configuración durante el test.
+func setVar[T any](t *testing.T, v *T, val T) {
+	t.Helper()
+	prev := *v
+	*v = val
+	t.Cleanup(func() { *v = prev })
+}
+
+// countRows es el resultado de un SELECT COUNT.
+func countRows(n int) *sqlmock.Rows { return sqlmock.NewRows([]string{"n"}).AddRow(n) }
+
+// authAs devuelve la cabecera Authorization con un token de acceso para u y
+// espera la lectura del usuario que hace optionalAuth en cada petición.
+func authAs(t *testing.T, mock sqlmock.Sqlmock, u User) http.Header {
+	t.Helper()
+	token, _, err := issueToken(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(sqlText(`FROM users WHERE id=?`)).WithArgs(u.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_id", "full_name", "phone", "email", "num_doc", "is_active", "branch_id"}).
+			AddRow(u.ID, u.RoleID, u.FullName, u.Phone, u.Email, u.NumDoc, u.IsActive, u.BranchID))
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// serve ejecuta una petición contra el router completo.
+func serve(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	return serveWith(newRouter(), method, path, body, header)
+}
+
+func serveWith(h http.Handler, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != "" && header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// decode lee el cuerpo JSON de la respuesta.
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var out map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("respuesta no es un objeto JSON: %v: %s", err, w.Body.String())
+	}
+	return out
+}
+
+// expectStatus falla el test si el código no es el esperado, mostrando el cuerpo.
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, quiero %d; cuerpo: %s", w.Code, want, w.Body.String())
+	}
+}
@@ -0,0 +1,8 @@
+-- Restricciones de cantidad por producto (ej. solo venta en packs de 6)
+ALTER TABLE products
+  ADD COLUMN min_qty      INT NULL,
+  ADD COLUMN qty_multiple INT NULL;
+
+-- Notas:
+-- - NULL significa "sin restricción" (comportamiento previo).
+-- - POST /api/v1/orders rechaza con 400 las líneas con qty < min_qty o que no sean múltiplo de qty_multiple.
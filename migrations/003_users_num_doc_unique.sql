@@ -0,0 +1,4 @@
+-- num_doc único (los NULL no colisionan en MySQL)
+-- Antes de aplicar, revisar duplicados existentes:
+--   SELECT num_doc, COUNT(*) FROM users WHERE num_doc IS NOT NULL GROUP BY num_doc HAVING COUNT(*) > 1;
+ALTER TABLE users ADD UNIQUE KEY uq_users_num_doc (num_doc);
@@ -0,0 +1,19 @@
+-- Catálogo de estados de pedido con etiquetas por idioma
+CREATE TABLE IF NOT EXISTS statuses (
+  code       VARCHAR(20)  NOT NULL PRIMARY KEY,
+  label_es   VARCHAR(50)  NOT NULL,
+  label_en   VARCHAR(50)  NULL,
+  color      VARCHAR(7)   NULL,
+  sort_order INT          NOT NULL DEFAULT 0
+);
+
+INSERT INTO statuses(code, label_es, label_en, color, sort_order) VALUES
+  ('por_atender', 'Por atender', 'Pending',    '#F59E0B', 1),
+  ('asignado',    'Asignado',    'Assigned',   '#3B82F6', 2),
+  ('en_camino',   'En camino',   'On the way', '#8B5CF6', 3),
+  ('entregado',   'Entregado',   'Delivered',  '#10B981', 4),
+  ('cancelado',   'Cancelado',   'Cancelled',  '#EF4444', 5)
+ON DUPLICATE KEY UPDATE label_es=VALUES(label_es), label_en=VALUES(label_en), color=VALUES(color), sort_order=VALUES(sort_order);
+
+-- Notas:
+-- - Para un idioma nuevo: agregar columna label_xx y registrarla en statusLabelColumns (main.go).
@@ -0,0 +1,15 @@
+-- Tramos de tarifa de delivery por distancia (km desde el almacén)
+CREATE TABLE IF NOT EXISTS delivery_fee_tiers (
+  id     BIGINT AUTO_INCREMENT PRIMARY KEY,
+  max_km DECIMAL(6,2)  NOT NULL,
+  fee    DECIMAL(10,2) NOT NULL,
+  UNIQUE KEY uq_delivery_fee_tiers_max_km (max_km)
+);
+
+-- Ejemplo:
+-- INSERT INTO delivery_fee_tiers(max_km, fee) VALUES (2, 0), (5, 3.00), (10, 6.00);
+
+-- Notas:
+-- - Se usa el primer tramo cuyo max_km cubre la distancia (max_km inclusive).
+-- - Más allá del último tramo se cobra DELIVERY_FEE_FALLBACK (o la tarifa del último tramo).
+-- - Tras editar la tabla: POST /api/v1/admin/delivery-fee-tiers/reload
@@ -0,0 +1,7 @@
+-- Reasignaciones: guardar repartidor anterior y nuevo en el historial
+ALTER TABLE order_status_history
+  ADD COLUMN old_driver_id BIGINT NULL,
+  ADD COLUMN new_driver_id BIGINT NULL;
+
+-- Notas:
+-- - Solo se llenan en PATCH /api/v1/orders/:id/reassign (old_status = new_status).
@@ -0,0 +1,10 @@
+-- Feature flags por entorno para endpoints experimentales
+CREATE TABLE IF NOT EXISTS feature_flags (
+  name       VARCHAR(50) NOT NULL PRIMARY KEY,
+  enabled    BOOLEAN     NOT NULL DEFAULT FALSE,
+  updated_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Notas:
+-- - La variable de entorno FEATURE_<NOMBRE>=true|false tiene prioridad sobre la tabla.
+-- - Tras editar la tabla: POST /api/v1/admin/flags/reload
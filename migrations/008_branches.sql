@@ -0,0 +1,19 @@
+-- Sucursales (multi-tenancy de catálogo y pedidos)
+CREATE TABLE IF NOT EXISTS branches (
+  id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+  name       VARCHAR(100) NOT NULL,
+  is_active  BOOLEAN      NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Sucursal por defecto: todos los datos existentes quedan aquí
+INSERT INTO branches(id, name) VALUES (1, 'Principal')
+ON DUPLICATE KEY UPDATE name=name;
+
+ALTER TABLE products ADD COLUMN branch_id BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE orders   ADD COLUMN branch_id BIGINT NOT NULL DEFAULT 1;
+-- NULL = usuario sin sucursal fija (ej. admin general)
+ALTER TABLE users    ADD COLUMN branch_id BIGINT NULL;
+
+CREATE INDEX idx_products_branch ON products(branch_id);
+CREATE INDEX idx_orders_branch   ON orders(branch_id);
@@ -0,0 +1,7 @@
+-- Quién registró el pedido (agente de soporte o el propio cliente)
+ALTER TABLE orders ADD COLUMN created_by BIGINT NULL;
+
+-- Backfill: los pedidos existentes se consideran autoservicio
+UPDATE orders SET created_by = customer_id WHERE created_by IS NULL;
+
+ALTER TABLE orders MODIFY created_by BIGINT NOT NULL;
@@ -0,0 +1,2 @@
+-- Disponibilidad del repartidor para recibir pedidos (solo aplica a role_id = 2)
+ALTER TABLE users ADD COLUMN is_available BOOLEAN NOT NULL DEFAULT TRUE;
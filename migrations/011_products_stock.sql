@@ -0,0 +1,9 @@
+-- Stock por producto. NULL = ilimitado (comportamiento previo).
+ALTER TABLE products ADD COLUMN stock INT NULL;
+
+-- Marca para devolver el stock de un pedido cancelado una sola vez
+ALTER TABLE orders ADD COLUMN stock_restored BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Notas:
+-- - POST /api/v1/orders y PUT /api/v1/orders/:id/items descuentan stock (400 si no alcanza).
+-- - Cancelar (POST /api/v1/orders/:id/cancel o PATCH .../status a 'cancelado') lo devuelve.
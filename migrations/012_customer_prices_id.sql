@@ -0,0 +1,8 @@
+-- Clave sustituta para customer_product_prices (DELETE /api/v1/customer_prices/:id)
+ALTER TABLE customer_product_prices
+  DROP PRIMARY KEY,
+  ADD COLUMN id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST,
+  ADD UNIQUE KEY uq_cpp_customer_product (customer_id, product_id);
+
+-- Notas:
+-- - El UNIQUE (customer_id, product_id) mantiene el upsert con ON DUPLICATE KEY UPDATE.
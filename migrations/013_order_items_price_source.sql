@@ -0,0 +1,8 @@
+-- De dónde salió el unit_price de cada línea (para disputas de precio)
+ALTER TABLE order_items
+  ADD COLUMN price_source ENUM('base','custom','tier') NOT NULL DEFAULT 'base';
+
+-- Notas:
+-- - Se calcula al crear el pedido o editar sus ítems: 'custom' si aplicó un precio personalizado activo.
+-- - Las filas anteriores a esta migración quedan como 'base' (no se puede reconstruir el origen).
+-- - 'tier' queda reservado para precios por volumen.
@@ -0,0 +1,8 @@
+-- Zona horaria del cliente para interpretar scheduled_at sin offset
+ALTER TABLE users
+  ADD COLUMN timezone VARCHAR(64) NULL;
+
+-- Notas:
+-- - Nombre IANA (ej. America/Lima), validado por la API con time.LoadLocation.
+-- - NULL = se usa la zona del servidor (APP_TIMEZONE).
+-- - orders.scheduled_at se guarda siempre en UTC.
@@ -0,0 +1,9 @@
+-- Prueba de entrega registrada por el repartidor
+ALTER TABLE orders
+  ADD COLUMN proof_url VARCHAR(500) NULL,
+  ADD COLUMN signature_name VARCHAR(120) NULL,
+  ADD COLUMN proof_at DATETIME NULL;
+
+-- Notas:
+-- - Se guarda solo la referencia (URL http/https); el archivo vive fuera de la BD.
+-- - Un nuevo POST /api/v1/orders/:id/proof reemplaza la prueba anterior.
@@ -0,0 +1,9 @@
+-- Total persistido del pedido (antes se derivaba en cada consulta)
+ALTER TABLE orders
+  ADD COLUMN total DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER delivery_fee;
+
+UPDATE orders SET total = subtotal + delivery_fee;
+
+-- Notas:
+-- - La API lo mantiene igual a subtotal + delivery_fee en cada cambio (syncOrderTotal).
+-- - POST /api/v1/admin/orders/recompute-totals corrige filas desfasadas (ej. ediciones manuales).
@@ -0,0 +1,8 @@
+-- Franja de entrega pedida por el cliente ("entre 14:00 y 17:00")
+ALTER TABLE orders
+  ADD COLUMN delivery_window_start DATETIME NULL AFTER scheduled_at,
+  ADD COLUMN delivery_window_end DATETIME NULL AFTER delivery_window_start;
+
+-- Notas:
+-- - Se guardan en UTC, igual que scheduled_at.
+-- - Un pedido tiene scheduled_at (hora exacta) o la franja, nunca ambos; la API lo valida.
@@ -0,0 +1,6 @@
+-- Momento en que el repartidor salió hacia la entrega (paso a en_camino)
+ALTER TABLE orders
+  ADD COLUMN transit_started_at DATETIME NULL;
+
+-- Notas:
+-- - Lo completa cualquier transición a en_camino (POST /start-transit o PATCH /status).
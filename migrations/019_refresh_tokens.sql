@@ -0,0 +1,18 @@
+-- Refresh tokens rotativos (sesiones largas sin access tokens largos)
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+  user_id    BIGINT    NOT NULL,
+  token_hash CHAR(64)  NOT NULL,
+  family_id  CHAR(32)  NOT NULL,
+  expires_at DATETIME  NOT NULL,
+  revoked_at DATETIME  NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_refresh_tokens_hash (token_hash),
+  KEY idx_refresh_tokens_family (family_id),
+  KEY idx_refresh_tokens_user (user_id)
+);
+
+-- Notas:
+-- - token_hash = sha256 del token; el token en claro solo lo tiene el cliente.
+-- - family_id agrupa los tokens de una sesión: al detectar reuso se revoca la familia.
+-- - Las filas expiradas o revocadas se pueden borrar periódicamente.
@@ -0,0 +1,17 @@
+-- Una sola dirección por defecto por usuario, garantizado también por la BD
+
+-- Limpieza: si algún usuario tiene varias, se conserva la más reciente
+UPDATE addresses a
+JOIN (SELECT user_id, MAX(id) AS keep_id FROM addresses WHERE is_default=TRUE GROUP BY user_id HAVING COUNT(*) > 1) d
+  ON d.user_id = a.user_id
+SET a.is_default = FALSE
+WHERE a.is_default = TRUE AND a.id <> d.keep_id;
+
+-- NULL para las no-default: el índice único solo restringe a las default
+ALTER TABLE addresses
+  ADD COLUMN default_user_id BIGINT AS (IF(is_default, user_id, NULL)) STORED,
+  ADD UNIQUE KEY uq_addresses_default_user (default_user_id);
+
+-- Notas:
+-- - La API serializa los cambios por usuario (SELECT ... FOR UPDATE sobre users),
+--   así que el índice solo salta si alguien escribe directo en la BD.
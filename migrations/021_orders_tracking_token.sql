@@ -0,0 +1,8 @@
+-- Token opaco para seguir un pedido sin cuenta (GET /api/v1/track/:token)
+ALTER TABLE orders
+  ADD COLUMN tracking_token CHAR(64) NULL,
+  ADD UNIQUE KEY uq_orders_tracking_token (tracking_token);
+
+-- Notas:
+-- - Se genera al crear el pedido (32 bytes aleatorios en hex). Los pedidos
+--   anteriores quedan con NULL y no se pueden seguir por token.
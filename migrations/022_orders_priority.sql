@@ -0,0 +1,8 @@
+-- Urgencia del pedido (ej. un negocio se quedó sin agua)
+ALTER TABLE orders
+  ADD COLUMN priority VARCHAR(10) NOT NULL DEFAULT 'normal' AFTER status,
+  ADD KEY idx_orders_status_priority (status, priority);
+
+-- Notas:
+-- - Valores: normal | high (la API valida el valor).
+-- - Los pedidos existentes quedan como normal.
@@ -0,0 +1,11 @@
+-- Impuesto del pedido
+ALTER TABLE orders
+  ADD COLUMN tax DECIMAL(10,2) NOT NULL DEFAULT 0 AFTER delivery_fee,
+  ADD COLUMN tax_rate DECIMAL(6,4) NOT NULL DEFAULT 0 AFTER tax,
+  ADD COLUMN tax_delivery BOOLEAN NOT NULL DEFAULT FALSE AFTER tax_rate;
+
+-- Notas:
+-- - total = subtotal + delivery_fee + tax.
+-- - tax_rate y tax_delivery son los de la configuración al crear el pedido; las
+--   ediciones recalculan tax con esos valores.
+-- - Los pedidos existentes quedan sin impuesto (tax 0), así que su total no cambia.
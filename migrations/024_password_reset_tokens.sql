@@ -0,0 +1,17 @@
+-- Tokens de un solo uso para restablecer la contraseña
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+  id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+  user_id    BIGINT    NOT NULL,
+  token_hash CHAR(64)  NOT NULL,
+  channel    VARCHAR(10) NOT NULL, -- email | sms
+  expires_at DATETIME  NOT NULL,
+  used_at    DATETIME  NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_password_reset_tokens_hash (token_hash),
+  KEY idx_password_reset_tokens_user (user_id)
+);
+
+-- Notas:
+-- - token_hash = sha256 del token; el token en claro solo se envía al usuario.
+-- - Pedir un token nuevo invalida (used_at) los pendientes del mismo usuario.
+-- - Las filas usadas o expiradas se pueden borrar periódicamente.
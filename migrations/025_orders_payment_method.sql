@@ -0,0 +1,8 @@
+-- Medio de pago del pedido (conciliación de efectivo vs tarjeta)
+ALTER TABLE orders
+  ADD COLUMN payment_method VARCHAR(20) NULL AFTER priority,
+  ADD KEY idx_orders_payment_method (payment_method);
+
+-- Notas:
+-- - Valores: cash | card | transfer (la API valida el valor).
+-- - Los pedidos existentes quedan en NULL (el reporte los agrupa como "unknown").
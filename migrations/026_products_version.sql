@@ -0,0 +1,7 @@
+-- Versión del producto para control de concurrencia optimista (If-Match en PUT)
+ALTER TABLE products
+  ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+-- Notas:
+-- - Cada PUT /api/v1/products/:id incrementa version.
+-- - Los productos existentes empiezan en 1.
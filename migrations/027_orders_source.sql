@@ -0,0 +1,7 @@
+-- Canal por el que entró el pedido (análisis de mezcla de canales)
+ALTER TABLE orders
+  ADD COLUMN source VARCHAR(10) NOT NULL DEFAULT 'web' AFTER payment_method;
+
+-- Notas:
+-- - Valores: web | app | phone (la API valida el valor).
+-- - Los pedidos existentes quedan como web.
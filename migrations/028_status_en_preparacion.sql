@@ -0,0 +1,12 @@
+-- Estado en_preparacion: el almacén arma el pedido después de asignarlo y antes
+-- de que el repartidor salga
+INSERT INTO statuses(code, label_es, label_en, color, sort_order) VALUES
+  ('en_preparacion', 'En preparación', 'Preparing', '#06B6D4', 3)
+ON DUPLICATE KEY UPDATE label_es=VALUES(label_es), label_en=VALUES(label_en), color=VALUES(color), sort_order=VALUES(sort_order);
+
+UPDATE statuses SET sort_order=4 WHERE code='en_camino';
+UPDATE statuses SET sort_order=5 WHERE code='entregado';
+UPDATE statuses SET sort_order=6 WHERE code='cancelado';
+
+-- Notas:
+-- - Es opcional: asignado → en_camino sigue permitido para los clientes que no lo usan.
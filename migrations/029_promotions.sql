@@ -0,0 +1,22 @@
+-- Promociones por producto con vigencia, para todos los clientes
+CREATE TABLE IF NOT EXISTS promotions (
+  id               BIGINT AUTO_INCREMENT PRIMARY KEY,
+  product_id       BIGINT        NOT NULL,
+  discount_percent DECIMAL(5,2)  NULL,
+  promo_price      DECIMAL(10,2) NULL,
+  starts_at        DATETIME      NOT NULL,
+  ends_at          DATETIME      NOT NULL,
+  is_active        BOOLEAN       NOT NULL DEFAULT TRUE,
+  created_at       TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  KEY idx_promotions_product_window (product_id, starts_at, ends_at)
+);
+
+ALTER TABLE order_items
+  MODIFY COLUMN price_source ENUM('base','custom','tier','promo') NOT NULL DEFAULT 'base',
+  ADD COLUMN promotion_id BIGINT NULL AFTER price_source;
+
+-- Notas:
+-- - Exactamente uno de discount_percent (0-100) o promo_price (la API lo valida).
+-- - Precedencia del precio efectivo: precio del cliente > promoción vigente > precio base.
+-- - Con varias promociones vigentes del mismo producto gana la de menor precio.
+-- - order_items.promotion_id guarda la promoción aplicada a la línea.
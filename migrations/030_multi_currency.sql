@@ -0,0 +1,20 @@
+-- Moneda de los precios de productos y de clientes, y precios directos por moneda
+ALTER TABLE products
+  ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'PEN' AFTER price;
+
+ALTER TABLE customer_product_prices
+  ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'PEN' AFTER price;
+
+CREATE TABLE IF NOT EXISTS product_prices (
+  product_id BIGINT        NOT NULL,
+  currency   CHAR(3)       NOT NULL,
+  price      DECIMAL(10,2) NOT NULL,
+  PRIMARY KEY (product_id, currency)
+);
+
+-- Notas:
+-- - El DEFAULT 'PEN' deja los precios existentes en la moneda base por defecto;
+--   si BASE_CURRENCY es otra, actualizar esas filas. La API siempre envía la moneda.
+-- - product_prices guarda precios fijados a mano en otras monedas; sin fila, el
+--   catálogo convierte con CURRENCY_RATES.
+-- - Los pedidos (order_items, totales) quedan en la moneda base.
@@ -0,0 +1,30 @@
+-- Asegura la clave única (customer_id, product_id) de customer_product_prices.
+-- La migración 012 la crea; si una base quedó sin ella (restauraciones, cambios a
+-- mano) primero se depuran los duplicados y después se agrega la clave.
+
+-- Por cada par duplicado queda la fila que usa el cálculo de precios: la activa más
+-- reciente o, si ninguna está activa, la más reciente.
+DELETE cpp FROM customer_product_prices cpp
+JOIN (
+  SELECT customer_id, product_id,
+         COALESCE(MAX(CASE WHEN is_active THEN id END), MAX(id)) AS kept_id
+  FROM customer_product_prices
+  GROUP BY customer_id, product_id
+  HAVING COUNT(*) > 1
+) d ON d.customer_id = cpp.customer_id AND d.product_id = cpp.product_id AND cpp.id <> d.kept_id;
+
+SET @has_key := (
+  SELECT COUNT(*) FROM information_schema.statistics
+  WHERE table_schema = DATABASE() AND table_name = 'customer_product_prices'
+    AND index_name = 'uq_cpp_customer_product');
+SET @ddl := IF(@has_key = 0,
+  'ALTER TABLE customer_product_prices ADD UNIQUE KEY uq_cpp_customer_product (customer_id, product_id)',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- Notas:
+-- - Con la clave garantizada, el upsert (ON DUPLICATE KEY UPDATE) nunca deja más
+--   de una fila por par; el handler ya no necesita comprobarlo.
+-- - GET /api/v1/admin/data-check sigue informando duplicate_customer_prices.
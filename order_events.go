@@ -0,0 +1,132 @@
+package main
+
+// Eventos de pedidos en vivo por Server-Sent Events (tablero de operaciones).
+// Los handlers que crean, asignan o cambian de estado un pedido publican en un
+// broker en memoria después del commit; GET /api/v1/orders/stream los reenvía a
+// cada suscriptor. Es por proceso: con varias instancias cada una ve solo sus cambios.
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tipos de evento (campo event: del SSE)
+const (
+	orderEventCreated    = "created"
+	orderEventAssigned   = "assigned"
+	orderEventReassigned = "reassigned"
+	orderEventStatus     = "status_changed"
+)
+
+type OrderEvent struct {
+	Type      string    `json:"type"`
+	OrderID   int64     `json:"order_id"`
+	OldStatus *string   `json:"old_status,omitempty"`
+	Status    string    `json:"status"`
+	DriverID  *int64    `json:"driver_id,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Cada suscriptor tiene un buffer; si no lo vacía a tiempo (cliente lento) los
+// eventos se descartan para no frenar a quien publica.
+const orderEventBuffer = 32
+
+// Intervalo de los comentarios de keep-alive para que proxies no corten la conexión
+var orderStreamHeartbeat = 15 * time.Second
+
+type orderBroker struct {
+	mu   sync.Mutex
+	subs map[chan OrderEvent]struct{}
+}
+
+var orderEvents = &orderBroker{subs: map[chan OrderEvent]struct{}{}}
+
+func (b *orderBroker) subscribe() chan OrderEvent {
+	ch := make(chan OrderEvent, orderEventBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *orderBroker) unsubscribe(ch chan OrderEvent) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+func (b *orderBroker) publish(ev OrderEvent) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// GET /api/v1/orders/stream (admin o repartidor), opcional ?driver_id=, ?status=
+// Un repartidor solo recibe los eventos de sus pedidos.
+func orderStreamHandler(c *gin.Context) {
+	if !numericQuery(c, "driver_id") {
+		return
+	}
+	var driverID *int64
+	if v := c.Query("driver_id"); v != "" {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		driverID = &id
+	}
+	if u, _ := currentUser(c); u.RoleID == roleDriver {
+		driverID = &u.ID
+	}
+	status := c.Query("status")
+	if status != "" && !knownStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status inválido"})
+		return
+	}
+
+	ch := orderEvents.subscribe()
+	defer orderEvents.unsubscribe(ch)
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // sin buffer en nginx
+	c.Status(http.StatusOK)
+	c.Writer.WriteString(": conectado\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(orderStreamHeartbeat)
+	defer heartbeat.Stop()
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			// El cliente cerró la conexión
+			return
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case ev := <-ch:
+			if driverID != nil && (ev.DriverID == nil || *ev.DriverID != *driverID) {
+				continue
+			}
+			if status != "" && ev.Status != status {
+				continue
+			}
+			c.SSEvent(ev.Type, ev)
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// openOrderStream se conecta a GET /api/v1/orders/stream en un servidor real (el
+// recorder de httptest no sirve para una respuesta que no termina) y espera el
+// comentario inicial, que se escribe ya suscrito al broker.
+func openOrderStream(t *testing.T, srv *httptest.Server, query string, header http.Header) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/orders/stream"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content-type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, err := r.ReadString('\n'); err != nil || line != ": conectado\n" {
+		t.Fatalf("primera línea = %q, %v", line, err)
+	}
+	return r
+}
+
+// nextOrderEvent lee el próximo evento del stream, saltando comentarios.
+func nextOrderEvent(t *testing.T, r *bufio.Reader) (string, OrderEvent) {
+	t.Helper()
+	var name string
+	var ev OrderEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream cortado: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &ev); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && name != "":
+			return name, ev
+		}
+	}
+}
+
+func TestOrderStream(t *testing.T) {
+	setVar(t, &dbFlags, map[string]bool{flagOrderStream: true})
+
+	t.Run("recibe el cambio de estado", func(t *testing.T) {
+		mock := newMock(t)
+		srv := httptest.NewServer(newRouter())
+		t.Cleanup(srv.Close) // después de cortar el stream (las limpiezas van en orden inverso)
+		stream := openOrderStream(t, srv, "", authAs(t, mock, testAdmin))
+
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusEnCamino, testDriver.ID)
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=?, delivered_at=NOW() WHERE id=? AND status=?`)).
+			WithArgs(statusEntregado, "10", statusEnCamino).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		w := serveWith(srv.Config.Handler, http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"entregado","changed_by":1}`, h)
+		expectStatus(t, w, http.StatusOK)
+
+		name, ev := nextOrderEvent(t, stream)
+		if name != orderEventStatus || ev.OrderID != 10 || ev.Status != statusEntregado || ev.OldStatus == nil || *ev.OldStatus != statusEnCamino {
+			t.Errorf("evento %s = %+v", name, ev)
+		}
+	})
+	t.Run("un repartidor solo recibe los suyos", func(t *testing.T) {
+		mock := newMock(t)
+		srv := httptest.NewServer(newRouter())
+		t.Cleanup(srv.Close)
+		// ?driver_id= de otro repartidor no aplica: se filtra por quien llama
+		stream := openOrderStream(t, srv, "?driver_id=99&status=asignado", authAs(t, mock, testDriver))
+
+		other, own := int64(99), testDriver.ID
+		orderEvents.publish(OrderEvent{Type: orderEventAssigned, OrderID: 20, Status: statusAsignado, DriverID: &other})
+		orderEvents.publish(OrderEvent{Type: orderEventCreated, OrderID: 21, Status: statusPorAtender})
+		orderEvents.publish(OrderEvent{Type: orderEventStatus, OrderID: 22, Status: statusEnCamino, DriverID: &own})
+		orderEvents.publish(OrderEvent{Type: orderEventAssigned, OrderID: 23, Status: statusAsignado, DriverID: &own})
+
+		if name, ev := nextOrderEvent(t, stream); name != orderEventAssigned || ev.OrderID != 23 {
+			t.Errorf("evento %s = %+v, quiero el pedido 23", name, ev)
+		}
+	})
+	t.Run("solo admin o repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/orders/stream", "", authAs(t, mock, testCustomer))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("status inválido", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/orders/stream?status=perdido", "", authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
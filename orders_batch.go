@@ -0,0 +1,100 @@
+package main
+
+// Consulta de varios pedidos en una sola llamada (dashboards que siguen pedidos).
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxBatchOrders = 50
+
+// GET /api/v1/orders/batch?ids=1,2,3 (autenticado; opcional ?money=, ?currency_format=true)
+// Devuelve los pedidos en el orden pedido con la misma forma que el listado. Los
+// ids inexistentes, o de otro cliente cuando llama un cliente, van a not_found.
+func batchOrdersHandler(c *gin.Context) {
+	raw := strings.TrimSpace(c.Query("ids"))
+	if raw == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids requerido"})
+		return
+	}
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ids debe ser una lista de números separados por coma"})
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxBatchOrders {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("como máximo %d ids por consulta", maxBatchOrders)})
+		return
+	}
+	money, ok := moneyMode(c)
+	if !ok {
+		return
+	}
+	formatted, ok := currencyFormat(c)
+	if !ok {
+		return
+	}
+
+	where := ` WHERE o.id IN (?` + strings.Repeat(",?", len(ids)-1) + `)`
+	args := make([]any, 0, len(ids)+1)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	// Igual que canActOnOrder: un cliente solo ve sus pedidos y un repartidor los
+	// que tiene asignados; el resto cae en not_found
+	switch u, _ := currentUser(c); u.RoleID {
+	case roleCustomer:
+		where += ` AND o.customer_id=?`
+		args = append(args, u.ID)
+	case roleDriver:
+		where += ` AND o.assigned_driver_id=?`
+		args = append(args, u.ID)
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT o.id, o.customer_id, o.address_id, o.branch_id, o.created_by, o.assigned_driver_id, o.status, o.priority, o.payment_method, o.source, o.subtotal, o.delivery_fee, o.tax, o.total, o.notes, o.scheduled_at, o.delivered_at, o.created_at, o.delivery_window_start, o.delivery_window_end FROM orders o`+where, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	found := map[int64]Order{}
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(&o.ID, &o.CustomerID, &o.AddressID, &o.BranchID, &o.CreatedBy, &o.AssignedDriverID, &o.Status, &o.Priority, &o.PaymentMethod, &o.Source, &o.Subtotal, &o.DeliveryFee, &o.Tax, &o.Total, &o.Notes, &o.ScheduledAt, &o.DeliveredAt, &o.CreatedAt, &o.DeliveryWindowStart, &o.DeliveryWindowEnd); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		o.applyMoneyMode(money)
+		if formatted {
+			o.setFormatted()
+		}
+		found[o.ID] = o
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	orders := make([]Order, 0, len(found))
+	notFound := []int64{}
+	for _, id := range ids {
+		if o, ok := found[id]; ok {
+			orders = append(orders, o)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": orders, "not_found": notFound})
+}
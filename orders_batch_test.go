@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBatchOrders(t *testing.T) {
+	t.Run("en el orden pedido", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		// Los ids repetidos se consultan una sola vez
+		mock.ExpectQuery(sqlText(`FROM orders o WHERE o.id IN (?,?,?)`)).WithArgs(int64(12), int64(10), int64(99)).
+			WillReturnRows(orderListRows(sampleOrder(10), sampleOrder(12)))
+
+		w := serve(http.MethodGet, "/api/v1/orders/batch?ids=12,10,12,99", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		data, _ := body["data"].([]any)
+		notFound, _ := body["not_found"].([]any)
+		if len(data) != 2 || data[0].(map[string]any)["id"] != float64(12) || data[1].(map[string]any)["id"] != float64(10) {
+			t.Errorf("data = %v", data)
+		}
+		if len(notFound) != 1 || notFound[0] != float64(99) {
+			t.Errorf("not_found = %v", notFound)
+		}
+	})
+	t.Run("un cliente solo ve los suyos", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, otherUser)
+		mock.ExpectQuery(sqlText(`WHERE o.id IN (?) AND o.customer_id=?`)).WithArgs(int64(10), otherUser.ID).
+			WillReturnRows(orderListRows())
+
+		w := serve(http.MethodGet, "/api/v1/orders/batch?ids=10", "", h)
+		expectStatus(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), `"not_found":[10]`) {
+			t.Errorf("cuerpo = %s", w.Body.String())
+		}
+	})
+	t.Run("un repartidor solo ve los asignados", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testDriver)
+		mock.ExpectQuery(sqlText(`WHERE o.id IN (?) AND o.assigned_driver_id=?`)).WithArgs(int64(10), testDriver.ID).
+			WillReturnRows(orderListRows())
+
+		w := serve(http.MethodGet, "/api/v1/orders/batch?ids=10", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	ids := make([]string, maxBatchOrders+1)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	for _, tc := range []struct{ name, ids string }{
+		{"sin ids", ""},
+		{"no numérico", "1,x"},
+		{"cero", "0"},
+		{"demasiados", strings.Join(ids, ",")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			w := serve(http.MethodGet, "/api/v1/orders/batch?ids="+tc.ids, "", authAs(t, mock, testAdmin))
+			expectStatus(t, w, http.StatusBadRequest)
+		})
+	}
+}
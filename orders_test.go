@@ -0,0 +1,1468 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListStatusesLabels(t *testing.T) {
+	cases := []struct {
+		lang, column string
+	}{
+		{"en", "COALESCE(label_en, label_es)"},
+		{"", "COALESCE(label_es, label_es)"},
+		{"fr", "COALESCE(label_es, label_es)"},
+	}
+	for _, tc := range cases {
+		t.Run("lang="+tc.lang, func(t *testing.T) {
+			mock := newMock(t)
+			mock.ExpectQuery(sqlText(`SELECT code, ` + tc.column + `, color FROM statuses ORDER BY sort_order, code`)).
+				WillReturnRows(sqlmock.NewRows([]string{"code", "label", "color"}).AddRow(statusEntregado, "Delivered", "#0a0"))
+			w := serve(http.MethodGet, "/api/v1/statuses?lang="+tc.lang, "", nil)
+			expectStatus(t, w, http.StatusOK)
+			if !strings.Contains(w.Body.String(), `"label":"Delivered"`) {
+				t.Errorf("cuerpo = %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTransitionGraph(t *testing.T) {
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`SELECT code, COALESCE(label_en, label_es), color FROM statuses`)).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "label", "color"}).
+			AddRow(statusPorAtender, "Pending", nil).
+			AddRow(statusEntregado, "Delivered", "#0a0").
+			AddRow("archivado", "Archived", nil))
+	w := serve(http.MethodGet, "/api/v1/orders/transition-graph?lang=en", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	var graph struct {
+		Nodes []GraphNode `json:"nodes"`
+		Edges []GraphEdge `json:"edges"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &graph); err != nil {
+		t.Fatal(err)
+	}
+	nodes := map[string]GraphNode{}
+	for _, n := range graph.Nodes {
+		nodes[n.Code] = n
+	}
+	if _, ok := nodes["archivado"]; ok {
+		t.Error("un código desconocido no es un nodo")
+	}
+	// cancelado no tiene fila en statuses pero sale de las transiciones
+	if n := nodes[statusCancelado]; n.Label != statusCancelado || !n.Terminal {
+		t.Errorf("nodo cancelado = %+v", n)
+	}
+	if n := nodes[statusEntregado]; n.Label != "Delivered" || !n.Terminal {
+		t.Errorf("nodo entregado = %+v", n)
+	}
+
+	want := 0
+	for _, out := range statusTransitions {
+		want += len(out)
+	}
+	if len(graph.Edges) != want {
+		t.Fatalf("%d aristas, quiero %d", len(graph.Edges), want)
+	}
+	if first := graph.Edges[0]; first.From != statusPorAtender {
+		t.Errorf("primera arista = %+v", first)
+	}
+	for _, e := range graph.Edges {
+		if e.From == statusPorAtender && e.To == statusCancelado && !slices.Contains(e.Roles, "customer") {
+			t.Errorf("el cliente puede cancelar un pedido por atender: %+v", e)
+		}
+	}
+}
+
+func TestIsForwardTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{statusPorAtender, statusAsignado, true},
+		{statusAsignado, statusEnCamino, true},
+		{statusEnCamino, statusAsignado, false},
+		{statusEntregado, statusEnCamino, false},
+		{statusAsignado, statusAsignado, false},
+		{statusEnCamino, statusCancelado, true},
+		{"desconocido", statusEntregado, false},
+	}
+	for _, tc := range cases {
+		if got := isForwardTransition(tc.from, tc.to); got != tc.want {
+			t.Errorf("isForwardTransition(%s, %s) = %v, quiero %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+// expectOrderForUpdate espera el bloqueo del pedido al inicio de applyStatusChange.
+func expectOrderForUpdate(mock sqlmock.Sqlmock, orderID int64, status string, driverID any) {
+	mock.ExpectQuery(sqlText(`SELECT id, status, assigned_driver_id FROM orders WHERE id=? FOR UPDATE`)).
+		WithArgs(strconv.FormatInt(orderID, 10)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "assigned_driver_id"}).AddRow(orderID, status, driverID))
+}
+
+func TestUpdateOrderStatusRejectsRegression(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectBegin()
+	expectOrderForUpdate(mock, 10, statusEnCamino, testDriver.ID)
+	mock.ExpectRollback()
+
+	w := serve(http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"asignado","changed_by":1}`, h)
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestUpdateOrderStatusForbiddenRole(t *testing.T) {
+	// asignado → en_camino es solo de admin o repartidor en el grafo
+	if e, _ := findEdge(statusAsignado, statusEnCamino); e.allows(roleCustomer) {
+		t.Fatal("el grafo no debe permitir al cliente poner en camino")
+	}
+	mock := newMock(t)
+	h := authAs(t, mock, testCustomer)
+	expectBranchOf(mock, "orders", 10, defaultBranchID)
+	expectOrderOwner(mock, 10, testCustomer.ID, testDriver.ID)
+	mock.ExpectBegin()
+	expectOrderForUpdate(mock, 10, statusAsignado, testDriver.ID)
+	mock.ExpectRollback()
+
+	w := serve(http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"en_camino"}`, h)
+	expectStatus(t, w, http.StatusForbidden)
+}
+
+func TestUpdateOrderStatusLostRace(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectBegin()
+	expectOrderForUpdate(mock, 10, statusEnCamino, testDriver.ID)
+	// Otra transición cambió el estado entre la lectura y el UPDATE condicionado
+	mock.ExpectExec(sqlText(`UPDATE orders SET status=?, delivered_at=NOW() WHERE id=? AND status=?`)).
+		WithArgs(statusEntregado, "10", statusEnCamino).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	w := serve(http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"entregado","changed_by":1}`, h)
+	expectStatus(t, w, http.StatusConflict)
+	if got := decode(t, w)["retryable"]; got != true {
+		t.Errorf("retryable = %v", got)
+	}
+}
+
+var orderDetailColumns = []string{"id", "customer_id", "address_id", "branch_id", "created_by", "assigned_driver_id", "status", "priority", "payment_method", "source", "subtotal", "delivery_fee", "tax", "total", "notes", "scheduled_at", "delivered_at", "created_at", "delivery_window_start", "delivery_window_end", "transit_started_at", "proof_url", "signature_name", "proof_at"}
+
+var orderItemColumns = []string{"id", "order_id", "product_id", "qty", "unit_price", "line_total", "price_source", "promotion_id", "name", "capacity_liters"}
+
+// sampleOrder es un pedido por atender de testCustomer en la sucursal por defecto.
+func sampleOrder(id int64) Order {
+	return Order{ID: id, CustomerID: testCustomer.ID, AddressID: 20, BranchID: defaultBranchID, CreatedBy: testCustomer.ID, Status: statusPorAtender, Priority: "normal", Source: "app", Subtotal: 20, DeliveryFee: 5, Total: 25}
+}
+
+// expectGetOrder espera las lecturas de getOrderHandler: el pedido y sus ítems.
+func expectGetOrder(mock sqlmock.Sqlmock, o Order) {
+	expectOrderDetail(mock, o)
+	mock.ExpectQuery(sqlText(`FROM order_items oi JOIN products p`)).WithArgs(strconv.FormatInt(o.ID, 10)).
+		WillReturnRows(sqlmock.NewRows(orderItemColumns).AddRow(1, o.ID, 7, 2, 10.0, 20.0, priceSourceBase, nil, "Bidón 20L", 20.0))
+}
+
+func expectOrderDetail(mock sqlmock.Sqlmock, o Order) {
+	mock.ExpectQuery(sqlText(`FROM orders WHERE id=?`)).WithArgs(strconv.FormatInt(o.ID, 10)).
+		WillReturnRows(sqlmock.NewRows(orderDetailColumns).AddRow(o.ID, o.CustomerID, o.AddressID, o.BranchID, o.CreatedBy, o.AssignedDriverID, o.Status, o.Priority, o.PaymentMethod, o.Source, o.Subtotal, o.DeliveryFee, o.Tax, o.Total, o.Notes, nil, nil, testNow, nil, nil, nil, nil, nil, nil))
+}
+
+// expectOrderOwner espera la lectura de canActOnOrder.
+func expectOrderOwner(mock sqlmock.Sqlmock, orderID, customerID int64, driverID any) {
+	mock.ExpectQuery(sqlText(`SELECT customer_id, assigned_driver_id FROM orders WHERE id=?`)).WithArgs(strconv.FormatInt(orderID, 10)).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_id", "assigned_driver_id"}).AddRow(customerID, driverID))
+}
+
+func TestGetOrderExpandsCustomerAndAddress(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	o := sampleOrder(10)
+	expectGetOrder(mock, o)
+	mock.ExpectQuery(sqlText(`SELECT id, full_name, phone FROM users WHERE id=?`)).WithArgs(o.CustomerID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "full_name", "phone"}).AddRow(o.CustomerID, "Cliente", "+51987654321"))
+	mock.ExpectQuery(sqlText(`SELECT street, reference, lat, lng FROM addresses WHERE id=?`)).WithArgs(o.AddressID).
+		WillReturnRows(sqlmock.NewRows([]string{"street", "reference", "lat", "lng"}).AddRow("Av. Arequipa 123", nil, -12.1, -77.03))
+
+	w := serve(http.MethodGet, "/api/v1/orders/10?expand=customer,address", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	customer, _ := body["customer"].(map[string]any)
+	if customer["full_name"] != "Cliente" {
+		t.Errorf("customer = %v", body["customer"])
+	}
+	address, _ := body["address"].(map[string]any)
+	if address["street"] != "Av. Arequipa 123" {
+		t.Errorf("address = %v", body["address"])
+	}
+}
+
+func TestGetOrderWithoutExpand(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectGetOrder(mock, sampleOrder(10))
+
+	w := serve(http.MethodGet, "/api/v1/orders/10", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if _, ok := body["customer"]; ok {
+		t.Error("customer no debe venir sin ?expand=customer")
+	}
+}
+
+func TestOrderCents(t *testing.T) {
+	o := Order{Subtotal: 0.1 + 0.2, DeliveryFee: 4.995, Tax: 1.005}
+	o.setCents()
+	if *o.SubtotalCents != 30 || *o.DeliveryFeeCents != 500 || *o.TaxCents != 100 {
+		t.Errorf("céntimos = %d, %d, %d", *o.SubtotalCents, *o.DeliveryFeeCents, *o.TaxCents)
+	}
+	if *o.TotalCents != *o.SubtotalCents+*o.DeliveryFeeCents+*o.TaxCents {
+		t.Errorf("total_cents = %d no es la suma de las partes", *o.TotalCents)
+	}
+}
+
+func TestGetOrderMoneyCents(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectGetOrder(mock, sampleOrder(10))
+
+	w := serve(http.MethodGet, "/api/v1/orders/10?money=cents", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["total_cents"] != float64(2500) {
+		t.Errorf("total_cents = %v", body["total_cents"])
+	}
+	items, _ := body["items"].([]any)
+	if len(items) != 1 || items[0].(map[string]any)["line_total_cents"] != float64(2000) {
+		t.Errorf("items = %v", body["items"])
+	}
+}
+
+func TestGetOrderRejectsUnknownMoneyMode(t *testing.T) {
+	mock := newMock(t)
+	w := serve(http.MethodGet, "/api/v1/orders/10?money=bitcoin", "", authAs(t, mock, testAdmin))
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestReassignOrder(t *testing.T) {
+	t.Run("pedido en camino a otro repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusEnCamino, testDriver.ID)
+		mock.ExpectQuery(sqlText(`SELECT COUNT(1) FROM users WHERE id=? AND role_id=? AND is_active=TRUE`)).WithArgs(int64(5), roleDriver).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+		mock.ExpectExec(sqlText(`UPDATE orders SET assigned_driver_id=? WHERE id=?`)).WithArgs(int64(5), "10").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note, old_driver_id, new_driver_id)`)).
+			WithArgs("10", statusEnCamino, statusEnCamino, testAdmin.ID, "Reasignado a otro repartidor", testDriver.ID, int64(5)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/reassign", `{"driver_id":5}`, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("mismo repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusAsignado, testDriver.ID)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/reassign", `{"driver_id":2}`, h)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPatch, "/api/v1/orders/10/reassign", `{"driver_id":5}`, authAs(t, mock, testDriver))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+// expectBranchOf espera la verificación de sucursal de branchScoped.
+func expectBranchOf(mock sqlmock.Sqlmock, table string, id, branchID int64) {
+	mock.ExpectQuery(sqlText(`SELECT branch_id FROM ` + table + ` WHERE id=?`)).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"branch_id"}).AddRow(branchID))
+}
+
+// expectSyncTotal espera el recálculo de tax y total de syncOrderTotal.
+func expectSyncTotal(mock sqlmock.Sqlmock, orderID any, tax, total float64) {
+	mock.ExpectExec(sqlText(`UPDATE orders SET tax = `)).WithArgs(orderID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(sqlText(`SELECT tax, total FROM orders WHERE id=?`)).WithArgs(orderID).
+		WillReturnRows(sqlmock.NewRows([]string{"tax", "total"}).AddRow(tax, total))
+}
+
+func TestUpdateOrderItems(t *testing.T) {
+	expectLocked := func(mock sqlmock.Sqlmock, status string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT status, customer_id, branch_id, delivery_fee FROM orders WHERE id=? FOR UPDATE`)).WithArgs(int64(10)).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "customer_id", "branch_id", "delivery_fee"}).AddRow(status, testCustomer.ID, defaultBranchID, 5.0))
+	}
+
+	t.Run("el cliente dueño re-precia los ítems", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectLocked(mock, statusPorAtender)
+		mock.ExpectExec(sqlText(`UPDATE products p`)).WithArgs(int64(10)).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectPricing(mock, testCustomer.ID, 7, baseProduct(12))
+		mock.ExpectExec(sqlText(`DELETE FROM order_items WHERE order_id=?`)).WithArgs(int64(10)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_items`)).WithArgs(int64(10), int64(7), 3, 12.0, priceSourceBase, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(sqlText(`UPDATE products SET stock = stock - ?`)).WithArgs(3, int64(7), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`UPDATE orders SET subtotal=? WHERE id=?`)).WithArgs(36.0, int64(10)).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectSyncTotal(mock, int64(10), 0, 41)
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).
+			WithArgs(int64(10), statusPorAtender, statusPorAtender, testCustomer.ID, "Ítems modificados").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPut, "/api/v1/orders/10/items", `{"items":[{"product_id":7,"qty":3}]}`, h)
+		expectStatus(t, w, http.StatusOK)
+		if body := decode(t, w); body["subtotal"] != 36.0 || body["total"] != 41.0 {
+			t.Errorf("cuerpo = %v", body)
+		}
+	})
+	t.Run("otro cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, otherUser)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectLocked(mock, statusPorAtender)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPut, "/api/v1/orders/10/items", `{"items":[{"product_id":7,"qty":3}]}`, h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("pedido ya asignado", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectLocked(mock, statusAsignado)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPut, "/api/v1/orders/10/items", `{"items":[{"product_id":7,"qty":3}]}`, h)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("id no numérico", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPut, "/api/v1/orders/abc/items", `{"items":[{"product_id":7,"qty":3}]}`, authAs(t, mock, testCustomer))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+var historyColumns = []string{"id", "order_id", "old_status", "new_status", "changed_by", "changed_at", "note", "old_driver_id", "new_driver_id"}
+
+func TestListOrderHistoryFilterAndPagination(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM order_status_history h WHERE h.order_id=? AND h.new_status=?`)).WithArgs("10", statusAsignado).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(3))
+	mock.ExpectQuery(sqlText(`FROM order_status_history h WHERE h.order_id=? AND h.new_status=? ORDER BY h.id LIMIT ? OFFSET ?`)).
+		WithArgs("10", statusAsignado, 2, 2).
+		WillReturnRows(sqlmock.NewRows(historyColumns).AddRow(5, 10, statusPorAtender, statusAsignado, 1, testNow, nil, nil, 2))
+
+	w := serve(http.MethodGet, "/api/v1/orders/10/history?new_status=asignado&page=2&page_size=2", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["total"] != float64(3) || body["page"] != float64(2) {
+		t.Errorf("cuerpo = %v", body)
+	}
+}
+
+func TestListOrderHistoryExpandActor(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM order_status_history h WHERE h.order_id=?`)).WithArgs("10").WillReturnRows(countRows(2))
+	mock.ExpectQuery(sqlText(`FROM order_status_history h LEFT JOIN users u ON u.id = h.changed_by WHERE h.order_id=?`)).
+		WithArgs("10", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(append(historyColumns, "actor_id", "actor_name", "actor_role")).
+			AddRow(1, 10, nil, statusPorAtender, 3, testNow, "Pedido creado", nil, nil, 3, "Cliente", roleCustomer).
+			// El usuario que hizo el cambio ya no existe
+			AddRow(2, 10, statusPorAtender, statusAsignado, 8, testNow, nil, nil, 2, nil, nil, nil))
+
+	w := serve(http.MethodGet, "/api/v1/orders/10/history?expand=actor", "", h)
+	expectStatus(t, w, http.StatusOK)
+	items, _ := decode(t, w)["data"].([]any)
+	if len(items) != 2 {
+		t.Fatalf("data = %v", items)
+	}
+	actor, _ := items[0].(map[string]any)["changed_by_user"].(map[string]any)
+	if actor["full_name"] != "Cliente" || actor["role_id"] != float64(roleCustomer) {
+		t.Errorf("changed_by_user = %v", actor)
+	}
+	if got := items[1].(map[string]any)["changed_by_user"]; got != nil {
+		t.Errorf("actor borrado = %v, quiero null", got)
+	}
+}
+
+func TestListOrderHistoryRejectsUnknownStatus(t *testing.T) {
+	mock := newMock(t)
+	w := serve(http.MethodGet, "/api/v1/orders/10/history?new_status=perdido", "", authAs(t, mock, testAdmin))
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `"abc"`
+	cases := map[string]bool{
+		`"abc"`:      true,
+		`W/"abc"`:    true,
+		`"x", "abc"`: true,
+		`*`:          true,
+		`"abd"`:      false,
+		``:           false,
+	}
+	for header, want := range cases {
+		if got := etagMatches(header, etag); got != want {
+			t.Errorf("etagMatches(%q) = %v, quiero %v", header, got, want)
+		}
+	}
+}
+
+func TestGetOrderIfNoneMatch(t *testing.T) {
+	mock := newMock(t)
+	o := sampleOrder(10)
+	h := authAs(t, mock, testAdmin)
+	expectGetOrder(mock, o)
+	w := serve(http.MethodGet, "/api/v1/orders/10", "", h)
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("falta ETag")
+	}
+
+	h = authAs(t, mock, testAdmin)
+	h.Set("If-None-Match", etag)
+	expectGetOrder(mock, o)
+	w = serve(http.MethodGet, "/api/v1/orders/10", "", h)
+	expectStatus(t, w, http.StatusNotModified)
+	if w.Body.Len() != 0 {
+		t.Errorf("304 con cuerpo: %s", w.Body.String())
+	}
+}
+
+// orderLine es un ítem del pedido y la fila de producto que lee priceOrderItems.
+type orderLine struct {
+	productID int64
+	qty       int
+	product   productRow
+}
+
+// expectBranchActive espera la validación de resolveBranch.
+func expectBranchActive(mock sqlmock.Sqlmock, branchID int64) {
+	mock.ExpectQuery(sqlText(`SELECT COUNT(1) FROM branches WHERE id=? AND is_active=TRUE`)).WithArgs(branchID).
+		WillReturnRows(countRows(1))
+}
+
+// expectPrepareOrder espera las lecturas de prepareOrder para un pedido de
+// testCustomer a la dirección 20 (sin coordenadas), con dirección por defecto.
+func expectPrepareOrder(mock sqlmock.Sqlmock, lines ...orderLine) {
+	mock.ExpectQuery(sqlText(`SELECT role_id, is_active, timezone FROM users WHERE id=?`)).WithArgs(testCustomer.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "is_active", "timezone"}).AddRow(roleCustomer, true, nil))
+	for _, l := range lines {
+		expectPricing(mock, testCustomer.ID, l.productID, l.product)
+	}
+	mock.ExpectQuery(sqlText(`SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`)).WithArgs(int64(20), testCustomer.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"lat", "lng"}).AddRow(nil, nil))
+	mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM addresses WHERE user_id=? AND is_default=1`)).WithArgs(testCustomer.ID).
+		WillReturnRows(countRows(1))
+}
+
+// insertOrderArgs son los argumentos del INSERT INTO orders con createdBy fijo
+// y el resto sin verificar.
+func insertOrderArgs(createdBy int64) []driver.Value {
+	args := make([]driver.Value, 20)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[3] = createdBy
+	return args
+}
+
+// expectInsertOrder espera las escrituras de createOrderHandler para el pedido
+// orderID creado por createdBy; stock ilimitado en todas las líneas.
+func expectInsertOrder(mock sqlmock.Sqlmock, orderID, createdBy int64, lines ...orderLine) {
+	mock.ExpectExec(sqlText(`INSERT INTO orders(`)).WithArgs(insertOrderArgs(createdBy)...).WillReturnResult(sqlmock.NewResult(orderID, 1))
+	for range lines {
+		mock.ExpectExec(sqlText(`INSERT INTO order_items`)).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	for _, l := range lines {
+		mock.ExpectExec(sqlText(`UPDATE products SET stock = stock - ?`)).WithArgs(l.qty, l.productID, l.qty).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs(orderID, nil, statusPorAtender, createdBy, "Pedido creado").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// freshRateLimiter aísla el cupo de creación de pedidos del test.
+func freshRateLimiter(t *testing.T) {
+	setVar(t, &orderCreateLimiter, &rateLimiter{hits: map[string][]time.Time{}})
+}
+
+func TestCreateOrderCreatedBy(t *testing.T) {
+	line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+	const body = `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`
+
+	t.Run("agente a nombre del cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectPrepareOrder(mock, line)
+		expectInsertOrder(mock, 50, testAdmin.ID, line)
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/orders", body, h)
+		expectStatus(t, w, http.StatusCreated)
+		if got := decode(t, w)["order_id"]; got != float64(50) {
+			t.Errorf("order_id = %v", got)
+		}
+	})
+	t.Run("autoservicio sin autenticación", func(t *testing.T) {
+		mock := newMock(t)
+		freshRateLimiter(t)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectPrepareOrder(mock, line)
+		expectInsertOrder(mock, 51, testCustomer.ID, line)
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/orders", body, nil)
+		expectStatus(t, w, http.StatusCreated)
+	})
+}
+
+func TestCreateOrderRejectsInvalidCustomer(t *testing.T) {
+	cases := []struct {
+		name   string
+		role   int8
+		active bool
+	}{
+		{"cliente inactivo", roleCustomer, false},
+		{"no es cliente", roleDriver, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			h := authAs(t, mock, testAdmin)
+			expectBranchActive(mock, defaultBranchID)
+			mock.ExpectBegin()
+			mock.ExpectQuery(sqlText(`SELECT role_id, is_active, timezone FROM users WHERE id=?`)).WithArgs(testCustomer.ID).
+				WillReturnRows(sqlmock.NewRows([]string{"role_id", "is_active", "timezone"}).AddRow(tc.role, tc.active, nil))
+			mock.ExpectRollback()
+
+			w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`, h)
+			expectStatus(t, w, http.StatusUnprocessableEntity)
+			if got := decode(t, w)["field"]; got != "customer_id" {
+				t.Errorf("field = %v", got)
+			}
+		})
+	}
+}
+
+func TestCancelOrderByOwnerRestoresStock(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testCustomer)
+	expectBranchOf(mock, "orders", 10, defaultBranchID)
+	expectOrderOwner(mock, 10, testCustomer.ID, nil)
+	mock.ExpectBegin()
+	expectOrderForUpdate(mock, 10, statusPorAtender, nil)
+	mock.ExpectExec(sqlText(`UPDATE orders SET status=? WHERE id=? AND status=?`)).WithArgs(statusCancelado, "10", statusPorAtender).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlText(`UPDATE orders SET stock_restored=TRUE`)).WithArgs("10").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlText(`SET p.stock = p.stock + oi.qty`)).WithArgs("10").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs("10", statusPorAtender, statusCancelado, testCustomer.ID, "Ya no lo necesito").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/api/v1/orders/10/cancel", `{"note":" Ya no lo necesito "}`, h)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestCancelOrderRequiresOwnerOrAssignedDriver(t *testing.T) {
+	cases := []struct {
+		name     string
+		user     User
+		driverID any
+	}{
+		{"otro cliente", otherUser, nil},
+		{"repartidor no asignado", testDriver, int64(99)},
+		{"sin repartidor asignado", testDriver, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			h := authAs(t, mock, tc.user)
+			expectBranchOf(mock, "orders", 10, defaultBranchID)
+			expectOrderOwner(mock, 10, testCustomer.ID, tc.driverID)
+
+			w := serve(http.MethodPost, "/api/v1/orders/10/cancel", `{}`, h)
+			expectStatus(t, w, http.StatusForbidden)
+		})
+	}
+
+	t.Run("sin autenticación", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodPost, "/api/v1/orders/10/cancel", `{}`, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+}
+
+func TestOrderRoutesRequireOwnerOrAdmin(t *testing.T) {
+	routes := []struct{ method, path, body string }{
+		{http.MethodGet, "/api/v1/orders/10", ""},
+		{http.MethodGet, "/api/v1/orders/10/history", ""},
+		{http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"cancelado"}`},
+		{http.MethodPatch, "/api/v1/orders/10/assign", `{"driver_id":2}`},
+	}
+	for _, rt := range routes {
+		t.Run("sin autenticación "+rt.method+" "+rt.path, func(t *testing.T) {
+			newMock(t)
+			w := serve(rt.method, rt.path, rt.body, nil)
+			expectStatus(t, w, http.StatusUnauthorized)
+		})
+	}
+
+	t.Run("pedido de otro cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, otherUser)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectOrderDetail(mock, sampleOrder(10))
+		w := serve(http.MethodGet, "/api/v1/orders/10?expand=customer", "", h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("historial de otro cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, otherUser)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectOrderOwner(mock, 10, testCustomer.ID, nil)
+		w := serve(http.MethodGet, "/api/v1/orders/10/history", "", h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("cancelar por estado un pedido ajeno", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, otherUser)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectOrderOwner(mock, 10, testCustomer.ID, nil)
+		w := serve(http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"cancelado","changed_by":1}`, h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("asignar no es de repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPatch, "/api/v1/orders/10/assign", `{"driver_id":2}`, authAs(t, mock, testDriver))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+func TestOrderHistoryRecordsCaller(t *testing.T) {
+	t.Run("cambio de estado ignora changed_by del body", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectOrderOwner(mock, 10, testCustomer.ID, nil)
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusPorAtender, nil)
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=? WHERE id=? AND status=?`)).WithArgs(statusCancelado, "10", statusPorAtender).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`UPDATE orders SET stock_restored=TRUE`)).WithArgs("10").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`SET p.stock = p.stock + oi.qty`)).WithArgs("10").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs("10", statusPorAtender, statusCancelado, testCustomer.ID, nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"cancelado","changed_by":1}`, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("asignación registra al admin", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT id, status FROM orders WHERE id=? FOR UPDATE`)).WithArgs("10").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(10, statusPorAtender))
+		mock.ExpectExec(sqlText(`UPDATE orders SET assigned_driver_id=?, status='asignado' WHERE id=?`)).WithArgs(testDriver.ID, "10").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs("10", statusPorAtender, statusAsignado, testAdmin.ID, "Asignado a repartidor").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/assign", `{"driver_id":2}`, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+}
+
+func TestOrderSoftWarnings(t *testing.T) {
+	mock := newMock(t)
+	setVar(t, &warehouseLat, nil)
+	mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM addresses WHERE user_id=? AND is_default=1`)).WithArgs(testCustomer.ID).WillReturnRows(countRows(0))
+	mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM addresses WHERE user_id=? AND is_default=1`)).WithArgs(testCustomer.ID).WillReturnRows(countRows(1))
+
+	past := sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}
+	lat, lng := -12.1, -77.0
+	got, err := orderSoftWarnings(db, testCustomer.ID, past, &lat, &lng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"el cliente no tiene dirección por defecto", "delivery_fee es 0: faltan las coordenadas del almacén", "scheduled_at está en el pasado"}
+	if !slices.Equal(got, want) {
+		t.Errorf("avisos = %q", got)
+	}
+
+	got, err = orderSoftWarnings(db, testCustomer.ID, sql.NullTime{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"delivery_fee es 0: la dirección no tiene coordenadas"}) {
+		t.Errorf("avisos = %q", got)
+	}
+}
+
+func TestCreateOrderReturnsWarnings(t *testing.T) {
+	line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectBranchActive(mock, defaultBranchID)
+	mock.ExpectBegin()
+	expectPrepareOrder(mock, line)
+	expectInsertOrder(mock, 50, testAdmin.ID, line)
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`, h)
+	expectStatus(t, w, http.StatusCreated)
+	warnings, _ := decode(t, w)["warnings"].([]any)
+	if len(warnings) != 1 || warnings[0] != "delivery_fee es 0: la dirección no tiene coordenadas" {
+		t.Errorf("warnings = %v", warnings)
+	}
+}
+
+var orderListColumns = orderDetailColumns[:20]
+
+// orderListRows arma las filas de listOrdersHandler.
+func orderListRows(orders ...Order) *sqlmock.Rows {
+	rows := sqlmock.NewRows(orderListColumns)
+	for _, o := range orders {
+		rows.AddRow(o.ID, o.CustomerID, o.AddressID, o.BranchID, o.CreatedBy, o.AssignedDriverID, o.Status, o.Priority, o.PaymentMethod, o.Source, o.Subtotal, o.DeliveryFee, o.Tax, o.Total, o.Notes, nil, nil, testNow, nil, nil)
+	}
+	return rows
+}
+
+func TestListOrdersScheduledWindow(t *testing.T) {
+	setVar(t, &appLocation, time.UTC)
+	from := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	t.Run("solo programados", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`WHERE o.branch_id=? AND o.scheduled_at>=? AND o.scheduled_at<? ORDER BY o.id DESC`)).
+			WithArgs(defaultBranchID, from, to).WillReturnRows(orderListRows(sampleOrder(10)))
+		w := serve(http.MethodGet, "/api/v1/orders?scheduled_from=2026-10-15&scheduled_to=2026-10-15", "", nil)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("con los no programados", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`((o.scheduled_at>=?) OR o.scheduled_at IS NULL)`)).
+			WithArgs(defaultBranchID, from).WillReturnRows(orderListRows())
+		w := serve(http.MethodGet, "/api/v1/orders?scheduled_from=2026-10-15T00:00:00Z&include_unscheduled=true", "", nil)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("ventana invertida", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/orders?scheduled_from=2026-10-16&scheduled_to=2026-10-14", "", nil)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("fecha inválida", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/orders?scheduled_to=mañana", "", nil)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestListOrdersByCustomerPhone(t *testing.T) {
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`FROM orders o JOIN users u ON u.id=o.customer_id WHERE o.branch_id=? AND REGEXP_REPLACE(u.phone, '[^0-9]', '') IN (?,?)`)).
+		WithArgs(defaultBranchID, "999888777", "51999888777").WillReturnRows(orderListRows(sampleOrder(10)))
+	w := serve(http.MethodGet, "/api/v1/orders?customer_phone=999-888-777", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	newMock(t)
+	w = serve(http.MethodGet, "/api/v1/orders?customer_phone=sin-numero", "", nil)
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestListOrdersExpandCancellation(t *testing.T) {
+	cancelled := sampleOrder(10)
+	cancelled.Status = statusCancelado
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`FROM orders o WHERE o.branch_id=?`)).WithArgs(defaultBranchID).
+		WillReturnRows(orderListRows(cancelled, sampleOrder(11)))
+	// Solo se busca el motivo de los cancelados
+	mock.ExpectQuery(sqlText(`WHERE new_status='cancelado' AND order_id IN (?)`)).WithArgs(int64(10)).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id", "note", "changed_at"}).AddRow(10, "Cliente ausente", testNow))
+
+	w := serve(http.MethodGet, "/api/v1/orders?expand=cancellation", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	var out []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || len(out) != 2 {
+		t.Fatalf("respuesta = %s", w.Body.String())
+	}
+	if out[0]["cancellation_reason"] != "Cliente ausente" || out[0]["cancelled_at"] == nil {
+		t.Errorf("pedido cancelado = %v", out[0])
+	}
+	if _, ok := out[1]["cancellation_reason"]; ok {
+		t.Errorf("pedido activo con cancellation_reason: %v", out[1])
+	}
+}
+
+func TestOrderPriority(t *testing.T) {
+	t.Run("urgentes primero en la cola", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`WHERE o.branch_id=? AND o.status=? ORDER BY o.priority='high' DESC, o.id DESC`)).
+			WithArgs(defaultBranchID, statusPorAtender).WillReturnRows(orderListRows())
+		w := serve(http.MethodGet, "/api/v1/orders?status=por_atender", "", nil)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("?sort= explícito manda", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`WHERE o.branch_id=? AND o.status=? ORDER BY o.created_at ASC, o.id ASC`)).
+			WithArgs(defaultBranchID, statusPorAtender).WillReturnRows(orderListRows())
+		w := serve(http.MethodGet, "/api/v1/orders?status=por_atender&sort=created_at&order=asc", "", nil)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("prioridad inválida", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"priority":"urgente","items":[{"product_id":7,"qty":1}]}`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if got := decode(t, w)["field"]; got != "priority" {
+			t.Errorf("field = %v", got)
+		}
+	})
+}
+
+func TestDecimalJSON(t *testing.T) {
+	cases := map[decimal]string{
+		0:     `"0.00"`,
+		5:     `"0.05"`,
+		950:   `"9.50"`,
+		-1205: `"-12.05"`,
+	}
+	for d, want := range cases {
+		if got, _ := json.Marshal(d); string(got) != want {
+			t.Errorf("decimal(%d) = %s, quiero %s", d, got, want)
+		}
+	}
+}
+
+func TestListOrdersMoneyString(t *testing.T) {
+	mock := newMock(t)
+	o := sampleOrder(10)
+	o.Subtotal, o.DeliveryFee, o.Total = 0.1+0.2, 4.5, 4.8
+	mock.ExpectQuery(sqlText(`FROM orders o WHERE o.branch_id=?`)).WithArgs(defaultBranchID).WillReturnRows(orderListRows(o))
+
+	w := serve(http.MethodGet, "/api/v1/orders?money=string", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if body := w.Body.String(); !strings.Contains(body, `"subtotal_decimal":"0.30"`) || !strings.Contains(body, `"total_decimal":"4.80"`) {
+		t.Errorf("cuerpo = %s", body)
+	}
+}
+
+func TestParseCustomerTime(t *testing.T) {
+	if _, err := time.LoadLocation("America/Lima"); err != nil {
+		t.Skip("sin base de zonas horarias:", err)
+	}
+	setVar(t, &appLocation, time.UTC)
+	lima := "America/Lima"
+	str := func(s string) *string { return &s }
+	cases := []struct {
+		name string
+		raw  *string
+		tz   *string
+		want time.Time
+	}{
+		{"RFC3339 respeta el offset", str("2026-10-15T10:00:00-03:00"), &lima, time.Date(2026, 10, 15, 13, 0, 0, 0, time.UTC)},
+		{"sin offset en la zona del cliente", str("2026-10-15T10:00"), &lima, time.Date(2026, 10, 15, 15, 0, 0, 0, time.UTC)},
+		{"sin zona del cliente usa appLocation", str("2026-10-15 10:00:00"), nil, time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCustomerTime(tc.raw, tc.tz, "scheduled_at")
+			if err != nil || !got.Valid || !got.Time.Equal(tc.want) {
+				t.Errorf("parseCustomerTime = %v, %v; quiero %v", got, err, tc.want)
+			}
+		})
+	}
+	if got, err := parseCustomerTime(str("  "), &lima, "scheduled_at"); err != nil || got.Valid {
+		t.Errorf("vacío = %v, %v", got, err)
+	}
+	if _, err := parseCustomerTime(str("15/10/2026"), &lima, "scheduled_at"); err == nil {
+		t.Error("formato inválido debe fallar")
+	}
+}
+
+func TestParseDeliveryWindow(t *testing.T) {
+	setVar(t, &appLocation, time.UTC)
+	str := func(s string) *string { return &s }
+	cases := []struct {
+		name       string
+		start, end *string
+		scheduled  *string
+		wantErr    bool
+	}{
+		{"sin franja", nil, nil, nil, false},
+		{"franja válida", str("2026-10-16T09:00"), str("2026-10-16T12:00"), nil, false},
+		{"solo inicio", str("2026-10-16T09:00"), nil, nil, true},
+		{"con scheduled_at", str("2026-10-16T09:00"), str("2026-10-16T12:00"), str("2026-10-16T10:00"), true},
+		{"invertida", str("2026-10-16T12:00"), str("2026-10-16T09:00"), nil, true},
+		{"en el pasado", str("2026-10-15T09:00"), str("2026-10-15T12:00"), nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &CreateOrderReq{DeliveryWindowStart: tc.start, DeliveryWindowEnd: tc.end, ScheduledAt: tc.scheduled}
+			start, end, err := parseDeliveryWindow(req, nil, testNow)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, quiero error: %v", err, tc.wantErr)
+			}
+			if err == nil && start.Valid != (tc.start != nil) {
+				t.Errorf("start = %v, end = %v", start, end)
+			}
+		})
+	}
+}
+
+func TestCreateOrderReportsEveryInvalidItem(t *testing.T) {
+	inactive := baseProduct(10)
+	inactive.active = false
+	otherBranch := baseProduct(10)
+	otherBranch.branchID = 2
+	noStock := baseProduct(10)
+	noStock.stock = intPtr(1)
+
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectBranchActive(mock, defaultBranchID)
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlText(`SELECT role_id, is_active, timezone FROM users WHERE id=?`)).WithArgs(testCustomer.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "is_active", "timezone"}).AddRow(roleCustomer, true, nil))
+	mock.ExpectQuery(sqlText(`FROM products p`)).WithArgs(testCustomer.ID, int64(1)).WillReturnError(sql.ErrNoRows)
+	expectPricing(mock, testCustomer.ID, 2, inactive)
+	expectPricing(mock, testCustomer.ID, 3, otherBranch)
+	expectPricing(mock, testCustomer.ID, 4, noStock)
+	expectPricing(mock, testCustomer.ID, 5, baseProduct(10))
+	mock.ExpectRollback()
+
+	w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[
+		{"product_id":1,"qty":1},{"product_id":2,"qty":1},{"product_id":3,"qty":1},{"product_id":4,"qty":2},{"product_id":5,"qty":1}]}`, h)
+	expectStatus(t, w, http.StatusUnprocessableEntity)
+	var body struct {
+		Field        string        `json:"field"`
+		InvalidItems []invalidItem `json:"invalid_items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := []invalidItem{{1, itemNotFound}, {2, itemInactive}, {3, itemWrongBranch}, {4, itemOutOfStock}}
+	if body.Field != "items" || !slices.Equal(body.InvalidItems, want) {
+		t.Errorf("cuerpo = %s", w.Body.String())
+	}
+}
+
+func TestUpdateOrderAddress(t *testing.T) {
+	expectLocked := func(mock sqlmock.Sqlmock, status string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT status, customer_id, address_id, subtotal FROM orders WHERE id=? FOR UPDATE`)).WithArgs(int64(10)).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "customer_id", "address_id", "subtotal"}).AddRow(status, testCustomer.ID, 20, 20.0))
+	}
+
+	t.Run("el cliente dueño cambia la dirección", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectLocked(mock, statusAsignado)
+		mock.ExpectQuery(sqlText(`SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`)).WithArgs(int64(21), testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"lat", "lng"}).AddRow(nil, nil))
+		mock.ExpectExec(sqlText(`UPDATE orders SET address_id=?, delivery_fee=? WHERE id=?`)).WithArgs(int64(21), 0.0, int64(10)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectSyncTotal(mock, int64(10), 0, 20)
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).
+			WithArgs(int64(10), statusAsignado, statusAsignado, testCustomer.ID, "Dirección cambiada de 20 a 21").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/address", `{"address_id":21}`, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("dirección de otro cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectLocked(mock, statusPorAtender)
+		mock.ExpectQuery(sqlText(`SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`)).WithArgs(int64(99), testCustomer.ID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/address", `{"address_id":99}`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+	t.Run("otro cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, otherUser)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectLocked(mock, statusPorAtender)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/address", `{"address_id":21}`, h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("pedido en camino", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectLocked(mock, statusEnCamino)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/address", `{"address_id":21}`, h)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("id no numérico", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPatch, "/api/v1/orders/diez/address", `{"address_id":21}`, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestValidProofURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://cdn.example.com/pod/10.jpg": true,
+		"http://example.com/a":               true,
+		"ftp://example.com/a":                false,
+		"/uploads/10.jpg":                    false,
+		"https://":                           false,
+		"":                                   false,
+	}
+	for raw, want := range cases {
+		if got := validProofURL(raw); got != want {
+			t.Errorf("validProofURL(%q) = %v, quiero %v", raw, got, want)
+		}
+	}
+}
+
+func TestOrderProof(t *testing.T) {
+	expectOrder := func(mock sqlmock.Sqlmock, status string) {
+		mock.ExpectQuery(sqlText(`SELECT status, assigned_driver_id FROM orders WHERE id=?`)).WithArgs("10").
+			WillReturnRows(sqlmock.NewRows([]string{"status", "assigned_driver_id"}).AddRow(status, testDriver.ID))
+	}
+	const body = `{"proof_url":"https://cdn.example.com/pod/10.jpg","signature_name":" Ana "}`
+
+	t.Run("el repartidor asignado", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testDriver)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectOrder(mock, statusEnCamino)
+		mock.ExpectExec(sqlText(`UPDATE orders SET proof_url=?, signature_name=?, proof_at=NOW() WHERE id=?`)).
+			WithArgs("https://cdn.example.com/pod/10.jpg", "Ana", "10").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := serve(http.MethodPost, "/api/v1/orders/10/proof", body, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("otro repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		other := testDriver
+		other.ID = 9
+		h := authAs(t, mock, other)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		expectOrder(mock, statusEnCamino)
+
+		w := serve(http.MethodPost, "/api/v1/orders/10/proof", body, h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("pedido aún no sale", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectOrder(mock, statusAsignado)
+
+		w := serve(http.MethodPost, "/api/v1/orders/10/proof", body, h)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("URL inválida", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPost, "/api/v1/orders/10/proof", `{"proof_url":"foto.jpg"}`, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestCreateOrderDefaultAddress(t *testing.T) {
+	expectCustomer := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(sqlText(`SELECT role_id, is_active, timezone FROM users WHERE id=?`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"role_id", "is_active", "timezone"}).AddRow(roleCustomer, true, nil))
+	}
+	const body = `{"customer_id":3,"items":[{"product_id":7,"qty":2}]}`
+
+	t.Run("sin dirección por defecto", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectCustomer(mock)
+		mock.ExpectQuery(sqlText(`SELECT id FROM addresses WHERE user_id=? AND is_default=1`)).WithArgs(testCustomer.ID).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPost, "/api/v1/orders", body, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if got := decode(t, w)["field"]; got != "address_id" {
+			t.Errorf("field = %v", got)
+		}
+	})
+	t.Run("usa la dirección por defecto", func(t *testing.T) {
+		line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectCustomer(mock)
+		mock.ExpectQuery(sqlText(`SELECT id FROM addresses WHERE user_id=? AND is_default=1`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+		expectPricing(mock, testCustomer.ID, 7, line.product)
+		mock.ExpectQuery(sqlText(`SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`)).WithArgs(int64(20), testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"lat", "lng"}).AddRow(nil, nil))
+		mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM addresses WHERE user_id=? AND is_default=1`)).WithArgs(testCustomer.ID).WillReturnRows(countRows(1))
+		expectInsertOrder(mock, 50, testAdmin.ID, line)
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/orders", body, h)
+		expectStatus(t, w, http.StatusCreated)
+		if got := decode(t, w)["address_id"]; got != float64(20) {
+			t.Errorf("address_id = %v", got)
+		}
+	})
+}
+
+func TestQuoteOrder(t *testing.T) {
+	lines := []orderLine{
+		{productID: 7, qty: 2, product: baseProduct(10)},
+		{productID: 8, qty: 1, product: baseProduct(4.5)},
+	}
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectBranchActive(mock, defaultBranchID)
+	// Sin transacción ni escrituras: cualquier INSERT haría fallar el mock
+	expectPrepareOrder(mock, lines...)
+
+	w := serve(http.MethodPost, "/api/v1/orders/quote",
+		`{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2},{"product_id":8,"qty":1}]}`, h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["subtotal"] != 24.5 || body["total"] != 24.5 {
+		t.Errorf("subtotal = %v, total = %v", body["subtotal"], body["total"])
+	}
+	items, _ := body["items"].([]any)
+	if len(items) != 2 {
+		t.Fatalf("items = %v", body["items"])
+	}
+	if first := items[0].(map[string]any); first["line_total"] != float64(20) || first["price_source"] != priceSourceBase {
+		t.Errorf("primera línea = %v", first)
+	}
+	if s, _ := body["price_snapshot"].(string); s == "" {
+		t.Error("falta price_snapshot")
+	}
+}
+
+func TestRecomputeTotals(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectExec(sqlText(`UPDATE orders SET total = subtotal + delivery_fee + tax WHERE total <> subtotal + delivery_fee + tax`)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	w := serve(http.MethodPost, "/api/v1/admin/orders/recompute-totals", "", h)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w)["corrected"]; got != float64(3) {
+		t.Errorf("corrected = %v", got)
+	}
+
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		w := serve(http.MethodPost, "/api/v1/admin/orders/recompute-totals", "", h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+func TestCreateOrderMaxTotal(t *testing.T) {
+	setVar(t, &maxOrderTotal, floatPtr(15))
+	line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+	const body = `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`
+	override := func(h http.Header) http.Header {
+		h.Set("X-Allow-Large-Order", "true")
+		return h
+	}
+
+	t.Run("excede el máximo", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectPrepareOrder(mock, line)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPost, "/api/v1/orders", body, h)
+		expectStatus(t, w, http.StatusConflict)
+		if got := decode(t, w)["max_order_total"]; got != float64(15) {
+			t.Errorf("max_order_total = %v", got)
+		}
+	})
+	t.Run("el header solo vale para admins", func(t *testing.T) {
+		mock := newMock(t)
+		freshRateLimiter(t)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectPrepareOrder(mock, line)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPost, "/api/v1/orders", body, override(http.Header{}))
+		expectStatus(t, w, http.StatusConflict)
+	})
+	t.Run("admin lo fuerza", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectPrepareOrder(mock, line)
+		expectInsertOrder(mock, 50, testAdmin.ID, line)
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/orders", body, override(h))
+		expectStatus(t, w, http.StatusCreated)
+	})
+}
+
+func TestStartTransit(t *testing.T) {
+	expectOrder := func(mock sqlmock.Sqlmock, status string, driverID any) {
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		mock.ExpectQuery(sqlText(`SELECT status, assigned_driver_id FROM orders WHERE id=?`)).WithArgs("10").
+			WillReturnRows(sqlmock.NewRows([]string{"status", "assigned_driver_id"}).AddRow(status, driverID))
+	}
+
+	t.Run("el repartidor asignado sale en camino", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testDriver)
+		expectOrder(mock, statusAsignado, testDriver.ID)
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusAsignado, testDriver.ID)
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=?, transit_started_at=NOW() WHERE id=? AND status=?`)).
+			WithArgs(statusEnCamino, "10", statusAsignado).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs("10", statusAsignado, statusEnCamino, testDriver.ID, "En camino").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/orders/10/start-transit", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("otro repartidor", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testDriver)
+		expectOrder(mock, statusAsignado, int64(99))
+		w := serve(http.MethodPost, "/api/v1/orders/10/start-transit", "", h)
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("pedido sin asignar", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testDriver)
+		expectOrder(mock, statusPorAtender, testDriver.ID)
+		w := serve(http.MethodPost, "/api/v1/orders/10/start-transit", "", h)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestEnPreparacionStatus(t *testing.T) {
+	t.Run("asignado pasa a en_preparacion", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusAsignado, testDriver.ID)
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=? WHERE id=? AND status=?`)).
+			WithArgs(statusEnPreparacion, "10", statusAsignado).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs("10", statusAsignado, statusEnPreparacion, testAdmin.ID, nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"en_preparacion","changed_by":1}`, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("no salta a entregado", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusEnPreparacion, testDriver.ID)
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPatch, "/api/v1/orders/10/status", `{"new_status":"entregado","changed_by":1}`, h)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("sale en camino desde en_preparacion", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testDriver)
+		expectBranchOf(mock, "orders", 10, defaultBranchID)
+		mock.ExpectQuery(sqlText(`SELECT status, assigned_driver_id FROM orders WHERE id=?`)).WithArgs("10").
+			WillReturnRows(sqlmock.NewRows([]string{"status", "assigned_driver_id"}).AddRow(statusEnPreparacion, testDriver.ID))
+		mock.ExpectBegin()
+		expectOrderForUpdate(mock, 10, statusEnPreparacion, testDriver.ID)
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=?, transit_started_at=NOW() WHERE id=? AND status=?`)).
+			WithArgs(statusEnCamino, "10", statusEnPreparacion).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs("10", statusEnPreparacion, statusEnCamino, testDriver.ID, "En camino").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/orders/10/start-transit", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	// Es opcional: asignado → en_camino sigue valiendo y nunca se vuelve atrás
+	if !isForwardTransition(statusAsignado, statusEnCamino) || isForwardTransition(statusEnCamino, statusEnPreparacion) {
+		t.Error("rango de en_preparacion fuera de lugar")
+	}
+}
+
+func TestRecomputeSubtotalsInBatches(t *testing.T) {
+	batchEnd := func(mock sqlmock.Sqlmock, after int64, end any) {
+		mock.ExpectQuery(sqlText(`SELECT MAX(id) FROM (`)).WithArgs(after, recomputeBatchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(end))
+	}
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	batchEnd(mock, 0, int64(12))
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlText(`SET o.subtotal = calc.subtotal`)).WithArgs(int64(0), int64(12)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlText(`UPDATE orders SET tax = `)).WithArgs(int64(0), int64(12)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// Lote sin diferencias: no toca tax ni total
+	batchEnd(mock, 12, int64(15))
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlText(`SET o.subtotal = calc.subtotal`)).WithArgs(int64(12), int64(15)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	batchEnd(mock, 15, nil)
+
+	w := serve(http.MethodPost, "/api/v1/admin/recompute-subtotals", "", h)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w)["corrected"]; got != float64(1) {
+		t.Errorf("corrected = %v", got)
+	}
+}
+
+func TestExpireStaleOrders(t *testing.T) {
+	setVar(t, &staleOrderGrace, time.Hour)
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlText(`WHERE status='por_atender' AND COALESCE(scheduled_at, delivery_window_end) < ?`)).
+		WithArgs(sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10).AddRow(11))
+	// El pedido 11 ya había devuelto su stock: no se devuelve dos veces
+	for _, o := range []struct {
+		id       int64
+		restored bool
+	}{{10, false}, {11, true}} {
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=? WHERE id=? AND status=?`)).WithArgs(statusCancelado, o.id, statusPorAtender).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if o.restored {
+			mock.ExpectExec(sqlText(`UPDATE orders SET stock_restored=TRUE`)).WithArgs(o.id).WillReturnResult(sqlmock.NewResult(0, 0))
+		} else {
+			mock.ExpectExec(sqlText(`UPDATE orders SET stock_restored=TRUE`)).WithArgs(o.id).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(sqlText(`SET p.stock = p.stock + oi.qty`)).WithArgs(o.id).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WithArgs(o.id, statusPorAtender, statusCancelado, testAdmin.ID, staleOrderNote).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	before := time.Now()
+	w := serve(http.MethodPost, "/api/v1/admin/orders/expire-stale", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["expired"] != float64(2) {
+		t.Errorf("expired = %v", body["expired"])
+	}
+	cutoff, err := time.Parse(time.RFC3339Nano, body["cutoff"].(string))
+	if err != nil || cutoff.After(before.Add(-time.Hour+time.Second)) {
+		t.Errorf("cutoff = %v con una hora de gracia", body["cutoff"])
+	}
+}
+
+func TestCreateOrderPaymentMethod(t *testing.T) {
+	line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+
+	t.Run("se guarda el medio de pago", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		expectPrepareOrder(mock, line)
+		args := insertOrderArgs(testAdmin.ID)
+		args[7] = "card"
+		mock.ExpectExec(sqlText(`INSERT INTO orders(`)).WithArgs(args...).WillReturnResult(sqlmock.NewResult(50, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_items`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(sqlText(`UPDATE products SET stock = stock - ?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"payment_method":" card ","items":[{"product_id":7,"qty":2}]}`, h)
+		expectStatus(t, w, http.StatusCreated)
+	})
+	t.Run("medio de pago desconocido", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"payment_method":"crypto","items":[{"product_id":7,"qty":2}]}`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if got := decode(t, w)["field"]; got != "payment_method" {
+			t.Errorf("field = %v", got)
+		}
+	})
+}
+
+func TestCreateOrderSource(t *testing.T) {
+	line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+	cases := []struct {
+		name, source, want string
+	}{
+		{"por defecto web", "", defaultOrderSource},
+		{"canal indicado", `,"source":"phone"`, "phone"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			h := authAs(t, mock, testAdmin)
+			expectBranchActive(mock, defaultBranchID)
+			mock.ExpectBegin()
+			expectPrepareOrder(mock, line)
+			args := insertOrderArgs(testAdmin.ID)
+			args[8] = tc.want
+			mock.ExpectExec(sqlText(`INSERT INTO orders(`)).WithArgs(args...).WillReturnResult(sqlmock.NewResult(50, 1))
+			mock.ExpectExec(sqlText(`INSERT INTO order_items`)).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(sqlText(`UPDATE products SET stock = stock - ?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]`+tc.source+`}`, h)
+			expectStatus(t, w, http.StatusCreated)
+		})
+	}
+	t.Run("canal desconocido", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectBranchActive(mock, defaultBranchID)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"source":"fax","items":[{"product_id":7,"qty":2}]}`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if got := decode(t, w)["field"]; got != "source" {
+			t.Errorf("field = %v", got)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+// Paginación estándar para listados: ?page= (desde 1) y ?page_size= (máx. maxPageSize).
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Paginated es el envelope estándar de los listados paginados.
+type Paginated struct {
+	Data       any `json:"data"`
+	Page       int `json:"page"`
+	PageSize   int `json:"page_size"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// parsePagination lee page/page_size; responde 400 y devuelve ok=false si no son válidos.
+func parsePagination(c *gin.Context) (page, pageSize int, ok bool) {
+	page, pageSize = 1, defaultPageSize
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page debe ser un entero >= 1"})
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := c.Query("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page_size debe estar entre 1 y %d", maxPageSize)})
+			return 0, 0, false
+		}
+		pageSize = n
+	}
+	return page, pageSize, true
+}
+
+// newPaginated arma el envelope; data nil se serializa como [] para no romper clientes.
+func newPaginated[T any](data []T, page, pageSize, total int) Paginated {
+	if data == nil {
+		data = []T{}
+	}
+	return Paginated{
+		Data:       data,
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      total,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	}
+}
+
+// setLinkHeader agrega el header Link (RFC 5988) con first/prev/next/last para el
+// mismo request cambiando solo ?page=. prev y next se omiten en los extremos.
+func setLinkHeader(c *gin.Context, page, pageSize, total int) {
+	last := (total + pageSize - 1) / pageSize
+	if last < 1 {
+		last = 1
+	}
+	link := func(p int, rel string) string {
+		u := *c.Request.URL
+		q := u.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("page_size", strconv.Itoa(pageSize))
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(min(page-1, last), "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+// orderByClause arma "ORDER BY <campo> <dir>, <id> <dir>" a partir de ?sort= y
+// ?order=asc|desc. Solo se aceptan los campos de allowed (nombre público → columna SQL);
+// el id siempre es el desempate para que la paginación sea estable.
+// Responde 400 y devuelve ok=false si sort u order no son válidos.
+func orderByClause(c *gin.Context, allowed map[string]string, idCol, defaultDir string) (string, bool) {
+	col := idCol
+	if sort := c.Query("sort"); sort != "" && sort != "id" {
+		var found bool
+		if col, found = allowed[sort]; !found {
+			names := make([]string, 0, len(allowed)+1)
+			names = append(names, "id")
+			for name := range allowed {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort inválido, use: " + strings.Join(names, ", ")})
+			return "", false
+		}
+	}
+	dir := defaultDir
+	switch strings.ToLower(c.Query("order")) {
+	case "":
+	case "asc":
+		dir = "ASC"
+	case "desc":
+		dir = "DESC"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order debe ser asc o desc"})
+		return "", false
+	}
+	if col == idCol {
+		return " ORDER BY " + idCol + " " + dir, true
+	}
+	return " ORDER BY " + col + " " + dir + ", " + idCol + " " + dir, true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testContext arma un *gin.Context para probar helpers de query params.
+func testContext(target string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return c, w
+}
+
+func TestOrderByClause(t *testing.T) {
+	cases := []struct {
+		query, want string
+	}{
+		{"", " ORDER BY id ASC"},
+		{"?sort=id&order=desc", " ORDER BY id DESC"},
+		{"?sort=full_name", " ORDER BY full_name ASC, id ASC"},
+		{"?sort=created_at&order=DESC", " ORDER BY created_at DESC, id DESC"},
+	}
+	for _, tc := range cases {
+		c, _ := testContext("/api/v1/users" + tc.query)
+		got, ok := orderByClause(c, userSortFields, "id", "ASC")
+		if !ok || got != tc.want {
+			t.Errorf("orderByClause(%q) = %q, %v; quiero %q", tc.query, got, ok, tc.want)
+		}
+	}
+}
+
+func TestOrderByClauseRejectsUnknownFields(t *testing.T) {
+	for _, query := range []string{"?sort=password_hash", "?order=sideways"} {
+		c, w := testContext("/api/v1/users" + query)
+		if _, ok := orderByClause(c, userSortFields, "id", "ASC"); ok {
+			t.Errorf("%s debe ser rechazado", query)
+		}
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+}
+
+func TestSetLinkHeader(t *testing.T) {
+	c, w := testContext("/api/v1/users?q=ana&page=2&page_size=10")
+	setLinkHeader(c, 2, 10, 35)
+	want := `</api/v1/users?page=1&page_size=10&q=ana>; rel="first", </api/v1/users?page=1&page_size=10&q=ana>; rel="prev", </api/v1/users?page=3&page_size=10&q=ana>; rel="next", </api/v1/users?page=4&page_size=10&q=ana>; rel="last"`
+	if got := w.Header().Get("Link"); got != want {
+		t.Errorf("Link =\n%s\nquiero\n%s", got, want)
+	}
+
+	c, w = testContext("/api/v1/users")
+	setLinkHeader(c, 1, 20, 0)
+	if got := w.Header().Get("Link"); strings.Contains(got, `rel="prev"`) || strings.Contains(got, `rel="next"`) {
+		t.Errorf("una sola página no lleva prev ni next: %s", got)
+	}
+}
+
+func TestNewPaginated(t *testing.T) {
+	p := newPaginated[int](nil, 1, 20, 41)
+	if p.TotalPages != 3 {
+		t.Errorf("total_pages = %d", p.TotalPages)
+	}
+	if data, _ := json.Marshal(p.Data); string(data) != "[]" {
+		t.Errorf("data = %s, quiero []", data)
+	}
+}
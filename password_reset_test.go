@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPasswordResetRequest(t *testing.T) {
+	type sent struct{ channel, to, token string }
+	capture := func(t *testing.T) *[]sent {
+		var out []sent
+		setVar(t, &resetSender, func(channel, to, token string) error {
+			out = append(out, sent{channel, to, token})
+			return nil
+		})
+		return &out
+	}
+	lookup := sqlText(`SELECT id, email, phone, is_active FROM users WHERE (email=? OR phone IN (?,?)) LIMIT 2`)
+	lookupColumns := []string{"id", "email", "phone", "is_active"}
+
+	t.Run("por email", func(t *testing.T) {
+		out := capture(t)
+		mock := newMock(t)
+		mock.ExpectQuery(lookup).WithArgs("ana@mail.com", "ana@mail.com", "ana@mail.com").
+			WillReturnRows(sqlmock.NewRows(lookupColumns).AddRow(3, "ana@mail.com", "999888777", true))
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlText(`UPDATE password_reset_tokens SET used_at=NOW() WHERE user_id=? AND used_at IS NULL`)).WithArgs(int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO password_reset_tokens`)).WithArgs(int64(3), sqlmock.AnyArg(), resetChannelEmail, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/password-reset/request", `{"username":"ana@mail.com"}`, nil)
+		expectStatus(t, w, http.StatusOK)
+		if len(*out) != 1 || (*out)[0].channel != resetChannelEmail || len((*out)[0].token) != 64 {
+			t.Errorf("enviados = %+v", *out)
+		}
+	})
+	t.Run("por teléfono va por SMS al número canónico", func(t *testing.T) {
+		out := capture(t)
+		mock := newMock(t)
+		mock.ExpectQuery(lookup).WithArgs("999888777", "999888777", "+51999888777").
+			WillReturnRows(sqlmock.NewRows(lookupColumns).AddRow(3, "ana@mail.com", "999 888 777", true))
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlText(`UPDATE password_reset_tokens`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlText(`INSERT INTO password_reset_tokens`)).WithArgs(int64(3), sqlmock.AnyArg(), resetChannelSMS, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/password-reset/request", `{"username":"999888777"}`, nil)
+		expectStatus(t, w, http.StatusOK)
+		if len(*out) != 1 || (*out)[0].to != "+51999888777" {
+			t.Errorf("enviados = %+v", *out)
+		}
+	})
+	t.Run("cuenta inexistente o inactiva responde igual", func(t *testing.T) {
+		out := capture(t)
+		mock := newMock(t)
+		mock.ExpectQuery(lookup).WillReturnRows(sqlmock.NewRows(lookupColumns))
+		w := serve(http.MethodPost, "/api/v1/password-reset/request", `{"username":"nadie@mail.com"}`, nil)
+		expectStatus(t, w, http.StatusOK)
+
+		mock = newMock(t)
+		mock.ExpectQuery(lookup).WillReturnRows(sqlmock.NewRows(lookupColumns).AddRow(3, "ana@mail.com", nil, false))
+		w = serve(http.MethodPost, "/api/v1/password-reset/request", `{"username":"ana@mail.com"}`, nil)
+		expectStatus(t, w, http.StatusOK)
+		if len(*out) != 0 {
+			t.Errorf("enviados = %+v", *out)
+		}
+	})
+}
+
+func TestResetSenderRequiresProvider(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	if err := resetSender(resetChannelEmail, "ana@mail.com", "token"); !errors.Is(err, errResetSenderMissing) {
+		t.Errorf("fuera de desarrollo el envío por defecto debe fallar, err = %v", err)
+	}
+}
+
+func TestPasswordResetConfirm(t *testing.T) {
+	const token = "token-de-prueba"
+	expectToken := func(mock sqlmock.Sqlmock, expiresAt time.Time, usedAt any) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`FROM password_reset_tokens WHERE token_hash=? FOR UPDATE`)).WithArgs(hashRefreshToken(token)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "expires_at", "used_at"}).AddRow(9, testCustomer.ID, expiresAt, usedAt))
+	}
+	const body = `{"token":"` + token + `","password":"nueva-clave-1"}`
+
+	t.Run("cambia la contraseña y cierra las sesiones", func(t *testing.T) {
+		mock := newMock(t)
+		expectToken(mock, time.Now().Add(time.Minute), nil)
+		mock.ExpectExec(sqlText(`UPDATE users SET password_hash=? WHERE id=? AND is_active=TRUE`)).WithArgs(sqlmock.AnyArg(), testCustomer.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`UPDATE password_reset_tokens SET used_at=NOW() WHERE id=?`)).WithArgs(int64(9)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=?`)).WithArgs(testCustomer.ID).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/password-reset/confirm", body, nil)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("token ya usado", func(t *testing.T) {
+		mock := newMock(t)
+		expectToken(mock, time.Now().Add(time.Minute), testNow)
+		mock.ExpectRollback()
+		w := serve(http.MethodPost, "/api/v1/password-reset/confirm", body, nil)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+	t.Run("token vencido", func(t *testing.T) {
+		mock := newMock(t)
+		expectToken(mock, time.Now().Add(-time.Minute), nil)
+		mock.ExpectRollback()
+		w := serve(http.MethodPost, "/api/v1/password-reset/confirm", body, nil)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+// Teléfonos en formato canónico E.164 (+51999888777) para las notificaciones SMS.
+// Las filas antiguas tienen formatos mezclados ("999 888 777", "+51 999-888-777",
+// "0051999888777"); las respuestas de usuarios los devuelven normalizados sin tocar la BD.
+// POST /api/v1/admin/normalize-phones reescribe los valores guardados. Las altas y
+// ediciones de usuarios guardan el formato canónico, y el login y el
+// restablecimiento de contraseña buscan por el teléfono tal como se escribe y por
+// su forma canónica.
+// PHONE_COUNTRY_CODE (por defecto 51) y PHONE_LOCAL_LEN (9) definen los números
+// locales sin código de país.
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	phoneCountryCode = "51"
+	phoneLocalLen    = 9
+)
+
+func loadPhoneConfig() {
+	if v := strings.TrimPrefix(strings.TrimSpace(os.Getenv("PHONE_COUNTRY_CODE")), "+"); v != "" {
+		phoneCountryCode = v
+	}
+	phoneLocalLen = envInt("PHONE_LOCAL_LEN", phoneLocalLen)
+}
+
+// canonicalPhone devuelve el teléfono en E.164 u ok=false si no se puede
+// interpretar con seguridad (se deja como está).
+func canonicalPhone(raw string) (string, bool) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return "", false
+	}
+	international := strings.HasPrefix(s, "+") || strings.HasPrefix(s, "00")
+	digits := phoneDigits(s)
+	if strings.HasPrefix(s, "00") {
+		digits = strings.TrimPrefix(digits, "00")
+	}
+	switch {
+	case international:
+		// E.164: como mucho 15 dígitos incluido el código de país
+		if len(digits) < 8 || len(digits) > 15 {
+			return "", false
+		}
+	case len(digits) == phoneLocalLen && digits[0] != '0':
+		digits = phoneCountryCode + digits
+	case len(digits) == phoneLocalLen+1 && digits[0] == '0':
+		// Prefijo troncal nacional ("0999888777")
+		digits = phoneCountryCode + digits[1:]
+	case len(digits) == len(phoneCountryCode)+phoneLocalLen && strings.HasPrefix(digits, phoneCountryCode):
+	default:
+		return "", false
+	}
+	return "+" + digits, true
+}
+
+// displayPhone normaliza un teléfono para la respuesta; si no se puede, lo deja igual.
+func displayPhone(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	if v, ok := canonicalPhone(*p); ok {
+		return &v
+	}
+	return p
+}
+
+// normalizePhoneInput canoniza un teléfono antes de guardarlo. Si no se puede
+// interpretar se guarda como vino (normalize-phones lo reporta como inválido).
+func normalizePhoneInput(p *string) *string {
+	p = trimOptional(p)
+	if p == nil {
+		return nil
+	}
+	return displayPhone(p)
+}
+
+// phoneLookupKey es la forma canónica de un identificador de login que parece un
+// teléfono; si no lo parece, el mismo valor.
+func phoneLookupKey(username string) string {
+	if v, ok := canonicalPhone(username); ok {
+		return v
+	}
+	return username
+}
+
+// POST /api/v1/admin/normalize-phones (admin)
+// Reescribe users.phone en formato canónico. Los valores que no se pueden
+// normalizar se dejan como están y se cuentan en invalid (con sus ids).
+func normalizePhonesHandler(c *gin.Context) {
+	type change struct {
+		id    int64
+		phone string
+	}
+	var changes []change
+	var invalidIDs []int64
+	var unchanged int
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		changes, invalidIDs, unchanged = nil, []int64{}, 0
+		rows, err := tx.Query(`SELECT id, phone FROM users WHERE phone IS NOT NULL AND phone<>'' ORDER BY id FOR UPDATE`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			var phone string
+			if err := rows.Scan(&id, &phone); err != nil {
+				rows.Close()
+				return err
+			}
+			canon, ok := canonicalPhone(phone)
+			switch {
+			case !ok:
+				invalidIDs = append(invalidIDs, id)
+			case canon == phone:
+				unchanged++
+			default:
+				changes = append(changes, change{id, canon})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, ch := range changes {
+			_, err := tx.Exec(`UPDATE users SET phone=? WHERE id=?`, ch.phone, ch.id)
+			if isRetryableTxError(err) {
+				return err
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "user_id": ch.id})
+				return errResponded
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ok":               true,
+		"changed":          len(changes),
+		"unchanged":        unchanged,
+		"invalid":          len(invalidIDs),
+		"invalid_user_ids": invalidIDs,
+		"country_code":     "+" + phoneCountryCode,
+		"local_len":        phoneLocalLen,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCanonicalPhone(t *testing.T) {
+	cases := []struct {
+		raw, want string
+		ok        bool
+	}{
+		{"999 888 777", "+51999888777", true},
+		{"+51 999-888-777", "+51999888777", true},
+		{"0051999888777", "+51999888777", true},
+		{"0999888777", "+51999888777", true},
+		{"51999888777", "+51999888777", true},
+		{"+1 (415) 555-0100", "+14155550100", true},
+		{"12345", "", false},
+		{"+123", "", false},
+		{"", "", false},
+	}
+	for _, tc := range cases {
+		got, ok := canonicalPhone(tc.raw)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("canonicalPhone(%q) = %q, %v; quiero %q, %v", tc.raw, got, ok, tc.want, tc.ok)
+		}
+	}
+	raw := "12-34"
+	if got := displayPhone(&raw); got != &raw {
+		t.Errorf("displayPhone debe dejar igual lo que no puede normalizar, dio %q", *got)
+	}
+}
+
+func TestNormalizePhones(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlText(`SELECT id, phone FROM users WHERE phone IS NOT NULL AND phone<>'' ORDER BY id FOR UPDATE`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "phone"}).
+			AddRow(3, "999 888 777").AddRow(4, "+51999888776").AddRow(5, "123"))
+	mock.ExpectExec(sqlText(`UPDATE users SET phone=? WHERE id=?`)).WithArgs("+51999888777", int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/api/v1/admin/normalize-phones", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["changed"] != float64(1) || body["unchanged"] != float64(1) || body["invalid"] != float64(1) {
+		t.Errorf("resultado = %v", body)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+// Snapshot de precios de una cotización.
+// POST /api/v1/orders/quote devuelve price_snapshot: un JWT firmado con JWT_SECRET
+// con el precio efectivo de cada producto cotizado, válido PRICE_SNAPSHOT_TTL_MINUTES
+// (por defecto 10). Si POST /api/v1/orders lo envía y sigue vigente se cobran los
+// precios cotizados aunque el precio haya cambiado entre medio; vencido → 409.
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var priceSnapshotTTL = 10 * time.Minute
+
+func loadPriceSnapshotConfig() {
+	priceSnapshotTTL = time.Duration(envInt("PRICE_SNAPSHOT_TTL_MINUTES", int(priceSnapshotTTL/time.Minute))) * time.Minute
+}
+
+// Audiencia del snapshot: impide usar un access token como snapshot y viceversa
+const priceSnapshotAudience = "price_snapshot"
+
+var (
+	errSnapshotExpired = errors.New("price_snapshot vencido, vuelva a cotizar")
+	errSnapshotInvalid = errors.New("price_snapshot inválido")
+)
+
+// snapshotPrice es el precio cotizado de un producto y su origen.
+type snapshotPrice struct {
+	Price       float64 `json:"price"`
+	Source      string  `json:"source"`
+	PromotionID *int64  `json:"promotion_id,omitempty"`
+}
+
+// snapshotClaims: prices va por product_id (las claves JSON son strings).
+type snapshotClaims struct {
+	CustomerID int64                    `json:"customer_id"`
+	BranchID   int64                    `json:"branch_id"`
+	Prices     map[string]snapshotPrice `json:"prices"`
+	jwt.RegisteredClaims
+}
+
+// issuePriceSnapshot firma los precios efectivos de las líneas cotizadas.
+func issuePriceSnapshot(customerID, branchID int64, items []pricedItem) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(priceSnapshotTTL)
+	prices := make(map[string]snapshotPrice, len(items))
+	for _, it := range items {
+		prices[strconv.FormatInt(it.ProductID, 10)] = snapshotPrice{Price: it.UnitPrice, Source: it.PriceSource, PromotionID: it.PromotionID}
+	}
+	claims := snapshotClaims{
+		CustomerID: customerID,
+		BranchID:   branchID,
+		Prices:     prices,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{priceSnapshotAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	return signed, exp, err
+}
+
+// applyPriceSnapshot valida el snapshot (firma, vigencia, cliente y sucursal) y
+// reemplaza el precio de las líneas cotizadas. Los productos que no estaban en la
+// cotización conservan el precio actual. Devuelve cuántas líneas cambiaron de precio.
+func applyPriceSnapshot(raw string, customerID, branchID int64, items []pricedItem) (int, error) {
+	var claims snapshotClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (any, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithAudience(priceSnapshotAudience))
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return 0, errSnapshotExpired
+	}
+	if err != nil || claims.CustomerID != customerID || claims.BranchID != branchID {
+		return 0, errSnapshotInvalid
+	}
+	drifted := 0
+	for i := range items {
+		sp, ok := claims.Prices[strconv.FormatInt(items[i].ProductID, 10)]
+		if !ok {
+			continue
+		}
+		if sp.Price != items[i].UnitPrice {
+			drifted++
+		}
+		items[i].UnitPrice, items[i].PriceSource, items[i].PromotionID = sp.Price, sp.Source, sp.PromotionID
+	}
+	return drifted, nil
+}
+
+// respondSnapshotError: 409 si venció (hay que volver a cotizar), 422 si no es válido.
+func respondSnapshotError(c *gin.Context, err error) {
+	if errors.Is(err, errSnapshotExpired) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "field": "price_snapshot"})
+		return
+	}
+	respondInvalid(c, "price_snapshot", err.Error())
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApplyPriceSnapshot(t *testing.T) {
+	quoted := []pricedItem{{ProductID: 7, Qty: 2, UnitPrice: 10, PriceSource: priceSourceBase}}
+	snapshot, _, err := issuePriceSnapshot(testCustomer.ID, defaultBranchID, quoted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// El precio de 7 subió después de cotizar; 8 no estaba en la cotización
+	items := []pricedItem{
+		{ProductID: 7, Qty: 2, UnitPrice: 12, PriceSource: priceSourceBase},
+		{ProductID: 8, Qty: 1, UnitPrice: 4, PriceSource: priceSourceBase},
+	}
+	drifted, err := applyPriceSnapshot(snapshot, testCustomer.ID, defaultBranchID, items)
+	if err != nil || drifted != 1 {
+		t.Fatalf("drifted = %d, err = %v", drifted, err)
+	}
+	if items[0].UnitPrice != 10 || items[1].UnitPrice != 4 {
+		t.Errorf("precios = %v, %v", items[0].UnitPrice, items[1].UnitPrice)
+	}
+
+	if _, err := applyPriceSnapshot(snapshot, otherUser.ID, defaultBranchID, items); !errors.Is(err, errSnapshotInvalid) {
+		t.Errorf("otro cliente: err = %v", err)
+	}
+	if _, err := applyPriceSnapshot("no-es-un-jwt", testCustomer.ID, defaultBranchID, items); !errors.Is(err, errSnapshotInvalid) {
+		t.Errorf("basura: err = %v", err)
+	}
+	// Un access token no sirve como snapshot (otra audiencia)
+	access, _, _ := issueToken(testCustomer)
+	if _, err := applyPriceSnapshot(access, testCustomer.ID, defaultBranchID, items); !errors.Is(err, errSnapshotInvalid) {
+		t.Errorf("access token: err = %v", err)
+	}
+
+	setVar(t, &priceSnapshotTTL, -time.Minute)
+	expired, _, _ := issuePriceSnapshot(testCustomer.ID, defaultBranchID, quoted)
+	if _, err := applyPriceSnapshot(expired, testCustomer.ID, defaultBranchID, items); !errors.Is(err, errSnapshotExpired) {
+		t.Errorf("vencido: err = %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+// Precio efectivo de las líneas de un pedido.
+// Lo comparten la creación de pedidos y la edición de ítems para que ambos
+// validen y cobren exactamente igual.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// querier lo cumplen *sql.DB y *sql.Tx.
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// customerPriceJoin une a products p (alias cpp) el precio activo del cliente del
+// placeholder. El UNIQUE (customer_id, product_id) deja a lo sumo una fila; si la
+// clave faltara y hubiera duplicados, gana la más reciente (mayor id) para que el
+// JOIN no multiplique filas ni dependa del orden de lectura.
+const customerPriceJoin = `
+            LEFT JOIN customer_product_prices cpp ON cpp.id = (
+                SELECT MAX(cpp2.id) FROM customer_product_prices cpp2
+                WHERE cpp2.product_id = p.id AND cpp2.customer_id = ? AND cpp2.is_active = TRUE)`
+
+// Origen del precio unitario de una línea (order_items.price_source)
+const (
+	priceSourceBase   = "base"   // precio de lista del producto
+	priceSourceCustom = "custom" // precio personalizado del cliente
+	priceSourceTier   = "tier"   // reservado para precios por volumen
+	priceSourcePromo  = "promo"  // promoción vigente del producto (promotions.go)
+)
+
+// pricedItem es una línea validada con su precio unitario efectivo.
+type pricedItem struct {
+	ProductID   int64
+	Qty         int
+	UnitPrice   float64
+	PriceSource string
+	PromotionID *int64 // promoción aplicada (solo con PriceSource promo)
+}
+
+// Motivos de rechazo de una línea (invalid_items[].reason)
+const (
+	itemNotFound    = "not_found"
+	itemInactive    = "inactive"
+	itemWrongBranch = "wrong_branch"
+	itemInvalidQty  = "invalid_qty"
+	itemOutOfStock  = "out_of_stock"
+)
+
+// invalidItem es una línea rechazada y el motivo.
+type invalidItem struct {
+	ProductID int64  `json:"product_id"`
+	Reason    string `json:"reason"`
+}
+
+// pricingError es un rechazo de validación de ítems (se responde 400).
+// items trae el detalle de todas las líneas rechazadas.
+type pricingError struct {
+	msg   string
+	items []invalidItem
+}
+
+func (e *pricingError) Error() string { return e.msg }
+
+// priceOrderItems valida cada línea (producto existente, activo y de la sucursal,
+// restricciones de cantidad, stock) y resuelve el precio efectivo: personalizado del
+// cliente si existe, si no la promoción vigente, si no el base. No se detiene en la primera línea inválida:
+// el pricingError trae todas las rechazadas con su motivo.
+func priceOrderItems(q querier, branchID, customerID int64, items []OrderItemReq) ([]pricedItem, float64, error) {
+	priced := make([]pricedItem, 0, len(items))
+	var invalid []invalidItem
+	subtotal := 0.0
+	for _, it := range items {
+		var effPrice float64
+		var source, currency string
+		var promoID *int64
+		var minQty, qtyMultiple, stock *int
+		var active bool
+		var prodBranch int64
+		err := q.QueryRow(`
+            SELECT `+effectivePriceSQL+` AS price,
+                   `+productCurrencyColumns+`,
+                   CASE WHEN cpp.price IS NULL THEN pr.id END,
+                   p.min_qty, p.qty_multiple, p.stock, p.is_active, p.branch_id
+            FROM products p
+            `+customerPriceJoin+activePromoJoin+`
+            WHERE p.id=?`, customerID, it.ProductID).Scan(&effPrice, &source, &currency, &promoID, &minQty, &qtyMultiple, &stock, &active, &prodBranch)
+		reason := ""
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			reason = itemNotFound
+		case err != nil:
+			return nil, 0, err
+		case !active:
+			reason = itemInactive
+		case prodBranch != branchID:
+			reason = itemWrongBranch
+		case !validOrderQty(it.Qty, minQty, qtyMultiple):
+			reason = itemInvalidQty
+		case stock != nil && *stock < it.Qty:
+			reason = itemOutOfStock
+		}
+		if reason != "" {
+			invalid = append(invalid, invalidItem{ProductID: it.ProductID, Reason: reason})
+			continue
+		}
+		// Los pedidos se cobran en la moneda base
+		if currency != baseCurrency {
+			converted, ok := convertPrice(effPrice, currency, baseCurrency)
+			if !ok {
+				return nil, 0, fmt.Errorf("sin tasa de cambio para %s (producto %d)", currency, it.ProductID)
+			}
+			effPrice = converted
+		}
+		priced = append(priced, pricedItem{ProductID: it.ProductID, Qty: it.Qty, UnitPrice: effPrice, PriceSource: source, PromotionID: promoID})
+		subtotal += effPrice * float64(it.Qty)
+	}
+	if len(invalid) > 0 {
+		return nil, 0, &pricingError{msg: "ítems inválidos", items: invalid}
+	}
+	return priced, subtotal, nil
+}
+
+// insertOrderItems guarda las líneas ya preciadas del pedido.
+func insertOrderItems(tx *sql.Tx, orderID int64, items []pricedItem) error {
+	for _, it := range items {
+		if _, err := tx.Exec(`INSERT INTO order_items(order_id, product_id, qty, unit_price, price_source, promotion_id) VALUES (?,?,?,?,?,?)`, orderID, it.ProductID, it.Qty, it.UnitPrice, it.PriceSource, it.PromotionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserveStock descuenta el stock de cada línea. Los productos con stock NULL son
+// ilimitados. Si no alcanza devuelve un pricingError (el llamador hace rollback).
+func reserveStock(tx *sql.Tx, items []pricedItem) error {
+	for _, it := range items {
+		res, err := tx.Exec(`UPDATE products SET stock = stock - ? WHERE id=? AND stock IS NOT NULL AND stock >= ?`, it.Qty, it.ProductID, it.Qty)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// El driver cuenta filas cambiadas, no encontradas: sin cambios puede ser
+			// un producto ilimitado (stock NULL) y no falta de stock
+			var enough bool
+			if err := tx.QueryRow(`SELECT stock IS NULL OR stock >= ? FROM products WHERE id=?`, it.Qty, it.ProductID).Scan(&enough); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if enough {
+				continue
+			}
+			// Puede pasar con líneas repetidas del mismo producto o un pedido concurrente
+			return &pricingError{
+				msg:   fmt.Sprintf("stock insuficiente para producto %d", it.ProductID),
+				items: []invalidItem{{ProductID: it.ProductID, Reason: itemOutOfStock}},
+			}
+		}
+	}
+	return nil
+}
+
+// releaseItemsStock devuelve al inventario las cantidades de los ítems actuales del pedido.
+func releaseItemsStock(tx *sql.Tx, orderID any) error {
+	_, err := tx.Exec(`
+        UPDATE products p
+        JOIN (SELECT product_id, SUM(qty) AS qty FROM order_items WHERE order_id=? GROUP BY product_id) oi
+          ON oi.product_id = p.id
+        SET p.stock = p.stock + oi.qty
+        WHERE p.stock IS NOT NULL`, orderID)
+	return err
+}
+
+// restoreOrderStock devuelve el stock de un pedido cancelado una sola vez:
+// orders.stock_restored evita una segunda devolución aunque se repita la cancelación.
+func restoreOrderStock(tx *sql.Tx, orderID any) error {
+	res, err := tx.Exec(`UPDATE orders SET stock_restored=TRUE WHERE id=? AND stock_restored=FALSE`, orderID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	return releaseItemsStock(tx, orderID)
+}
+
+// taxSQL recalcula orders.tax con la tasa y el flag guardados en el pedido.
+const taxSQL = `ROUND((subtotal + IF(tax_delivery, delivery_fee, 0)) * tax_rate, 2)`
+
+// syncOrderTotal recalcula orders.tax y orders.total desde subtotal y delivery_fee
+// y devuelve los nuevos valores. Se llama en la misma transacción después de
+// cualquier cambio de montos del pedido.
+func syncOrderTotal(tx *sql.Tx, orderID any) (tax, total float64, err error) {
+	// MySQL asigna de izquierda a derecha: total ya ve el tax nuevo
+	if _, err = tx.Exec(`UPDATE orders SET tax = `+taxSQL+`, total = subtotal + delivery_fee + tax WHERE id=?`, orderID); err != nil {
+		return
+	}
+	err = tx.QueryRow(`SELECT tax, total FROM orders WHERE id=?`, orderID).Scan(&tax, &total)
+	return
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func intPtr(v int) *int { return &v }
+
+// pricingColumns son las columnas que lee priceOrderItems por línea.
+var pricingColumns = []string{"price", "source", "currency", "promotion_id", "min_qty", "qty_multiple", "stock", "is_active", "branch_id"}
+
+// productRow es la fila de un producto tal como la ve priceOrderItems.
+type productRow struct {
+	price       float64
+	source      string
+	currency    string
+	promoID     *int64
+	minQty      *int
+	qtyMultiple *int
+	stock       *int
+	active      bool
+	branchID    int64
+}
+
+func baseProduct(price float64) productRow {
+	return productRow{price: price, source: priceSourceBase, currency: baseCurrency, active: true, branchID: defaultBranchID}
+}
+
+// expectPricing espera la consulta de precio efectivo de una línea.
+func expectPricing(mock sqlmock.Sqlmock, customerID, productID int64, p productRow) {
+	mock.ExpectQuery(sqlText(`FROM products p`)).WithArgs(customerID, productID).
+		WillReturnRows(sqlmock.NewRows(pricingColumns).
+			AddRow(p.price, p.source, p.currency, p.promoID, p.minQty, p.qtyMultiple, p.stock, p.active, p.branchID))
+}
+
+func TestValidOrderQty(t *testing.T) {
+	cases := []struct {
+		name     string
+		qty      int
+		minQty   *int
+		multiple *int
+		want     bool
+	}{
+		{"sin restricciones", 1, nil, nil, true},
+		{"cero", 0, nil, nil, false},
+		{"negativa", -2, nil, nil, false},
+		{"bajo el mínimo", 2, intPtr(3), nil, false},
+		{"en el mínimo", 3, intPtr(3), nil, true},
+		{"no es múltiplo", 7, nil, intPtr(6), false},
+		{"múltiplo", 12, nil, intPtr(6), true},
+		{"mínimo y múltiplo", 6, intPtr(12), intPtr(6), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := validOrderQty(tc.qty, tc.minQty, tc.multiple); got != tc.want {
+				t.Errorf("validOrderQty(%d) = %v, quiero %v", tc.qty, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidQtyConstraints(t *testing.T) {
+	if !validQtyConstraints(nil, nil) {
+		t.Error("sin restricciones debe ser válido")
+	}
+	if validQtyConstraints(intPtr(0), nil) {
+		t.Error("min_qty 0 debe ser inválido")
+	}
+	if validQtyConstraints(nil, intPtr(-1)) {
+		t.Error("qty_multiple negativo debe ser inválido")
+	}
+}
+
+func TestPriceOrderItemsRejectsInvalidQty(t *testing.T) {
+	mock := newMock(t)
+	p := baseProduct(10)
+	p.minQty, p.qtyMultiple = intPtr(2), intPtr(2)
+	expectPricing(mock, 3, 7, p)
+	expectPricing(mock, 3, 8, baseProduct(5))
+
+	_, _, err := priceOrderItems(db, defaultBranchID, 3, []OrderItemReq{{ProductID: 7, Qty: 3}, {ProductID: 8, Qty: 1}})
+	var perr *pricingError
+	if !errors.As(err, &perr) {
+		t.Fatalf("err = %v, quiero pricingError", err)
+	}
+	if len(perr.items) != 1 || perr.items[0].ProductID != 7 || perr.items[0].Reason != itemInvalidQty {
+		t.Errorf("invalid_items = %+v", perr.items)
+	}
+}
+
+func TestCreateProductRejectsInvalidQtyConstraints(t *testing.T) {
+	mock := newMock(t)
+	w := serve(http.MethodPost, "/api/v1/products", `{"name":"Bidón","capacity_liters":20,"price":10,"min_qty":0}`, authAs(t, mock, testAdmin))
+	expectStatus(t, w, http.StatusUnprocessableEntity)
+	if got := decode(t, w)["field"]; got != "min_qty" {
+		t.Errorf("field = %v", got)
+	}
+}
+
+func TestReserveStock(t *testing.T) {
+	items := []pricedItem{{ProductID: 7, Qty: 3}}
+	cases := []struct {
+		name    string
+		enough  bool
+		wantErr bool
+	}{
+		{"stock NULL (ilimitado)", true, false},
+		{"stock insuficiente", false, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			mock.ExpectBegin()
+			// El driver cuenta filas cambiadas: 0 no distingue NULL de falta de stock
+			mock.ExpectExec(sqlText(`UPDATE products SET stock = stock - ?`)).WithArgs(3, int64(7), 3).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(sqlText(`SELECT stock IS NULL OR stock >= ? FROM products WHERE id=?`)).WithArgs(3, int64(7)).
+				WillReturnRows(sqlmock.NewRows([]string{"enough"}).AddRow(tc.enough))
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = reserveStock(tx, items)
+			var perr *pricingError
+			if tc.wantErr != errors.As(err, &perr) {
+				t.Fatalf("err = %v", err)
+			}
+			if tc.wantErr && perr.items[0].Reason != itemOutOfStock {
+				t.Errorf("reason = %s", perr.items[0].Reason)
+			}
+		})
+	}
+}
+
+func TestRestoreOrderStockOnlyOnce(t *testing.T) {
+	mock := newMock(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlText(`UPDATE orders SET stock_restored=TRUE WHERE id=? AND stock_restored=FALSE`)).WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlText(`SET p.stock = p.stock + oi.qty`)).WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 2))
+	// Segunda cancelación: ya devuelto, no se vuelve a sumar
+	mock.ExpectExec(sqlText(`UPDATE orders SET stock_restored=TRUE WHERE id=? AND stock_restored=FALSE`)).WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 0))
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := restoreOrderStock(tx, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPriceOrderItemsSource(t *testing.T) {
+	mock := newMock(t)
+	custom := baseProduct(8)
+	custom.source = priceSourceCustom
+	expectPricing(mock, 3, 7, custom)
+	expectPricing(mock, 3, 8, baseProduct(5))
+
+	priced, subtotal, err := priceOrderItems(db, defaultBranchID, 3, []OrderItemReq{{ProductID: 7, Qty: 2}, {ProductID: 8, Qty: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priced[0].PriceSource != priceSourceCustom || priced[1].PriceSource != priceSourceBase {
+		t.Errorf("price_source = %s, %s", priced[0].PriceSource, priced[1].PriceSource)
+	}
+	if subtotal != 21 {
+		t.Errorf("subtotal = %v", subtotal)
+	}
+}
+
+func TestStatusSQLLists(t *testing.T) {
+	if got, want := closedStatusesSQL, `'entregado','cancelado'`; got != want {
+		t.Errorf("closedStatusesSQL = %s, quiero %s", got, want)
+	}
+	if got, want := inRouteStatusesSQL, `'asignado','en_preparacion','en_camino'`; got != want {
+		t.Errorf("inRouteStatusesSQL = %s, quiero %s", got, want)
+	}
+	// Todo estado conocido está en exactamente una de las listas abierta/cerrada
+	for st := range statusRank {
+		open := strings.Contains(openStatusesSQL, "'"+st+"'")
+		closed := strings.Contains(closedStatusesSQL, "'"+st+"'")
+		if open == closed {
+			t.Errorf("%s: abierto=%v cerrado=%v", st, open, closed)
+		}
+	}
+}
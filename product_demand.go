@@ -0,0 +1,153 @@
+package main
+
+// Demanda comprometida de un producto: unidades en pedidos que todavía no
+// terminaron (ni entregado ni cancelado), para planificar el inventario.
+// La conciliación compara esa demanda con el stock de todos los productos.
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PendingDemand struct {
+	ProductID  int64          `json:"product_id"`
+	Name       string         `json:"name"`
+	Stock      *int           `json:"stock"` // null = ilimitado
+	PendingQty int            `json:"pending_qty"`
+	Orders     int            `json:"orders"`
+	ByStatus   map[string]int `json:"by_status,omitempty"` // solo con ?group_by=status
+}
+
+// GET /api/v1/products/:id/pending-demand (admin), opcional ?group_by=status y
+// ?branch_id=. Solo cuenta los pedidos de la sucursal, igual que los reportes.
+func pendingDemandHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id debe ser numérico"})
+		return
+	}
+	groupBy := c.Query("group_by")
+	if groupBy != "" && groupBy != "status" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by debe ser status"})
+		return
+	}
+	branchID, ok := branchFromRequest(c)
+	if !ok {
+		return
+	}
+	d := PendingDemand{ProductID: id}
+	err = db.QueryRowContext(c.Request.Context(), `SELECT name, stock FROM products WHERE id=?`, id).Scan(&d.Name, &d.Stock)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "producto no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT o.status, COALESCE(SUM(oi.qty), 0), COUNT(DISTINCT o.id)
+        FROM order_items oi
+        JOIN orders o ON o.id = oi.order_id
+        WHERE oi.product_id=? AND o.branch_id=? AND o.status NOT IN (`+closedStatusesSQL+`)
+        GROUP BY o.status`, id, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	if groupBy == "status" {
+		d.ByStatus = map[string]int{}
+	}
+	for rows.Next() {
+		var status string
+		var qty, orders int
+		if err := rows.Scan(&status, &qty, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// Un pedido está en un solo estado, así que los conteos por estado se pueden sumar
+		d.PendingQty += qty
+		d.Orders += orders
+		if d.ByStatus != nil {
+			d.ByStatus[status] = qty
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+type StockReconciliation struct {
+	ProductID    int64  `json:"product_id"`
+	Name         string `json:"name"`
+	Stock        int    `json:"stock"` // disponible: ya descontadas las reservas
+	CommittedQty int    `json:"committed_qty"`
+	OnHand       int    `json:"on_hand"` // stock + committed_qty: unidades físicas antes de entregar
+	OpenOrders   int    `json:"open_orders"`
+	Oversold     bool   `json:"oversold"`
+}
+
+// GET /api/v1/admin/stock-reconciliation (admin), opcional ?branch_id= y ?only_oversold=true
+// Por producto con stock controlado (los ilimitados no se listan): stock disponible
+// y unidades comprometidas en pedidos abiertos. reserveStock ya descontó esas
+// unidades de stock al crear los pedidos, así que comparar lo comprometido con el
+// stock las contaría dos veces: oversold marca solo el stock negativo, es decir,
+// más unidades comprometidas que las que había (típicamente por una edición manual).
+func stockReconciliationHandler(c *gin.Context) {
+	branchID, ok := branchFromRequest(c)
+	if !ok {
+		return
+	}
+	onlyOversold := false
+	if v := c.Query("only_oversold"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only_oversold debe ser true o false"})
+			return
+		}
+		onlyOversold = b
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT p.id, p.name, p.stock, COALESCE(SUM(CASE WHEN o.id IS NOT NULL THEN oi.qty END), 0), COUNT(DISTINCT o.id)
+        FROM products p
+        LEFT JOIN order_items oi ON oi.product_id = p.id
+        LEFT JOIN orders o ON o.id = oi.order_id AND o.status NOT IN (`+closedStatusesSQL+`)
+        WHERE p.branch_id=? AND p.stock IS NOT NULL
+        GROUP BY p.id, p.name, p.stock
+        ORDER BY p.id`, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	out := []StockReconciliation{}
+	oversold := 0
+	for rows.Next() {
+		var r StockReconciliation
+		if err := rows.Scan(&r.ProductID, &r.Name, &r.Stock, &r.CommittedQty, &r.OpenOrders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		r.OnHand = r.Stock + r.CommittedQty
+		r.Oversold = r.Stock < 0
+		if r.Oversold {
+			oversold++
+		} else if onlyOversold {
+			continue
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"products": out, "oversold": oversold})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPendingDemand(t *testing.T) {
+	expectDemand := func(mock sqlmock.Sqlmock, branchID int64) {
+		mock.ExpectQuery(sqlText(`SELECT name, stock FROM products WHERE id=?`)).WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "stock"}).AddRow("Bidón 20L", 12))
+		mock.ExpectQuery(sqlText(`WHERE oi.product_id=? AND o.branch_id=? AND o.status NOT IN (`)).WithArgs(int64(7), branchID).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "qty", "orders"}).
+				AddRow(statusPorAtender, 5, 2).AddRow(statusEnCamino, 3, 1))
+	}
+
+	t.Run("total", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectDemand(mock, defaultBranchID)
+		w := serve(http.MethodGet, "/api/v1/products/7/pending-demand", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["pending_qty"] != float64(8) || body["orders"] != float64(3) || body["stock"] != float64(12) {
+			t.Errorf("demanda = %v", body)
+		}
+		if _, ok := body["by_status"]; ok {
+			t.Error("by_status solo con ?group_by=status")
+		}
+	})
+	t.Run("por estado", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectDemand(mock, defaultBranchID)
+		w := serve(http.MethodGet, "/api/v1/products/7/pending-demand?group_by=status", "", h)
+		expectStatus(t, w, http.StatusOK)
+		byStatus, _ := decode(t, w)["by_status"].(map[string]any)
+		if byStatus[statusPorAtender] != float64(5) || byStatus[statusEnCamino] != float64(3) {
+			t.Errorf("by_status = %v", byStatus)
+		}
+	})
+	t.Run("sucursal del admin", func(t *testing.T) {
+		mock := newMock(t)
+		admin := testAdmin
+		admin.BranchID = int64Ptr(2)
+		h := authAs(t, mock, admin)
+		expectDemand(mock, 2)
+		w := serve(http.MethodGet, "/api/v1/products/7/pending-demand", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("producto inexistente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`SELECT name, stock FROM products WHERE id=?`)).WithArgs(int64(99)).WillReturnError(sql.ErrNoRows)
+		w := serve(http.MethodGet, "/api/v1/products/99/pending-demand", "", h)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+}
+
+func TestStockReconciliation(t *testing.T) {
+	expectReconciliation := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(sqlText(`WHERE p.branch_id=? AND p.stock IS NOT NULL`)).WithArgs(defaultBranchID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "stock", "committed", "open_orders"}).
+				AddRow(7, "Bidón 20L", 10, 6, 3).
+				AddRow(8, "Bidón 7L", -2, 5, 2))
+	}
+
+	t.Run("todos", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectReconciliation(mock)
+		w := serve(http.MethodGet, "/api/v1/admin/stock-reconciliation", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		products, _ := body["products"].([]any)
+		if body["oversold"] != float64(1) || len(products) != 2 {
+			t.Fatalf("conciliación = %v", body)
+		}
+		// Lo comprometido ya está descontado del stock: no cuenta como sobreventa
+		if p := products[0].(map[string]any); p["oversold"] != false || p["on_hand"] != float64(16) {
+			t.Errorf("producto con stock = %v", p)
+		}
+		if p := products[1].(map[string]any); p["oversold"] != true || p["on_hand"] != float64(3) {
+			t.Errorf("producto sobrevendido = %v", p)
+		}
+	})
+	t.Run("solo sobrevendidos", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectReconciliation(mock)
+		w := serve(http.MethodGet, "/api/v1/admin/stock-reconciliation?only_oversold=true", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		products, _ := body["products"].([]any)
+		if len(products) != 1 || products[0].(map[string]any)["product_id"] != float64(8) || body["oversold"] != float64(1) {
+			t.Errorf("conciliación = %v", body)
+		}
+	})
+	t.Run("only_oversold inválido", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/admin/stock-reconciliation?only_oversold=si", "", authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
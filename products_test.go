@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var catalogColumns = []string{"id", "name", "capacity_liters", "price", "source", "currency", "is_active", "min_qty", "qty_multiple", "branch_id", "stock", "promotion_id", "discount_percent", "promo_price", "ends_at", "base_price"}
+
+// catalogProduct es un producto activo de la sucursal por defecto, a precio base.
+func catalogProduct(id int64, name string, price float64) Product {
+	return Product{ID: id, Name: name, Price: price, Currency: baseCurrency, IsActive: true, BranchID: defaultBranchID, priceSource: priceSourceBase}
+}
+
+// catalogRows arma las filas del listado de productos; base es el precio de
+// lista (distinto de price con promoción o precio del cliente).
+func catalogRows(products ...Product) *sqlmock.Rows {
+	rows := sqlmock.NewRows(catalogColumns)
+	for _, p := range products {
+		var promoID, percent, promoPrice, endsAt any
+		base := p.Price
+		if pr := p.Promotion; pr != nil {
+			promoID, percent, promoPrice, endsAt, base = pr.ID, pr.DiscountPercent, pr.PromoPrice, pr.EndsAt, pr.BasePrice
+		}
+		rows.AddRow(p.ID, p.Name, p.CapacityLiters, p.Price, p.priceSource, p.Currency, p.IsActive, p.MinQty, p.QtyMultiple, p.BranchID, p.Stock, promoID, percent, promoPrice, endsAt, base)
+	}
+	return rows
+}
+
+// expectCatalog espera la consulta de listProductsHandler sin ?customer_id=.
+func expectCatalog(mock sqlmock.Sqlmock, rows *sqlmock.Rows, filterArgs ...any) {
+	args := []driver.Value{"", defaultBranchID}
+	for _, a := range filterArgs {
+		args = append(args, a)
+	}
+	mock.ExpectQuery(sqlText(`WHERE p.is_active = TRUE AND p.branch_id = ?`)).WithArgs(args...).WillReturnRows(rows)
+}
+
+func TestListProductsStock(t *testing.T) {
+	limited := catalogProduct(7, "Bidón 20L", 10)
+	limited.Stock = intPtr(4)
+	unlimited := catalogProduct(8, "Botella 1L", 2)
+
+	mock := newMock(t)
+	expectCatalog(mock, catalogRows(limited, unlimited))
+	w := serve(http.MethodGet, "/api/v1/products", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if body := w.Body.String(); !strings.Contains(body, `"stock":4`) || !strings.Contains(body, `"stock":null`) {
+		t.Errorf("cuerpo = %s", body)
+	}
+}
+
+func TestListProductsInStockFilter(t *testing.T) {
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`AND (p.stock IS NULL OR p.stock > 0)`)).WithArgs("", defaultBranchID).WillReturnRows(catalogRows())
+	w := serve(http.MethodGet, "/api/v1/products?in_stock=true", "", nil)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestListProductsCapacityRange(t *testing.T) {
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`AND p.capacity_liters >= ? AND p.capacity_liters <= ?`)).
+		WithArgs("", defaultBranchID, 5.0, 20.0).WillReturnRows(catalogRows(catalogProduct(7, "Bidón 20L", 10)))
+	w := serve(http.MethodGet, "/api/v1/products?min_capacity=5&max_capacity=20", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	for _, q := range []string{"min_capacity=-1", "max_capacity=veinte"} {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/products?"+q, "", nil)
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+}
+
+func TestListProductsPriceWithDelivery(t *testing.T) {
+	setVar(t, &warehouseLat, floatPtr(-12.0464))
+	setVar(t, &warehouseLng, floatPtr(-77.0428))
+	setVar(t, &feeTiers, []DeliveryFeeTier{{MaxKm: 5, Fee: 3.5}})
+
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT lat, lng FROM addresses WHERE id=? AND user_id=?`)).WithArgs("20", "3").
+		WillReturnRows(sqlmock.NewRows([]string{"lat", "lng"}).AddRow(-12.0464, -77.0428))
+	mock.ExpectQuery(sqlText(`WHERE p.is_active = TRUE AND p.branch_id = ?`)).WithArgs("3", defaultBranchID).
+		WillReturnRows(catalogRows(catalogProduct(7, "Bidón 20L", 10)))
+
+	w := serve(http.MethodGet, "/api/v1/products?customer_id=3&address_id=20", "", h)
+	expectStatus(t, w, http.StatusOK)
+	if body := w.Body.String(); !strings.Contains(body, `"delivery_fee":3.5`) || !strings.Contains(body, `"price_with_delivery":13.5`) {
+		t.Errorf("cuerpo = %s", body)
+	}
+
+	t.Run("address_id sin customer_id", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/products?address_id=20", "", nil)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestCloneProduct(t *testing.T) {
+	sourceRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"capacity_liters", "price", "currency", "is_active", "min_qty", "qty_multiple", "branch_id", "stock"}).
+			AddRow(20.0, 12.5, baseCurrency, true, 2, nil, defaultBranchID, 40)
+	}
+	t.Run("con precios por cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`FROM products WHERE id=?`)).WithArgs(int64(10)).WillReturnRows(sourceRow())
+		// El clon arranca sin stock: las unidades no se duplican
+		mock.ExpectExec(sqlText(`INSERT INTO products(`)).
+			WithArgs("Bidón 20L (copia)", 20.0, 12.5, baseCurrency, true, 0, 2, nil, defaultBranchID).
+			WillReturnResult(sqlmock.NewResult(11, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO product_prices(product_id, currency, price) SELECT ?, currency, price FROM product_prices WHERE product_id=?`)).
+			WithArgs(int64(11), int64(10)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO customer_product_prices(`)).WithArgs(int64(11), int64(10)).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/products/10/clone", `{"name":" Bidón 20L (copia) ","copy_customer_prices":true}`, h)
+		expectStatus(t, w, http.StatusCreated)
+		body := decode(t, w)
+		product, _ := body["product"].(map[string]any)
+		if body["cloned_from"] != float64(10) || body["customer_prices_copied"] != float64(3) || product["id"] != float64(11) || product["stock"] != float64(0) {
+			t.Errorf("respuesta = %v", body)
+		}
+	})
+	t.Run("sin precios por cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`FROM products WHERE id=?`)).WithArgs(int64(10)).WillReturnRows(sourceRow())
+		mock.ExpectExec(sqlText(`INSERT INTO products(`)).WillReturnResult(sqlmock.NewResult(11, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO product_prices(`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/products/10/clone", `{"name":"Copia"}`, h)
+		expectStatus(t, w, http.StatusCreated)
+		if got := decode(t, w)["customer_prices_copied"]; got != float64(0) {
+			t.Errorf("customer_prices_copied = %v", got)
+		}
+	})
+	t.Run("origen inexistente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`FROM products WHERE id=?`)).WithArgs(int64(99)).WillReturnRows(sqlmock.NewRows(nil))
+		mock.ExpectRollback()
+
+		w := serve(http.MethodPost, "/api/v1/products/99/clone", `{"name":"Copia"}`, h)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+	t.Run("sin nombre", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPost, "/api/v1/products/10/clone", `{"name":"  "}`, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPost, "/api/v1/products/10/clone", `{"name":"Copia"}`, authAs(t, mock, testCustomer))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+}
+
+func TestUpdateProductIfMatch(t *testing.T) {
+	const body = `{"name":"Bidón 20L","price":12.5}`
+	ifMatch := func(h http.Header, v string) http.Header {
+		h.Set("If-Match", v)
+		return h
+	}
+	expectUpdate := func(mock sqlmock.Sqlmock, version int, affected int64) {
+		mock.ExpectExec(sqlText(`version=version+1 WHERE id=? AND version=?`)).
+			WithArgs("Bidón 20L", nil, 12.5, nil, true, nil, nil, nil, "10", version).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+	}
+
+	t.Run("versión actual", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectUpdate(mock, 3, 1)
+		w := serve(http.MethodPut, "/api/v1/products/10", body, ifMatch(h, `W/"3"`))
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["version"]; got != float64(4) {
+			t.Errorf("version = %v, quiero 4", got)
+		}
+	})
+	t.Run("versión vieja", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectUpdate(mock, 2, 0)
+		mock.ExpectQuery(sqlText(`SELECT EXISTS(SELECT 1 FROM products WHERE id=?)`)).WithArgs("10").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		w := serve(http.MethodPut, "/api/v1/products/10", body, ifMatch(h, "2"))
+		expectStatus(t, w, http.StatusPreconditionFailed)
+	})
+	t.Run("producto inexistente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectUpdate(mock, 2, 0)
+		mock.ExpectQuery(sqlText(`SELECT EXISTS(SELECT 1 FROM products WHERE id=?)`)).WithArgs("10").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		w := serve(http.MethodPut, "/api/v1/products/10", body, ifMatch(h, "2"))
+		expectStatus(t, w, http.StatusNotFound)
+	})
+	t.Run("ETag del GET", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		detailColumns := append(slices.Clone(catalogColumns[:11]), append([]string{"version"}, catalogColumns[11:]...)...)
+		mock.ExpectQuery(sqlText(`WHERE p.id = ?`)).WithArgs("", "10").
+			WillReturnRows(sqlmock.NewRows(detailColumns).AddRow(10, "Bidón 20L", 20.0, 12.0, priceSourceBase, baseCurrency, true, nil, nil, defaultBranchID, nil, 3, nil, nil, nil, nil, 12.0))
+		w := serve(http.MethodGet, "/api/v1/products/10", "", h)
+		expectStatus(t, w, http.StatusOK)
+		etag := w.Header().Get("ETag")
+		if !strings.HasPrefix(etag, `"3.`) {
+			t.Fatalf("ETag = %q, quiero la versión delante", etag)
+		}
+
+		h = authAs(t, mock, testAdmin)
+		expectUpdate(mock, 3, 1)
+		w = serve(http.MethodPut, "/api/v1/products/10", body, ifMatch(h, etag))
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPut, "/api/v1/products/10", body, ifMatch(authAs(t, mock, testCustomer), "3"))
+		expectStatus(t, w, http.StatusForbidden)
+		newMock(t)
+		w = serve(http.MethodDelete, "/api/v1/products/10", "", nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+	t.Run("sin If-Match", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPut, "/api/v1/products/10", body, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusPreconditionRequired)
+	})
+	for _, v := range []string{"*", `"abc"`, "0"} {
+		t.Run("If-Match "+v, func(t *testing.T) {
+			mock := newMock(t)
+			w := serve(http.MethodPut, "/api/v1/products/10", body, ifMatch(authAs(t, mock, testAdmin), v))
+			expectStatus(t, w, http.StatusBadRequest)
+		})
+	}
+}
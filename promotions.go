@@ -0,0 +1,198 @@
+package main
+
+// Promociones por producto con vigencia (starts_at ≤ ahora < ends_at), para todos
+// los clientes. Precedencia del precio efectivo: precio personalizado del cliente >
+// promoción vigente > precio base. Con varias promociones vigentes del mismo
+// producto se aplica la de menor precio.
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activePromoJoin une a products p (alias pr) la mejor promoción vigente del producto.
+const activePromoJoin = `
+            LEFT JOIN promotions pr ON pr.id = (
+                SELECT pr2.id FROM promotions pr2
+                WHERE pr2.product_id = p.id AND pr2.is_active = TRUE AND pr2.starts_at <= NOW() AND pr2.ends_at > NOW()
+                ORDER BY COALESCE(pr2.promo_price, ROUND(p.price * (100 - pr2.discount_percent) / 100, 2)), pr2.id
+                LIMIT 1)`
+
+// promoPriceSQL es el precio de la promoción unida con activePromoJoin (NULL si no hay).
+const promoPriceSQL = `COALESCE(pr.promo_price, ROUND(p.price * (100 - pr.discount_percent) / 100, 2))`
+
+// effectivePriceSQL y priceSourceSQL resuelven la precedencia con cpp (precio del
+// cliente) y pr (promoción) ya unidos.
+const (
+	effectivePriceSQL = `COALESCE(cpp.price, ` + promoPriceSQL + `, p.price)`
+	priceSourceSQL    = `CASE WHEN cpp.price IS NOT NULL THEN '` + priceSourceCustom + `' WHEN pr.id IS NOT NULL THEN '` + priceSourcePromo + `' ELSE '` + priceSourceBase + `' END`
+	// Columnas de la promoción aplicada: solo si el precio del cliente no la pisa
+	appliedPromoColumns = `CASE WHEN cpp.price IS NULL THEN pr.id END, pr.discount_percent, pr.promo_price, pr.ends_at, p.price`
+)
+
+type Promotion struct {
+	ID              int64     `json:"id"`
+	ProductID       int64     `json:"product_id"`
+	DiscountPercent *float64  `json:"discount_percent,omitempty"`
+	PromoPrice      *float64  `json:"promo_price,omitempty"`
+	StartsAt        time.Time `json:"starts_at"`
+	EndsAt          time.Time `json:"ends_at"`
+	IsActive        bool      `json:"is_active"`
+}
+
+// AppliedPromotion es la promoción que fija el precio de un producto en el listado.
+type AppliedPromotion struct {
+	ID              int64     `json:"id"`
+	DiscountPercent *float64  `json:"discount_percent,omitempty"`
+	PromoPrice      *float64  `json:"promo_price,omitempty"`
+	EndsAt          time.Time `json:"ends_at"`
+	BasePrice       float64   `json:"base_price"`
+}
+
+// promoScan recibe appliedPromoColumns.
+type promoScan struct {
+	id        *int64
+	percent   *float64
+	price     *float64
+	endsAt    *time.Time
+	basePrice float64
+}
+
+func (s *promoScan) dest() []any {
+	return []any{&s.id, &s.percent, &s.price, &s.endsAt, &s.basePrice}
+}
+
+func (s *promoScan) applied() *AppliedPromotion {
+	if s.id == nil || s.endsAt == nil {
+		return nil
+	}
+	return &AppliedPromotion{ID: *s.id, DiscountPercent: s.percent, PromoPrice: s.price, EndsAt: *s.endsAt, BasePrice: s.basePrice}
+}
+
+type CreatePromotionReq struct {
+	ProductID       int64    `json:"product_id"`
+	DiscountPercent *float64 `json:"discount_percent"`
+	PromoPrice      *float64 `json:"promo_price"`
+	StartsAt        string   `json:"starts_at"` // RFC3339
+	EndsAt          string   `json:"ends_at"`
+}
+
+// POST /api/v1/promotions (admin)
+func createPromotionHandler(c *gin.Context) {
+	var req CreatePromotionReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.ProductID == 0 {
+		respondInvalid(c, "product_id", "product_id requerido")
+		return
+	}
+	if (req.DiscountPercent == nil) == (req.PromoPrice == nil) {
+		respondInvalid(c, "discount_percent", "se requiere exactamente uno de discount_percent o promo_price")
+		return
+	}
+	if req.DiscountPercent != nil && (*req.DiscountPercent <= 0 || *req.DiscountPercent >= 100) {
+		respondInvalid(c, "discount_percent", "discount_percent debe estar entre 0 y 100")
+		return
+	}
+	if req.PromoPrice != nil && *req.PromoPrice <= 0 {
+		respondInvalid(c, "promo_price", "promo_price debe ser mayor a 0")
+		return
+	}
+	startsAt, err := time.Parse(time.RFC3339, req.StartsAt)
+	if err != nil {
+		respondInvalid(c, "starts_at", "starts_at debe ser RFC3339")
+		return
+	}
+	endsAt, err := time.Parse(time.RFC3339, req.EndsAt)
+	if err != nil {
+		respondInvalid(c, "ends_at", "ends_at debe ser RFC3339")
+		return
+	}
+	if !endsAt.After(startsAt) {
+		respondInvalid(c, "ends_at", "ends_at debe ser posterior a starts_at")
+		return
+	}
+	var exists bool
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT EXISTS(SELECT 1 FROM products WHERE id=?)`, req.ProductID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		respondInvalid(c, "product_id", "product_id inválido")
+		return
+	}
+	res, err := db.ExecContext(c.Request.Context(), `INSERT INTO promotions(product_id, discount_percent, promo_price, starts_at, ends_at) VALUES (?,?,?,?,?)`,
+		req.ProductID, req.DiscountPercent, req.PromoPrice, startsAt, endsAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+// GET /api/v1/promotions (admin), opcional ?product_id= y ?current=true (solo vigentes)
+func listPromotionsHandler(c *gin.Context) {
+	if !numericQuery(c, "product_id") {
+		return
+	}
+	where := ` WHERE 1=1`
+	var args []any
+	if v := c.Query("product_id"); v != "" {
+		where += ` AND product_id=?`
+		args = append(args, v)
+	}
+	if v := c.Query("current"); v != "" {
+		current, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "current debe ser true o false"})
+			return
+		}
+		if current {
+			where += ` AND is_active=TRUE AND starts_at<=NOW() AND ends_at>NOW()`
+		}
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT id, product_id, discount_percent, promo_price, starts_at, ends_at, is_active FROM promotions`+where+` ORDER BY starts_at DESC, id DESC`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := []Promotion{}
+	for rows.Next() {
+		var p Promotion
+		if err := rows.Scan(&p.ID, &p.ProductID, &p.DiscountPercent, &p.PromoPrice, &p.StartsAt, &p.EndsAt, &p.IsActive); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, p)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// DELETE /api/v1/promotions/:id (admin): la desactiva; las líneas de pedidos que
+// ya la usaron conservan su precio y promotion_id.
+func deletePromotionHandler(c *gin.Context) {
+	var id int64
+	err := db.QueryRowContext(c.Request.Context(), `SELECT id FROM promotions WHERE id=?`, c.Param("id")).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "promoción no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := db.ExecContext(c.Request.Context(), `UPDATE promotions SET is_active=FALSE WHERE id=?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
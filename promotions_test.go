@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// La precedencia se resuelve en SQL: el precio del cliente va antes que la
+// promoción y la promoción antes que el base, tanto en el precio como en el origen.
+func TestPromotionPrecedenceSQL(t *testing.T) {
+	inOrder := func(s string, parts ...string) bool {
+		at := -1
+		for _, p := range parts {
+			i := strings.Index(s, p)
+			if i <= at {
+				return false
+			}
+			at = i
+		}
+		return true
+	}
+	if !strings.HasPrefix(effectivePriceSQL, "COALESCE(cpp.price, "+promoPriceSQL) || !strings.HasSuffix(effectivePriceSQL, ", p.price)") {
+		t.Errorf("effectivePriceSQL = %s", effectivePriceSQL)
+	}
+	if !inOrder(priceSourceSQL, "cpp.price IS NOT NULL", priceSourceCustom, "pr.id IS NOT NULL", priceSourcePromo, priceSourceBase) {
+		t.Errorf("priceSourceSQL = %s", priceSourceSQL)
+	}
+	// Con varias promociones vigentes gana la de menor precio
+	if !strings.Contains(activePromoJoin, "ORDER BY COALESCE(pr2.promo_price, ROUND(p.price * (100 - pr2.discount_percent) / 100, 2)), pr2.id") {
+		t.Errorf("activePromoJoin = %s", activePromoJoin)
+	}
+}
+
+func TestListProductsPromotionPrecedence(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testCustomer)
+	promo := catalogProduct(7, "Bidón 20L", 9)
+	promo.priceSource = priceSourcePromo
+	promo.Promotion = &AppliedPromotion{ID: 5, DiscountPercent: floatPtr(10), EndsAt: testNow, BasePrice: 10}
+	rows := catalogRows(promo, catalogProduct(9, "Bidón 7L", 6))
+	// Precio del cliente sobre un producto con promoción: appliedPromoColumns deja
+	// pr.id en NULL y la promoción no aparece
+	rows.AddRow(8, "Bidón 10L", nil, 7.5, priceSourceCustom, baseCurrency, true, nil, nil, defaultBranchID, nil, nil, 15.0, nil, testNow, 9.0)
+	mock.ExpectQuery(sqlText(`WHERE p.is_active = TRUE AND p.branch_id = ?`)).WithArgs("3", defaultBranchID).WillReturnRows(rows)
+
+	w := serve(http.MethodGet, "/api/v1/products?customer_id=3", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := w.Body.String()
+	for _, want := range []string{
+		`"id":7,"name":"Bidón 20L","price":9,`,
+		`"promotion":{"id":5,"discount_percent":10,"ends_at":`,
+		`"base_price":10}`,
+		`"id":8,"name":"Bidón 10L","price":7.5,`,
+		`"id":9,"name":"Bidón 7L","price":6,`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("falta %s en %s", want, body)
+		}
+	}
+	if strings.Count(body, `"promotion"`) != 1 {
+		t.Errorf("solo el producto sin precio del cliente lleva promoción: %s", body)
+	}
+}
+
+func TestCreateOrderWithPromotion(t *testing.T) {
+	promoID := int64(5)
+	product := baseProduct(9)
+	product.source, product.promoID = priceSourcePromo, &promoID
+	line := orderLine{productID: 7, qty: 2, product: product}
+
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectBranchActive(mock, defaultBranchID)
+	mock.ExpectBegin()
+	expectPrepareOrder(mock, line)
+	mock.ExpectExec(sqlText(`INSERT INTO orders(`)).WithArgs(insertOrderArgs(testAdmin.ID)...).WillReturnResult(sqlmock.NewResult(50, 1))
+	// La línea guarda el origen del precio y la promoción aplicada
+	mock.ExpectExec(sqlText(`INSERT INTO order_items(order_id, product_id, qty, unit_price, price_source, promotion_id)`)).
+		WithArgs(int64(50), int64(7), 2, 9.0, priceSourcePromo, promoID).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(sqlText(`UPDATE products SET stock = stock - ?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlText(`INSERT INTO order_status_history`)).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`, h)
+	expectStatus(t, w, http.StatusCreated)
+}
+
+func TestCreatePromotionValidation(t *testing.T) {
+	cases := []struct {
+		name, body, field string
+	}{
+		{"ni descuento ni precio", `{"product_id":7,"starts_at":"2026-10-01T00:00:00Z","ends_at":"2026-11-01T00:00:00Z"}`, "discount_percent"},
+		{"descuento y precio", `{"product_id":7,"discount_percent":10,"promo_price":8,"starts_at":"2026-10-01T00:00:00Z","ends_at":"2026-11-01T00:00:00Z"}`, "discount_percent"},
+		{"descuento del 100%", `{"product_id":7,"discount_percent":100,"starts_at":"2026-10-01T00:00:00Z","ends_at":"2026-11-01T00:00:00Z"}`, "discount_percent"},
+		{"termina antes de empezar", `{"product_id":7,"promo_price":8,"starts_at":"2026-11-01T00:00:00Z","ends_at":"2026-10-01T00:00:00Z"}`, "ends_at"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			w := serve(http.MethodPost, "/api/v1/promotions", tc.body, authAs(t, mock, testAdmin))
+			expectStatus(t, w, http.StatusUnprocessableEntity)
+			if got := decode(t, w)["field"]; got != tc.field {
+				t.Errorf("field = %v, quiero %s", got, tc.field)
+			}
+		})
+	}
+}
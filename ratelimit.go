@@ -0,0 +1,116 @@
+package main
+
+// Límite de creación de pedidos por quien llama.
+// Ventana deslizante en memoria: como mucho ORDER_RATE_LIMIT pedidos cada
+// ORDER_RATE_WINDOW_SECONDS por usuario autenticado o, sin autenticación, por IP.
+// No se usa el customer_id del body: lo elige quien llama y permitiría esquivar el
+// límite o gastar el cupo de otro cliente. Los admins (agentes que cargan pedidos
+// a nombre de clientes) no se limitan. Solo cuentan los pedidos creados. Con
+// varias instancias el límite es por proceso. ORDER_RATE_LIMIT=0 lo desactiva.
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	orderRateLimit  = 5
+	orderRateWindow = time.Minute
+)
+
+func loadRateLimitConfig() {
+	orderRateLimit = envInt("ORDER_RATE_LIMIT", orderRateLimit)
+	orderRateWindow = time.Duration(envInt("ORDER_RATE_WINDOW_SECONDS", int(orderRateWindow/time.Second))) * time.Second
+}
+
+// rateLimiter guarda los instantes de los últimos intentos por clave. Las claves
+// sin intentos dentro de la ventana se borran para que el mapa no crezca sin fin.
+type rateLimiter struct {
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+var orderCreateLimiter = &rateLimiter{hits: map[string][]time.Time{}}
+
+// orderRateKey identifica a quien crea el pedido; ok=false si no se limita (admin).
+func orderRateKey(c *gin.Context) (string, bool) {
+	if u, ok := currentUser(c); ok {
+		if u.RoleID == roleAdmin {
+			return "", false
+		}
+		return "user:" + strconv.FormatInt(u.ID, 10), true
+	}
+	return "ip:" + c.ClientIP(), true
+}
+
+// allow registra un intento para key si hay cupo. Si no lo hay devuelve
+// false y cuánto falta para que se libere el intento más antiguo.
+func (l *rateLimiter) allow(key string, limit int, window time.Duration, now time.Time) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-window)
+	if now.Sub(l.lastSweep) >= window {
+		l.sweep(cutoff)
+		l.lastSweep = now
+	}
+	recent := l.hits[key][:0]
+	for _, t := range l.hits[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		l.hits[key] = recent
+		return false, recent[0].Sub(cutoff)
+	}
+	l.hits[key] = append(recent, now)
+	return true, 0
+}
+
+// undo quita el intento registrado en at: la petición no llegó a crear el pedido
+// (validación, error) y no debe gastar cupo.
+func (l *rateLimiter) undo(key string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hits := l.hits[key]
+	for i, t := range hits {
+		if t.Equal(at) {
+			hits = append(hits[:i], hits[i+1:]...)
+			break
+		}
+	}
+	if len(hits) == 0 {
+		delete(l.hits, key)
+		return
+	}
+	l.hits[key] = hits
+}
+
+// sweep borra las claves cuyos intentos ya salieron de la ventana (con l.mu tomado).
+func (l *rateLimiter) sweep(cutoff time.Time) {
+	for key, hits := range l.hits {
+		if len(hits) == 0 || !hits[len(hits)-1].After(cutoff) {
+			delete(l.hits, key)
+		}
+	}
+}
+
+// respondRateLimited contesta 429 con Retry-After en segundos (redondeado hacia arriba).
+func respondRateLimited(c *gin.Context, retryAfter time.Duration) {
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "demasiados pedidos, intente más tarde"})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWindow(t *testing.T) {
+	l := &rateLimiter{hits: map[string][]time.Time{}}
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	for i := range 2 {
+		if ok, _ := l.allow("user:3", 2, time.Minute, start.Add(time.Duration(i)*time.Second)); !ok {
+			t.Fatalf("intento %d rechazado", i)
+		}
+	}
+	ok, retry := l.allow("user:3", 2, time.Minute, start.Add(10*time.Second))
+	if ok {
+		t.Fatal("el tercer intento en la ventana debe rechazarse")
+	}
+	if retry != 50*time.Second {
+		t.Errorf("retry = %v, quiero 50s", retry)
+	}
+	if ok, _ := l.allow("user:4", 2, time.Minute, start.Add(10*time.Second)); !ok {
+		t.Error("el cupo es por clave")
+	}
+	if ok, _ := l.allow("user:3", 2, time.Minute, start.Add(61*time.Second)); !ok {
+		t.Error("el intento más antiguo ya salió de la ventana")
+	}
+}
+
+func TestRateLimiterUndo(t *testing.T) {
+	l := &rateLimiter{hits: map[string][]time.Time{}}
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	l.allow("ip:192.0.2.1", 1, time.Minute, now)
+	l.undo("ip:192.0.2.1", now)
+	if _, ok := l.hits["ip:192.0.2.1"]; ok {
+		t.Error("undo debe borrar la clave sin intentos")
+	}
+	if ok, _ := l.allow("ip:192.0.2.1", 1, time.Minute, now.Add(time.Second)); !ok {
+		t.Error("el intento deshecho no debe gastar cupo")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := &rateLimiter{hits: map[string][]time.Time{}}
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	l.allow("user:3", 5, time.Minute, now)
+	l.allow("user:4", 5, time.Minute, now.Add(2*time.Minute))
+	if _, ok := l.hits["user:3"]; ok {
+		t.Error("las claves fuera de la ventana se barren")
+	}
+}
+
+func TestCreateOrderRateLimited(t *testing.T) {
+	newMock(t)
+	freshRateLimiter(t)
+	setVar(t, &orderRateLimit, 1)
+	orderCreateLimiter.allow("ip:192.0.2.1", 1, orderRateWindow, time.Now())
+
+	w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`, nil)
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("falta Retry-After")
+	}
+}
+
+func TestCreateOrderFailureDoesNotSpendQuota(t *testing.T) {
+	mock := newMock(t)
+	freshRateLimiter(t)
+	setVar(t, &orderRateLimit, 1)
+	mock.ExpectQuery(sqlText(`SELECT COUNT(1) FROM branches`)).WithArgs(defaultBranchID).WillReturnRows(countRows(0))
+
+	w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`, nil)
+	expectStatus(t, w, http.StatusBadRequest)
+	if n := len(orderCreateLimiter.hits["ip:192.0.2.1"]); n != 0 {
+		t.Errorf("intentos registrados = %d, quiero 0", n)
+	}
+}
+
+func TestOrderRateKey(t *testing.T) {
+	c, _ := testContext("/api/v1/orders")
+	if key, limited := orderRateKey(c); !limited || key != "ip:192.0.2.1" {
+		t.Errorf("anónimo: %q, %v", key, limited)
+	}
+	c.Set(authUserKey, testCustomer)
+	if key, limited := orderRateKey(c); !limited || key != "user:3" {
+		t.Errorf("cliente: %q, %v", key, limited)
+	}
+	c.Set(authUserKey, testAdmin)
+	if _, limited := orderRateKey(c); limited {
+		t.Error("los admins no se limitan")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+// Refresh tokens: el login entrega un access token corto (JWT) y un refresh token
+// largo. El refresh token se guarda hasheado (sha256) en refresh_tokens y rota en
+// cada uso; todos los de una misma sesión comparten family_id. Si se presenta un
+// token ya rotado o revocado se asume robo y se revoca la familia completa.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var refreshTTL = 7 * 24 * time.Hour
+
+func loadRefreshConfig() {
+	refreshTTL = time.Duration(envInt("REFRESH_TTL_HOURS", int(refreshTTL/time.Hour))) * time.Hour
+}
+
+var errInvalidRefresh = errors.New("refresh token inválido o expirado")
+
+type RefreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashRefreshToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// execer lo cumplen *sql.DB y *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// issueRefreshToken crea un refresh token en la familia dada (vacía = sesión nueva)
+// y devuelve el token en claro, que solo se entrega al cliente.
+func issueRefreshToken(q execer, userID int64, family string) (string, time.Time, error) {
+	raw, err := randomHex(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if family == "" {
+		if family, err = randomHex(16); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+	exp := time.Now().Add(refreshTTL)
+	_, err = q.Exec(`INSERT INTO refresh_tokens(user_id, token_hash, family_id, expires_at) VALUES (?,?,?,?)`,
+		userID, hashRefreshToken(raw), family, exp)
+	return raw, exp, err
+}
+
+// revokeFamily invalida todos los refresh tokens de una sesión.
+func revokeFamily(q execer, family string) error {
+	_, err := q.Exec(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=? AND revoked_at IS NULL`, family)
+	return err
+}
+
+// POST /api/v1/token/refresh: rota el refresh token y emite un access token nuevo.
+func refreshTokenHandler(c *gin.Context) {
+	var req RefreshReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.RefreshToken == "" {
+		respondInvalid(c, "refresh_token", "refresh_token requerido")
+		return
+	}
+
+	var reused bool
+	var access, refresh string
+	var accessExp, refreshExp time.Time
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		var tokenID, userID int64
+		var family string
+		var expiresAt time.Time
+		var revokedAt sql.NullTime
+		err := tx.QueryRow(`SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash=? FOR UPDATE`, hashRefreshToken(req.RefreshToken)).
+			Scan(&tokenID, &userID, &family, &expiresAt, &revokedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": errInvalidRefresh.Error()})
+			return errResponded
+		}
+		if err != nil {
+			return err
+		}
+		if revokedAt.Valid {
+			// Reuso de un token ya rotado: se corta la sesión entera (y se confirma)
+			reused = true
+			return revokeFamily(tx, family)
+		}
+		if !expiresAt.After(time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": errInvalidRefresh.Error()})
+			return errResponded
+		}
+		u, err := userByID(c.Request.Context(), userID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !u.IsActive) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": errInvalidRefresh.Error()})
+			return errResponded
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE id=?`, tokenID); err != nil {
+			return err
+		}
+		if refresh, refreshExp, err = issueRefreshToken(tx, u.ID, family); err != nil {
+			return err
+		}
+		access, accessExp, err = issueToken(u)
+		return err
+	})
+	if errors.Is(err, errResponded) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if reused {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reutilizado, sesión revocada"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":                    access,
+		"token_type":               "Bearer",
+		"expires_at":               accessExp,
+		"refresh_token":            refresh,
+		"refresh_token_expires_at": refreshExp,
+	})
+}
+
+// POST /api/v1/logout: revoca la sesión del refresh token. Responde 200 aunque
+// el token no exista para no revelar cuáles son válidos.
+func logoutHandler(c *gin.Context) {
+	var req RefreshReq
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "json inválido"})
+		return
+	}
+	if req.RefreshToken == "" {
+		respondInvalid(c, "refresh_token", "refresh_token requerido")
+		return
+	}
+	var family string
+	err := db.QueryRowContext(c.Request.Context(), `SELECT family_id FROM refresh_tokens WHERE token_hash=?`, hashRefreshToken(req.RefreshToken)).Scan(&family)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err == nil {
+		if err := revokeFamily(ctxDB{c.Request.Context()}, family); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
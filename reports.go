@@ -0,0 +1,288 @@
+package main
+
+// Reportes de ventas (solo admin). Cuentan únicamente pedidos entregados dentro
+// de ?from=&to= (por delivered_at; RFC3339 o YYYY-MM-DD, igual que en pedidos).
+// Los litros se calculan con products.capacity_liters; los productos sin
+// capacidad no suman litros.
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SalesReport struct {
+	DeliveredOrders int     `json:"delivered_orders"`
+	Revenue         float64 `json:"revenue"`
+	TotalLiters     float64 `json:"total_liters"`
+}
+
+type TopProduct struct {
+	ProductID   int64   `json:"product_id"`
+	Name        string  `json:"name"`
+	Qty         int     `json:"qty"`
+	Revenue     float64 `json:"revenue"`
+	TotalLiters float64 `json:"total_liters"`
+}
+
+// deliveredWindow arma el WHERE de pedidos entregados en la sucursal y el rango pedido.
+func deliveredWindow(c *gin.Context) (string, []any, bool) {
+	return orderWindow(c, "o.status='"+statusEntregado+"'", "o.delivered_at")
+}
+
+// orderWindow arma el WHERE de los pedidos que cumplen cond en la sucursal, con
+// ?from=&to= aplicado sobre la columna de fecha col.
+func orderWindow(c *gin.Context, cond, col string) (string, []any, bool) {
+	from, to, ok := dateWindowQuery(c, "from", "to")
+	if !ok {
+		return "", nil, false
+	}
+	branchID, ok := branchFromRequest(c)
+	if !ok {
+		return "", nil, false
+	}
+	where := []string{cond, "o.branch_id=?"}
+	args := []any{branchID}
+	if from != nil {
+		where = append(where, col+">=?")
+		args = append(args, *from)
+	}
+	if to != nil {
+		where = append(where, col+"<?")
+		args = append(args, *to)
+	}
+	return " WHERE " + strings.Join(where, " AND "), args, true
+}
+
+// GET /api/v1/reports/sales
+func salesReportHandler(c *gin.Context) {
+	where, args, ok := deliveredWindow(c)
+	if !ok {
+		return
+	}
+	var rep SalesReport
+	if err := db.QueryRowContext(c.Request.Context(), `SELECT COUNT(*), COALESCE(SUM(o.total), 0) FROM orders o`+where, args...).
+		Scan(&rep.DeliveredOrders, &rep.Revenue); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Aparte para no duplicar el total del pedido por cada ítem del JOIN
+	if err := db.QueryRowContext(c.Request.Context(), `
+        SELECT COALESCE(SUM(oi.qty * p.capacity_liters), 0)
+        FROM orders o
+        JOIN order_items oi ON oi.order_id=o.id
+        JOIN products p ON p.id=oi.product_id`+where+` AND p.capacity_liters IS NOT NULL`, args...).
+		Scan(&rep.TotalLiters); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rep)
+}
+
+// GET /api/v1/reports/top-products (opcional ?limit=, por defecto 10)
+func topProductsReportHandler(c *gin.Context) {
+	limit := 10
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit debe estar entre 1 y " + strconv.Itoa(maxPageSize)})
+			return
+		}
+		limit = n
+	}
+	where, args, ok := deliveredWindow(c)
+	if !ok {
+		return
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT p.id, p.name, SUM(oi.qty), SUM(oi.qty * oi.unit_price), COALESCE(SUM(oi.qty * p.capacity_liters), 0)
+        FROM orders o
+        JOIN order_items oi ON oi.order_id=o.id
+        JOIN products p ON p.id=oi.product_id`+where+`
+        GROUP BY p.id, p.name
+        ORDER BY SUM(oi.qty) DESC, p.id
+        LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	out := []TopProduct{}
+	for rows.Next() {
+		var tp TopProduct
+		if err := rows.Scan(&tp.ProductID, &tp.Name, &tp.Qty, &tp.Revenue, &tp.TotalLiters); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out = append(out, tp)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+type PaymentMethodTotal struct {
+	PaymentMethod string  `json:"payment_method"` // "unknown" para pedidos sin medio de pago
+	Orders        int     `json:"orders"`
+	Total         float64 `json:"total"`
+}
+
+type PaymentsReport struct {
+	Paid         []PaymentMethodTotal `json:"paid"`
+	PaidTotal    float64              `json:"paid_total"`
+	Pending      []PaymentMethodTotal `json:"pending"`
+	PendingTotal float64              `json:"pending_total"`
+}
+
+// GET /api/v1/reports/payments
+// paid: pedidos entregados en la ventana (por delivered_at), que es cuando se
+// cobran. pending: pedidos abiertos (ni entregados ni cancelados) creados en la
+// ventana, por cobrar. Los cancelados no cuentan en ninguno.
+func paymentsReportHandler(c *gin.Context) {
+	where, args, ok := deliveredWindow(c)
+	if !ok {
+		return
+	}
+	var rep PaymentsReport
+	var err error
+	if rep.Paid, rep.PaidTotal, err = paymentTotals(c.Request.Context(), where, args); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	pendingWhere, pendingArgs, _ := orderWindow(c, "o.status NOT IN ("+closedStatusesSQL+")", "o.created_at")
+	if rep.Pending, rep.PendingTotal, err = paymentTotals(c.Request.Context(), pendingWhere, pendingArgs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rep)
+}
+
+// paymentTotals agrupa por medio de pago los pedidos que cumplen where.
+func paymentTotals(ctx context.Context, where string, args []any) ([]PaymentMethodTotal, float64, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT COALESCE(o.payment_method, 'unknown') AS method, COUNT(*), COALESCE(SUM(o.total), 0)
+        FROM orders o`+where+`
+        GROUP BY method
+        ORDER BY method`, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	out := []PaymentMethodTotal{}
+	var sum float64
+	for rows.Next() {
+		var t PaymentMethodTotal
+		if err := rows.Scan(&t.PaymentMethod, &t.Orders, &t.Total); err != nil {
+			return nil, 0, err
+		}
+		sum += t.Total
+		out = append(out, t)
+	}
+	return out, sum, rows.Err()
+}
+
+type SourceTotal struct {
+	Source  string  `json:"source"`
+	Orders  int     `json:"orders"`
+	Revenue float64 `json:"revenue"`
+}
+
+// GET /api/v1/reports/sources
+// Pedidos entregados en la ventana agrupados por canal de origen (web, app, phone).
+func sourcesReportHandler(c *gin.Context) {
+	where, args, ok := deliveredWindow(c)
+	if !ok {
+		return
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT o.source, COUNT(*), COALESCE(SUM(o.total), 0)
+        FROM orders o`+where+`
+        GROUP BY o.source
+        ORDER BY COUNT(*) DESC, o.source`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	out := []SourceTotal{}
+	for rows.Next() {
+		var t SourceTotal
+		if err := rows.Scan(&t.Source, &t.Orders, &t.Revenue); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+type ForecastProduct struct {
+	ProductID       int64   `json:"product_id"`
+	Name            string  `json:"name"`
+	ProjectedQty    int     `json:"projected_qty"`
+	ProjectedLiters float64 `json:"projected_liters"`
+	Orders          int     `json:"orders"`
+}
+
+const maxForecastDays = 90
+
+// GET /api/v1/reports/forecast?days=7 (por defecto 7, máx. 90)
+// Volumen comprometido por producto en los próximos días: pedidos abiertos
+// programados dentro de la ventana (scheduled_at o inicio de la franja).
+// Aún no existen suscripciones, así que no hay pedidos recurrentes que proyectar.
+func forecastReportHandler(c *gin.Context) {
+	days := 7
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxForecastDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days debe estar entre 1 y " + strconv.Itoa(maxForecastDays)})
+			return
+		}
+		days = n
+	}
+	branchID, ok := branchFromRequest(c)
+	if !ok {
+		return
+	}
+	from := time.Now()
+	to := from.AddDate(0, 0, days)
+	rows, err := db.QueryContext(c.Request.Context(), `
+        SELECT p.id, p.name, SUM(oi.qty), COALESCE(SUM(oi.qty * p.capacity_liters), 0), COUNT(DISTINCT o.id)
+        FROM orders o
+        JOIN order_items oi ON oi.order_id=o.id
+        JOIN products p ON p.id=oi.product_id
+        WHERE o.branch_id=? AND o.status IN (`+openStatusesSQL+`)
+          AND COALESCE(o.scheduled_at, o.delivery_window_start) >= ?
+          AND COALESCE(o.scheduled_at, o.delivery_window_start) < ?
+        GROUP BY p.id, p.name
+        ORDER BY SUM(oi.qty) DESC, p.id`, branchID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	products := []ForecastProduct{}
+	for rows.Next() {
+		var fp ForecastProduct
+		if err := rows.Scan(&fp.ProductID, &fp.Name, &fp.ProjectedQty, &fp.ProjectedLiters, &fp.Orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		products = append(products, fp)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "days": days, "products": products})
+}
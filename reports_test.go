@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSalesReportLiters(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`SELECT COUNT(*), COALESCE(SUM(o.total), 0) FROM orders o WHERE o.status='entregado' AND o.branch_id=?`)).
+		WithArgs(defaultBranchID).WillReturnRows(sqlmock.NewRows([]string{"n", "revenue"}).AddRow(4, 120.5))
+	// Los productos sin capacidad no suman litros
+	mock.ExpectQuery(sqlText(`AND p.capacity_liters IS NOT NULL`)).WithArgs(defaultBranchID).
+		WillReturnRows(sqlmock.NewRows([]string{"liters"}).AddRow(80.0))
+
+	w := serve(http.MethodGet, "/api/v1/reports/sales", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["delivered_orders"] != float64(4) || body["revenue"] != 120.5 || body["total_liters"] != float64(80) {
+		t.Errorf("reporte = %v", body)
+	}
+}
+
+func TestTopProductsReportRowError(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`GROUP BY p.id, p.name`)).WithArgs(defaultBranchID, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "qty", "revenue", "liters"}).
+			AddRow(7, "Bidón 20L", 4, 40.0, 80.0).AddRow(8, "Botella 1L", 2, 4.0, 2.0).
+			RowError(1, sql.ErrConnDone))
+
+	w := serve(http.MethodGet, "/api/v1/reports/top-products", "", h)
+	expectStatus(t, w, http.StatusInternalServerError)
+}
+
+func TestForecastReport(t *testing.T) {
+	t.Run("ventana por defecto", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`COALESCE(o.scheduled_at, o.delivery_window_start) >= ?`)).
+			WithArgs(defaultBranchID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "qty", "liters", "orders"}).
+				AddRow(10, "Bidón 20L", 6, 120.0, 3))
+
+		w := serve(http.MethodGet, "/api/v1/reports/forecast", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		products, _ := body["products"].([]any)
+		if body["days"] != float64(7) || len(products) != 1 {
+			t.Fatalf("pronóstico = %v", body)
+		}
+		if p := products[0].(map[string]any); p["projected_qty"] != float64(6) || p["projected_liters"] != float64(120) || p["orders"] != float64(3) {
+			t.Errorf("producto = %v", p)
+		}
+	})
+	t.Run("sin pedidos devuelve lista vacía", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`FROM orders o`)).WithArgs(defaultBranchID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "qty", "liters", "orders"}))
+
+		w := serve(http.MethodGet, "/api/v1/reports/forecast?days=30", "", h)
+		expectStatus(t, w, http.StatusOK)
+		if products, ok := decode(t, w)["products"].([]any); !ok || len(products) != 0 {
+			t.Errorf("products = %v, quiero []", products)
+		}
+	})
+	for _, days := range []string{"0", "91", "x"} {
+		t.Run("days="+days, func(t *testing.T) {
+			mock := newMock(t)
+			w := serve(http.MethodGet, "/api/v1/reports/forecast?days="+days, "", authAs(t, mock, testAdmin))
+			expectStatus(t, w, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestPaymentsReport(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	methodColumns := []string{"method", "orders", "total"}
+	mock.ExpectQuery(sqlText(`WHERE o.status='entregado' AND o.branch_id=?`)).WithArgs(defaultBranchID).
+		WillReturnRows(sqlmock.NewRows(methodColumns).AddRow("cash", 3, 45.0).AddRow("unknown", 1, 10.5))
+	// Pendientes: ni entregados ni cancelados
+	mock.ExpectQuery(sqlText(`WHERE o.status NOT IN (` + closedStatusesSQL + `) AND o.branch_id=?`)).WithArgs(defaultBranchID).
+		WillReturnRows(sqlmock.NewRows(methodColumns).AddRow("transfer", 2, 30.0))
+
+	w := serve(http.MethodGet, "/api/v1/reports/payments", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	paid, _ := body["paid"].([]any)
+	pending, _ := body["pending"].([]any)
+	if len(paid) != 2 || body["paid_total"] != 55.5 || len(pending) != 1 || body["pending_total"] != float64(30) {
+		t.Fatalf("reporte = %v", body)
+	}
+	if m := paid[1].(map[string]any); m["payment_method"] != "unknown" || m["orders"] != float64(1) {
+		t.Errorf("pedidos sin medio de pago = %v", m)
+	}
+}
+
+func TestSourcesReport(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`GROUP BY o.source`)).WithArgs(defaultBranchID).
+		WillReturnRows(sqlmock.NewRows([]string{"source", "orders", "revenue"}).AddRow("app", 5, 80.0).AddRow("phone", 2, 30.5))
+
+	w := serve(http.MethodGet, "/api/v1/reports/sources", "", h)
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `{"source":"app","orders":5,"revenue":80}`) {
+		t.Errorf("cuerpo = %s", w.Body.String())
+	}
+
+	// Un error a mitad del recorrido no se devuelve como un reporte incompleto
+	h = authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`GROUP BY o.source`)).WithArgs(defaultBranchID).
+		WillReturnRows(sqlmock.NewRows([]string{"source", "orders", "revenue"}).AddRow("app", 5, 80.0).RowError(0, sql.ErrConnDone))
+	w = serve(http.MethodGet, "/api/v1/reports/sources", "", h)
+	expectStatus(t, w, http.StatusInternalServerError)
+}
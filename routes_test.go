@@ -0,0 +1,186 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	newMock(t)
+	setVar(t, &version, "1.4.0")
+	setVar(t, &commit, "abc1234")
+	w := serve(http.MethodGet, "/version", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["version"] != "1.4.0" || body["commit"] != "abc1234" {
+		t.Errorf("cuerpo = %v", body)
+	}
+	if _, ok := body["build_time"]; !ok {
+		t.Error("falta build_time")
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	newMock(t)
+	w := serve(http.MethodDelete, "/api/v1/statuses", "", nil)
+	expectStatus(t, w, http.StatusMethodNotAllowed)
+	if allow := w.Header().Get("Allow"); !strings.Contains(allow, http.MethodGet) {
+		t.Errorf("Allow = %q", allow)
+	}
+	if _, ok := decode(t, w)["allow"]; !ok {
+		t.Error("falta allow en el cuerpo")
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	newMock(t)
+	w := serve(http.MethodGet, "/api/v1/no-existe", "", nil)
+	expectStatus(t, w, http.StatusNotFound)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	errBody, _ := decode(t, w)["error"].(map[string]any)
+	if errBody["code"] != "NOT_FOUND" || errBody["message"] == "" {
+		t.Errorf("error = %v", errBody)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	r := gin.New()
+	r.Use(bodyLimit(16))
+	r.POST("/echo", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	w := serveWith(r, http.MethodPost, "/echo", `{"a":"corto"}`, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.String() != `{"a":"corto"}` {
+		t.Errorf("el handler recibió %q", w.Body.String())
+	}
+
+	w = serveWith(r, http.MethodPost, "/echo", `{"a":"demasiado largo"}`, nil)
+	expectStatus(t, w, http.StatusRequestEntityTooLarge)
+
+	// Sin Content-Length (chunked) lo corta MaxBytesReader
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(strings.Repeat("x", 32)))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusRequestEntityTooLarge)
+}
+
+func TestBodyLimitOnRouter(t *testing.T) {
+	newMock(t)
+	setVar(t, &maxBodyBytes, 32)
+	w := serve(http.MethodPost, "/api/v1/users", `{"full_name":"`+strings.Repeat("a", 64)+`"}`, nil)
+	expectStatus(t, w, http.StatusRequestEntityTooLarge)
+}
+
+func TestMalformedIntegerQueryParams(t *testing.T) {
+	cases := []struct {
+		path, want string
+	}{
+		{"/api/v1/users?role_id=abc", "role_id debe ser numérico"},
+		{"/api/v1/users?page=0", "page debe ser un entero >= 1"},
+		{"/api/v1/users?page_size=x", "page_size debe estar entre 1 y"},
+		{"/api/v1/orders?customer_id=1.5", "customer_id debe ser numérico"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			mock := newMock(t)
+			var h http.Header
+			if strings.HasPrefix(tc.path, "/api/v1/users") {
+				h = authAs(t, mock, testAdmin)
+			}
+			w := serve(http.MethodGet, tc.path, "", h)
+			expectStatus(t, w, http.StatusBadRequest)
+			if msg, _ := decode(t, w)["error"].(string); !strings.HasPrefix(msg, tc.want) {
+				t.Errorf("error = %q", msg)
+			}
+		})
+	}
+}
+
+func TestDBStats(t *testing.T) {
+	mock := newMock(t)
+	db.SetMaxOpenConns(7)
+	w := serve(http.MethodGet, "/debug/db", "", authAs(t, mock, testAdmin))
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["max_open_connections"] != float64(7) {
+		t.Errorf("max_open_connections = %v", body["max_open_connections"])
+	}
+	for _, key := range []string{"open_connections", "in_use", "idle", "wait_count", "wait_duration_ms"} {
+		if _, ok := body[key]; !ok {
+			t.Errorf("falta %s", key)
+		}
+	}
+}
+
+func TestDBStatsRequiresAdmin(t *testing.T) {
+	newMock(t)
+	w := serve(http.MethodGet, "/debug/db", "", nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestGzipResponses(t *testing.T) {
+	r := gin.New()
+	r.Use(gzipResponses(64))
+	r.GET("/big", func(c *gin.Context) { c.String(http.StatusOK, strings.Repeat("agua ", 100)) })
+	r.GET("/small", func(c *gin.Context) { c.String(http.StatusOK, "agua") })
+	gz := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+
+	w := serveWith(r, http.MethodGet, "/big", "", gz)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", w.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if string(body) != strings.Repeat("agua ", 100) {
+		t.Errorf("cuerpo descomprimido = %q", body)
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q", w.Header().Get("Vary"))
+	}
+
+	// Por debajo del mínimo se envía tal cual
+	w = serveWith(r, http.MethodGet, "/small", "", gz)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "agua" {
+		t.Errorf("respuesta pequeña comprimida: %q %q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+
+	// Sin Accept-Encoding no se comprime
+	w = serveWith(r, http.MethodGet, "/big", "", nil)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Content-Encoding = %q sin Accept-Encoding", w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestSeedOnlyInDevelopment(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	newMock(t)
+	w := serve(http.MethodPost, "/api/v1/dev/seed", "", nil)
+	expectStatus(t, w, http.StatusNotFound)
+
+	t.Setenv("APP_ENV", "development")
+	mock := newMock(t)
+	mock.ExpectQuery(sqlText(`SELECT id FROM users WHERE email=?`)).WithArgs(demoAdminEmail).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	w = serve(http.MethodPost, "/api/v1/dev/seed", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode(t, w)["seeded"]; got != false {
+		t.Errorf("seeded = %v con datos ya cargados", got)
+	}
+}
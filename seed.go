@@ -0,0 +1,175 @@
+package main
+
+// Datos de demo para desarrollo: POST /api/v1/dev/seed.
+// Solo existe con APP_ENV=development (en otros entornos responde 404 como una
+// ruta inexistente). Si ya hay datos de demo no vuelve a insertarlos.
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+const demoPassword = "demo1234"
+
+// demoAdminEmail marca si el seed ya se ejecutó
+const demoAdminEmail = "admin@demo.local"
+
+func devMode() bool {
+	return os.Getenv("APP_ENV") == "development"
+}
+
+// requireDevMode oculta la ruta fuera de desarrollo.
+func requireDevMode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !devMode() {
+			notFoundHandler(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+type demoUser struct {
+	role  int8
+	name  string
+	email string
+	phone string
+}
+
+type demoProduct struct {
+	name     string
+	capacity float64
+	price    float64
+	stock    int
+}
+
+func seedHandler(c *gin.Context) {
+	var existing int64
+	err := db.QueryRow(`SELECT id FROM users WHERE email=?`, demoAdminEmail).Scan(&existing)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{"seeded": false, "message": "datos de demo ya cargados"})
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := hashPassword(demoPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	users := []demoUser{
+		{roleAdmin, "Admin Demo", demoAdminEmail, "900000001"},
+		{roleDriver, "Repartidor Demo", "repartidor@demo.local", "900000002"},
+		{roleCustomer, "Cliente Uno", "cliente1@demo.local", "900000003"},
+		{roleCustomer, "Cliente Dos", "cliente2@demo.local", "900000004"},
+	}
+	userIDs := make([]int64, len(users))
+	for i, u := range users {
+		res, err := tx.Exec(`INSERT INTO users(role_id, full_name, phone, email, password_hash, is_active, branch_id) VALUES (?,?,?,?,?,TRUE,?)`,
+			u.role, u.name, u.phone, u.email, hash, defaultBranchID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		userIDs[i], _ = res.LastInsertId()
+	}
+	driverID, customers := userIDs[1], userIDs[2:]
+
+	addressIDs := make([]int64, 0, len(customers))
+	for i, cust := range customers {
+		lat, lng := -12.0464+float64(i)*0.01, -77.0428+float64(i)*0.01
+		res, err := tx.Exec(`INSERT INTO addresses(user_id, label, street, lat, lng, is_default) VALUES (?,?,?,?,?,TRUE)`,
+			cust, "Casa", "Calle Demo "+string(rune('A'+i)), lat, lng)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		id, _ := res.LastInsertId()
+		addressIDs = append(addressIDs, id)
+	}
+
+	products := []demoProduct{
+		{"Bidón 20L", 20, 12.50, 100},
+		{"Bidón 7L", 7, 6.00, 100},
+		{"Botella 625ml (pack 15)", 9.4, 18.90, 50},
+	}
+	productIDs := make([]int64, len(products))
+	for i, p := range products {
+		res, err := tx.Exec(`INSERT INTO products(name, capacity_liters, price, currency, is_active, stock, branch_id) VALUES (?,?,?,?,TRUE,?,?)`,
+			p.name, p.capacity, p.price, baseCurrency, p.stock, defaultBranchID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		productIDs[i], _ = res.LastInsertId()
+	}
+
+	// Un pedido pendiente y uno asignado al repartidor
+	orders := []struct {
+		customer, address int64
+		status            string
+		driver            *int64
+		items             []pricedItem
+	}{
+		{customers[0], addressIDs[0], "por_atender", nil, []pricedItem{{ProductID: productIDs[0], Qty: 2, UnitPrice: products[0].price, PriceSource: priceSourceBase}}},
+		{customers[1], addressIDs[1], "asignado", &driverID, []pricedItem{
+			{ProductID: productIDs[1], Qty: 1, UnitPrice: products[1].price, PriceSource: priceSourceBase},
+			{ProductID: productIDs[2], Qty: 1, UnitPrice: products[2].price, PriceSource: priceSourceBase},
+		}},
+	}
+	orderIDs := make([]int64, 0, len(orders))
+	for _, o := range orders {
+		subtotal := 0.0
+		for _, it := range o.items {
+			subtotal += it.UnitPrice * float64(it.Qty)
+		}
+		res, err := tx.Exec(`INSERT INTO orders(customer_id, address_id, branch_id, created_by, assigned_driver_id, status, subtotal, delivery_fee, total) VALUES (?,?,?,?,?,?,?,0,?)`,
+			o.customer, o.address, defaultBranchID, o.customer, o.driver, o.status, subtotal, subtotal)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		orderID, _ := res.LastInsertId()
+		if err := insertOrderItems(tx, orderID, o.items); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := reserveStock(tx, o.items); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, orderID, nil, o.status, o.customer, "Pedido de demo"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		orderIDs = append(orderIDs, orderID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"seeded":      true,
+		"password":    demoPassword,
+		"user_ids":    userIDs,
+		"address_ids": addressIDs,
+		"product_ids": productIDs,
+		"order_ids":   orderIDs,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+// Sesiones de login: cada sesión es una familia de refresh tokens (family_id) con
+// al menos un token vigente. MAX_SESSIONS_PER_USER (por defecto 5, 0 = sin límite)
+// acota cuántas puede tener un usuario; al pasarse, un login nuevo revoca las más
+// antiguas. El usuario ve y cierra las suyas en /api/v1/me/sessions.
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var maxSessionsPerUser = 5
+
+func loadSessionConfig() {
+	maxSessionsPerUser = envInt("MAX_SESSIONS_PER_USER", maxSessionsPerUser)
+}
+
+type Session struct {
+	ID         string    `json:"id"` // family_id
+	StartedAt  time.Time `json:"started_at"`
+	LastUsedAt time.Time `json:"last_used_at"` // último login o refresh
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// startSession abre una sesión nueva (refresh token de una familia nueva) y
+// revoca las más antiguas que excedan el límite. La fila del usuario se bloquea
+// para que dos logins simultáneos no dejen más sesiones que el límite.
+func startSession(ctx context.Context, userID int64) (raw string, exp time.Time, err error) {
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		var locked int64
+		if err := tx.QueryRow(`SELECT id FROM users WHERE id=? FOR UPDATE`, userID).Scan(&locked); err != nil {
+			return err
+		}
+		var err error
+		if raw, exp, err = issueRefreshToken(tx, userID, ""); err != nil {
+			return err
+		}
+		if maxSessionsPerUser > 0 {
+			sessions, err := activeSessions(tx, userID)
+			if err != nil {
+				return err
+			}
+			// activeSessions viene de la más nueva a la más antigua
+			for i := maxSessionsPerUser; i < len(sessions); i++ {
+				if err := revokeFamily(tx, sessions[i].ID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return raw, exp, err
+}
+
+// rowsQuerier lo cumplen *sql.DB y *sql.Tx.
+type rowsQuerier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// activeSessions lista las sesiones vigentes del usuario, de la más nueva a la
+// más antigua. started_at es el primer token de la familia (antes de rotar).
+func activeSessions(q rowsQuerier, userID int64) ([]Session, error) {
+	rows, err := q.Query(`
+        SELECT rt.family_id, MIN(rt.created_at), MAX(rt.created_at), MAX(rt.expires_at)
+        FROM refresh_tokens rt
+        WHERE rt.user_id=? AND rt.family_id IN (
+            SELECT family_id FROM refresh_tokens
+            WHERE user_id=? AND revoked_at IS NULL AND expires_at > NOW())
+        GROUP BY rt.family_id
+        ORDER BY MIN(rt.id) DESC`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.StartedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// GET /api/v1/me/sessions
+func listSessionsHandler(c *gin.Context) {
+	u, _ := currentUser(c)
+	sessions, err := activeSessions(ctxDB{c.Request.Context()}, u.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "max_sessions": maxSessionsPerUser})
+}
+
+// DELETE /api/v1/me/sessions/:id: cierra una sesión propia (404 si no es del
+// usuario o ya no está vigente).
+func revokeSessionHandler(c *gin.Context) {
+	u, _ := currentUser(c)
+	res, err := db.ExecContext(c.Request.Context(), `UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=? AND user_id=? AND revoked_at IS NULL AND expires_at > NOW()`, c.Param("id"), u.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sesión no encontrada"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoginRevokesOldestSessions(t *testing.T) {
+	setVar(t, &maxSessionsPerUser, 2)
+	stored, err := hashPassword("agua2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := newMock(t)
+	expectLoginLookup(mock, "cliente@example.com", testCustomer, stored)
+	// Con la nueva (f4) hay cuatro: quedan las dos más nuevas
+	expectStartSession(mock, testCustomer.ID, "f4", "f3", "f2", "f1")
+	for _, family := range []string{"f2", "f1"} {
+		mock.ExpectExec(sqlText(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=? AND revoked_at IS NULL`)).WithArgs(family).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/api/v1/login", `{"username":"cliente@example.com","password":"agua2024"}`, nil)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestLoginWithoutSessionLimit(t *testing.T) {
+	setVar(t, &maxSessionsPerUser, 0)
+	stored, err := hashPassword("agua2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := newMock(t)
+	expectLoginLookup(mock, "cliente@example.com", testCustomer, stored)
+	// Sin límite no se listan ni revocan sesiones
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlText(`SELECT id FROM users WHERE id=? FOR UPDATE`)).WithArgs(testCustomer.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testCustomer.ID))
+	mock.ExpectExec(sqlText(`INSERT INTO refresh_tokens(user_id, token_hash, family_id, expires_at)`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/api/v1/login", `{"username":"cliente@example.com","password":"agua2024"}`, nil)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestListSessions(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testCustomer)
+	mock.ExpectQuery(sqlText(`FROM refresh_tokens rt`)).WithArgs(testCustomer.ID, testCustomer.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"family_id", "started_at", "last_used_at", "expires_at"}).
+			AddRow("f2", testNow, testNow, testNow).
+			AddRow("f1", testNow, testNow, testNow))
+
+	w := serve(http.MethodGet, "/api/v1/me/sessions", "", h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	sessions, _ := body["sessions"].([]any)
+	if len(sessions) != 2 || sessions[0].(map[string]any)["id"] != "f2" || body["max_sessions"] != float64(maxSessionsPerUser) {
+		t.Errorf("sesiones = %v", body)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	const revoke = `UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=? AND user_id=?`
+	t.Run("propia", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		mock.ExpectExec(sqlText(revoke)).WithArgs("f1", testCustomer.ID).WillReturnResult(sqlmock.NewResult(0, 2))
+		w := serve(http.MethodDelete, "/api/v1/me/sessions/f1", "", h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	t.Run("ajena o cerrada", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		mock.ExpectExec(sqlText(revoke)).WithArgs("f9", testCustomer.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+		w := serve(http.MethodDelete, "/api/v1/me/sessions/f9", "", h)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+	t.Run("requiere autenticación", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodDelete, "/api/v1/me/sessions/f1", "", nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+// Vencimiento de pedidos programados que nunca se atendieron.
+// Un pedido por_atender cuya hora programada (scheduled_at, o el fin de la franja)
+// pasó hace más de STALE_ORDER_GRACE_MINUTES se cancela con una nota en el
+// historial y devuelve su stock, igual que una cancelación manual.
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var staleOrderGrace = 2 * time.Hour
+
+func loadStaleOrderConfig() {
+	staleOrderGrace = time.Duration(envInt("STALE_ORDER_GRACE_MINUTES", int(staleOrderGrace/time.Minute))) * time.Minute
+}
+
+const staleOrderNote = "Vencido: no se atendió a la hora programada"
+
+// POST /api/v1/admin/orders/expire-stale (admin)
+// Idempotente: solo toca pedidos que siguen en por_atender.
+func expireStaleOrdersHandler(c *gin.Context) {
+	u, _ := currentUser(c)
+	cutoff := time.Now().Add(-staleOrderGrace)
+
+	var ids []int64
+	err := withTx(c.Request.Context(), func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+        SELECT id FROM orders
+        WHERE status='`+statusPorAtender+`' AND COALESCE(scheduled_at, delivery_window_end) < ?
+        ORDER BY id
+        FOR UPDATE`, cutoff)
+		if err != nil {
+			return err
+		}
+		ids = nil
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if _, err := tx.Exec(`UPDATE orders SET status=? WHERE id=? AND status=?`, statusCancelado, id, statusPorAtender); err != nil {
+				return err
+			}
+			if err := restoreOrderStock(tx, id); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO order_status_history(order_id, old_status, new_status, changed_by, note) VALUES (?,?,?,?,?)`, id, statusPorAtender, statusCancelado, u.ID, staleOrderNote); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	old := statusPorAtender
+	for _, id := range ids {
+		orderEvents.publish(OrderEvent{Type: orderEventStatus, OrderID: id, OldStatus: &old, Status: statusCancelado})
+	}
+	if ids == nil {
+		ids = []int64{}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "expired": len(ids), "order_ids": ids, "cutoff": cutoff})
+}
@@ -0,0 +1,35 @@
+package main
+
+// Impuesto de los pedidos.
+// TAX_RATE es la tasa (0.18 = 18%, por defecto 0 = sin impuesto) y TAX_DELIVERY
+// indica si el delivery también paga impuesto. Cada pedido guarda la tasa y el
+// flag con que se creó, así las ediciones posteriores recalculan con las mismas
+// reglas aunque cambie la configuración.
+
+import "math"
+
+var (
+	taxRate     = 0.0
+	taxDelivery = false
+)
+
+func loadTaxConfig() {
+	if v := envFloatPtr("TAX_RATE"); v != nil && *v >= 0 {
+		taxRate = *v
+	}
+	taxDelivery = envBool("TAX_DELIVERY", taxDelivery)
+}
+
+// computeTax aplica la tasa a los ítems (y al delivery si es gravable) con la
+// misma regla que taxSQL: montos en céntimos, tasa con los 4 decimales de
+// orders.tax_rate y redondeo al céntimo hacia arriba desde la mitad, como
+// ROUND(x, 2) sobre DECIMAL en MySQL. En float64 0.225 no es exacto y se
+// redondearía distinto.
+func computeTax(subtotal, deliveryFee, rate float64, deliveryTaxable bool) float64 {
+	base := toCents(subtotal)
+	if deliveryTaxable {
+		base += toCents(deliveryFee)
+	}
+	rateUnits := int64(math.Round(rate * 10000))
+	return float64((base*rateUnits+5000)/10000) / 100
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestComputeTax(t *testing.T) {
+	cases := []struct {
+		name            string
+		subtotal, fee   float64
+		rate            float64
+		deliveryTaxable bool
+		want            float64
+	}{
+		{"sin impuesto", 100, 5, 0, true, 0},
+		{"solo ítems", 100, 5, 0.18, false, 18},
+		{"con delivery gravable", 100, 5, 0.18, true, 18.9},
+		// 1.25 * 0.18 = 0.225: medio céntimo, hacia arriba como ROUND de MySQL
+		{"medio céntimo", 1.25, 0, 0.18, false, 0.23},
+		{"tasa con 4 decimales", 10, 0, 0.1234, false, 1.23},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := computeTax(tc.subtotal, tc.fee, tc.rate, tc.deliveryTaxable); got != tc.want {
+				t.Errorf("computeTax = %v, quiero %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuoteOrderTax(t *testing.T) {
+	setVar(t, &taxRate, 0.18)
+	setVar(t, &taxDelivery, false)
+	line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectBranchActive(mock, defaultBranchID)
+	expectPrepareOrder(mock, line)
+
+	w := serve(http.MethodPost, "/api/v1/orders/quote", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`, h)
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["tax"] != 3.6 || body["total"] != 23.6 {
+		t.Errorf("tax = %v, total = %v", body["tax"], body["total"])
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+// Tiempo máximo por petición.
+// REQUEST_TIMEOUT (duración de Go como "15s" o segundos enteros; por defecto 30s,
+// 0 = sin límite) acota la latencia total de un handler aunque cada consulta sea
+// rápida. Al vencer se responde 503 y se cancela el contexto de la petición: las
+// transacciones de withTx se abortan en la próxima consulta. Las rutas de
+// streaming (timeoutExemptRoutes) no tienen límite.
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var requestTimeout = 30 * time.Second
+
+func loadTimeoutConfig() {
+	v := os.Getenv("REQUEST_TIMEOUT")
+	if v == "" {
+		return
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		requestTimeout = time.Duration(secs) * time.Second
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatal("REQUEST_TIMEOUT inválido:", err)
+	}
+	requestTimeout = d
+}
+
+// Rutas (c.FullPath()) que hacen streaming y no pueden cortarse con un 503
+var timeoutExemptRoutes = map[string]bool{
+	"/api/v1/orders/stream": true,
+}
+
+// timeoutWriter acumula cabeceras, estado y cuerpo del handler. Solo se envían si
+// el handler termina a tiempo; después del 503 todo lo que escriba se descarta.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.timedOut && w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status != 0
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		return -1
+	}
+	return w.buf.Len()
+}
+
+// Flush no hace nada: con límite de tiempo la respuesta se envía entera al final.
+func (w *timeoutWriter) Flush() {}
+
+func requestTimeoutMiddleware(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 || timeoutExemptRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		orig := c.Writer
+		tw := &timeoutWriter{ResponseWriter: orig, header: http.Header{}}
+		c.Writer = tw
+		done := make(chan any, 1)
+		go func() {
+			defer func() { done <- recover() }()
+			c.Next()
+		}()
+
+		select {
+		case p := <-done:
+			c.Writer = orig
+			if p != nil {
+				// Se relanza aquí para que la recupere gin.Recovery
+				panic(p)
+			}
+			for k, v := range tw.header {
+				orig.Header()[k] = v
+			}
+			if tw.status != 0 {
+				orig.WriteHeader(tw.status)
+				orig.Write(tw.buf.Bytes())
+			}
+		case <-ctx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+			orig.Header().Set("Content-Type", "application/json; charset=utf-8")
+			orig.WriteHeader(http.StatusServiceUnavailable)
+			orig.Write([]byte(`{"error":"solicitud expiró"}`))
+			orig.Flush()
+			// El gin.Context vuelve al pool al retornar: hay que esperar al handler
+			// (que ya ve el contexto cancelado) antes de soltarlo
+			<-done
+			c.Writer = orig
+			c.Abort()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestTimeoutMiddleware(t *testing.T) {
+	r := gin.New()
+	r.Use(gin.Recovery(), requestTimeoutMiddleware(20*time.Millisecond))
+	handlerErr := make(chan error, 1)
+	r.GET("/lento", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		handlerErr <- c.Request.Context().Err()
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	r.GET("/rapido", func(c *gin.Context) {
+		c.Header("X-Handler", "si")
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+	r.GET("/panico", func(c *gin.Context) { panic("falla") })
+	r.GET("/api/v1/orders/stream", func(c *gin.Context) {
+		time.Sleep(40 * time.Millisecond)
+		c.String(http.StatusOK, "stream")
+	})
+
+	w := serveWith(r, http.MethodGet, "/lento", "", nil)
+	expectStatus(t, w, http.StatusServiceUnavailable)
+	if !strings.Contains(w.Body.String(), "solicitud expiró") || strings.Contains(w.Body.String(), `"ok"`) {
+		t.Errorf("cuerpo = %s", w.Body.String())
+	}
+	if err := <-handlerErr; err == nil {
+		t.Error("el handler debe ver el contexto cancelado")
+	}
+
+	w = serveWith(r, http.MethodGet, "/rapido", "", nil)
+	expectStatus(t, w, http.StatusCreated)
+	if w.Header().Get("X-Handler") != "si" {
+		t.Error("se perdieron las cabeceras del handler")
+	}
+
+	w = serveWith(r, http.MethodGet, "/panico", "", nil)
+	expectStatus(t, w, http.StatusInternalServerError)
+
+	// El streaming no tiene límite
+	w = serveWith(r, http.MethodGet, "/api/v1/orders/stream", "", nil)
+	expectStatus(t, w, http.StatusOK)
+}
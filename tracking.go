@@ -0,0 +1,68 @@
+package main
+
+// Seguimiento público de pedidos por token (checkout de invitados).
+// Cada pedido recibe al crearse un token opaco aleatorio; con él cualquiera puede
+// ver el estado y la hora estimada, sin datos del cliente.
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trackingTTL es cuánto sigue valiendo el token después de la entrega; 0 = sin vencimiento.
+var trackingTTL = 7 * 24 * time.Hour
+
+func loadTrackingConfig() {
+	trackingTTL = time.Duration(envInt("TRACKING_TTL_DAYS", int(trackingTTL/(24*time.Hour)))) * 24 * time.Hour
+}
+
+func newTrackingToken() (string, error) {
+	return randomHex(32)
+}
+
+// Vista pública del pedido: sin cliente, dirección, montos ni notas
+type TrackingView struct {
+	Status              string     `json:"status"`
+	ItemCount           int        `json:"item_count"`
+	ScheduledAt         *time.Time `json:"scheduled_at,omitempty"`
+	DeliveryWindowStart *time.Time `json:"delivery_window_start,omitempty"`
+	DeliveryWindowEnd   *time.Time `json:"delivery_window_end,omitempty"`
+	TransitStartedAt    *time.Time `json:"transit_started_at,omitempty"`
+	DeliveredAt         *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// GET /api/v1/track/:token (público). Token desconocido o vencido: 404.
+func trackOrderHandler(c *gin.Context) {
+	token := c.Param("token")
+	if len(token) != 64 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pedido no encontrado"})
+		return
+	}
+	var v TrackingView
+	err := db.QueryRowContext(c.Request.Context(), `
+        SELECT o.status, COALESCE(SUM(oi.qty), 0), o.scheduled_at, o.delivery_window_start, o.delivery_window_end,
+               o.transit_started_at, o.delivered_at, o.created_at
+        FROM orders o
+        LEFT JOIN order_items oi ON oi.order_id = o.id
+        WHERE o.tracking_token=?
+        GROUP BY o.id`, token).Scan(&v.Status, &v.ItemCount, &v.ScheduledAt, &v.DeliveryWindowStart, &v.DeliveryWindowEnd,
+		&v.TransitStartedAt, &v.DeliveredAt, &v.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pedido no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if trackingTTL > 0 && v.DeliveredAt != nil && time.Since(*v.DeliveredAt) > trackingTTL {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pedido no encontrado"})
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
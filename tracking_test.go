@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTrackOrder(t *testing.T) {
+	token := strings.Repeat("ab", 32)
+	trackingRow := func(deliveredAt any) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"status", "items", "scheduled_at", "window_start", "window_end", "transit_started_at", "delivered_at", "created_at"}).
+			AddRow(statusEntregado, 3, nil, nil, nil, testNow, deliveredAt, testNow)
+	}
+
+	t.Run("vista pública", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`WHERE o.tracking_token=?`)).WithArgs(token).WillReturnRows(trackingRow(time.Now().Add(-time.Hour)))
+		w := serve(http.MethodGet, "/api/v1/track/"+token, "", nil)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["status"] != statusEntregado || body["item_count"] != float64(3) {
+			t.Errorf("vista = %v", body)
+		}
+		for _, private := range []string{"customer_id", "address_id", "total", "notes"} {
+			if _, ok := body[private]; ok {
+				t.Errorf("la vista pública expone %s", private)
+			}
+		}
+	})
+	t.Run("vencido tras la entrega", func(t *testing.T) {
+		setVar(t, &trackingTTL, 24*time.Hour)
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`WHERE o.tracking_token=?`)).WithArgs(token).WillReturnRows(trackingRow(time.Now().Add(-48 * time.Hour)))
+		w := serve(http.MethodGet, "/api/v1/track/"+token, "", nil)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+	t.Run("token desconocido", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`WHERE o.tracking_token=?`)).WithArgs(token).WillReturnError(sql.ErrNoRows)
+		w := serve(http.MethodGet, "/api/v1/track/"+token, "", nil)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+	t.Run("formato inválido sin consultar", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodGet, "/api/v1/track/corto", "", nil)
+		expectStatus(t, w, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+// Grafo del ciclo de vida del pedido para que los frontends dibujen el diagrama.
+// Las aristas salen de statusTransitions (la misma tabla que valida los cambios de
+// estado) y las etiquetas de la tabla statuses, así el grafo no se desfasa.
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Nombre público de cada rol en el grafo
+var roleNames = map[int8]string{
+	roleAdmin:    "admin",
+	roleDriver:   "driver",
+	roleCustomer: "customer",
+}
+
+type GraphNode struct {
+	Code     string  `json:"code"`
+	Label    string  `json:"label"`
+	Color    *string `json:"color,omitempty"`
+	Terminal bool    `json:"terminal"` // sin transiciones de salida
+}
+
+type GraphEdge struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Roles []string `json:"roles"`
+}
+
+// GET /api/v1/orders/transition-graph (opcional ?lang=, igual que /statuses)
+func transitionGraphHandler(c *gin.Context) {
+	col, ok := statusLabelColumns[c.Query("lang")]
+	if !ok {
+		col = statusLabelColumns[defaultStatusLang]
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT code, COALESCE(` + col + `, label_es), color FROM statuses ORDER BY sort_order, code`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	nodes := []GraphNode{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var n GraphNode
+		if err := rows.Scan(&n.Code, &n.Label, &n.Color); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !knownStatus(n.Code) {
+			continue
+		}
+		n.Terminal = len(statusTransitions[n.Code]) == 0
+		nodes = append(nodes, n)
+		seen[n.Code] = true
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	edges := []GraphEdge{}
+	for from, out := range statusTransitions {
+		for _, e := range out {
+			roles := make([]string, 0, len(e.Roles))
+			for _, r := range e.Roles {
+				roles = append(roles, roleNames[r])
+			}
+			edges = append(edges, GraphEdge{From: from, To: e.To, Roles: roles})
+			// Un estado sin fila en statuses igual aparece, con el código como etiqueta
+			for _, code := range []string{from, e.To} {
+				if !seen[code] {
+					seen[code] = true
+					nodes = append(nodes, GraphNode{Code: code, Label: code, Terminal: len(statusTransitions[code]) == 0})
+				}
+			}
+		}
+	}
+	// El mapa no tiene orden: se ordena por el rango del estado de origen y luego de destino
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return statusOrder(edges[i].From) < statusOrder(edges[j].From)
+		}
+		return statusOrder(edges[i].To) < statusOrder(edges[j].To)
+	})
+	c.JSON(http.StatusOK, gin.H{"nodes": nodes, "edges": edges})
+}
+
+// statusOrder ubica cancelado después de los estados del flujo normal.
+func statusOrder(code string) int {
+	if r, ok := statusRank[code]; ok {
+		return r
+	}
+	return len(statusRank) + 1
+}
@@ -0,0 +1,97 @@
+package main
+
+// Reintento de transacciones ante deadlocks.
+// Con creación y asignación de pedidos concurrentes MySQL puede abortar una
+// transacción con deadlock (1213) o lock wait timeout (1205). withTx repite la
+// transacción completa hasta TX_DEADLOCK_RETRIES veces (por defecto 3) con una
+// espera creciente de TX_RETRY_BACKOFF_MS (por defecto 20) por intento. Cualquier
+// otro error se devuelve sin reintentar.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	txDeadlockRetries = 3
+	txRetryBackoff    = 20 * time.Millisecond
+)
+
+func loadTxConfig() {
+	txDeadlockRetries = envInt("TX_DEADLOCK_RETRIES", txDeadlockRetries)
+	txRetryBackoff = time.Duration(envInt("TX_RETRY_BACKOFF_MS", int(txRetryBackoff/time.Millisecond))) * time.Millisecond
+}
+
+// Códigos de MySQL que se reintentan
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// errResponded lo devuelve la función de withTx cuando ya respondió al cliente
+// (validación, 404, etc.): la transacción se revierte y no se reintenta.
+var errResponded = errors.New("respuesta ya enviada")
+
+// txBeginner lo cumple *sql.DB.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// isRetryableTxError: solo deadlock y lock wait timeout.
+func isRetryableTxError(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && (me.Number == mysqlErrDeadlock || me.Number == mysqlErrLockWaitTimeout)
+}
+
+// withTx ejecuta fn en una transacción y hace commit si devuelve nil. Si fn o el
+// commit fallan por deadlock, revierte y repite todo fn, así que fn no debe
+// responder al cliente antes de terminar ni tener efectos fuera de la transacción.
+// Si ctx se cancela (REQUEST_TIMEOUT) la transacción se revierte y no se reintenta.
+func withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return retryTx(ctx, db, txDeadlockRetries, txRetryBackoff, fn)
+}
+
+func retryTx(ctx context.Context, b txBeginner, retries int, backoff time.Duration, fn func(tx *sql.Tx) error) error {
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, b, fn)
+		if err == nil || attempt >= retries || !isRetryableTxError(err) || ctx.Err() != nil {
+			return err
+		}
+		time.Sleep(backoff * time.Duration(attempt+1))
+	}
+}
+
+func runTx(ctx context.Context, b txBeginner, fn func(tx *sql.Tx) error) error {
+	tx, err := b.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ctxDB adapta db a las interfaces querier, rowsQuerier y execer (que también
+// cumple *sql.Tx) usando el contexto de la petición, para que las consultas
+// fuera de una transacción también se cancelen con REQUEST_TIMEOUT.
+type ctxDB struct {
+	ctx context.Context
+}
+
+func (d ctxDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.QueryRowContext(d.ctx, query, args...)
+}
+
+func (d ctxDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.QueryContext(d.ctx, query, args...)
+}
+
+func (d ctxDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.ExecContext(d.ctx, query, args...)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+var errDeadlock = &mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found when trying to get lock"}
+
+func TestIsRetryableTxError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{errDeadlock, true},
+		{&mysql.MySQLError{Number: mysqlErrLockWaitTimeout}, true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{errors.Join(errors.New("insert"), errDeadlock), true},
+		{sql.ErrNoRows, false},
+		{nil, false},
+	}
+	for _, tc := range cases {
+		if got := isRetryableTxError(tc.err); got != tc.want {
+			t.Errorf("isRetryableTxError(%v) = %v", tc.err, got)
+		}
+	}
+}
+
+func TestRetryTx(t *testing.T) {
+	exec := func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE orders SET status=?`, statusAsignado)
+		return err
+	}
+
+	t.Run("reintenta el deadlock", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=?`)).WillReturnError(errDeadlock)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err := retryTx(context.Background(), db, 3, 0, exec); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("se rinde tras los reintentos", func(t *testing.T) {
+		mock := newMock(t)
+		for range 2 {
+			mock.ExpectBegin()
+			mock.ExpectExec(sqlText(`UPDATE orders SET status=?`)).WillReturnError(errDeadlock)
+			mock.ExpectRollback()
+		}
+		if err := retryTx(context.Background(), db, 1, 0, exec); !isRetryableTxError(err) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+	t.Run("otros errores no se reintentan", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlText(`UPDATE orders SET status=?`)).WillReturnError(&mysql.MySQLError{Number: 1062})
+		mock.ExpectRollback()
+		if err := retryTx(context.Background(), db, 3, 0, exec); err == nil {
+			t.Fatal("quiero error")
+		}
+	})
+	t.Run("errResponded no se reintenta", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		err := retryTx(context.Background(), db, 3, 0, func(*sql.Tx) error { return errResponded })
+		if !errors.Is(err, errResponded) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
+
+func TestCreateOrderRetriesDeadlock(t *testing.T) {
+	setVar(t, &txRetryBackoff, 0)
+	line := orderLine{productID: 7, qty: 2, product: baseProduct(10)}
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	expectBranchActive(mock, defaultBranchID)
+	// Primer intento: deadlock al insertar; se repite la transacción completa
+	mock.ExpectBegin()
+	expectPrepareOrder(mock, line)
+	mock.ExpectExec(sqlText(`INSERT INTO orders(`)).WillReturnError(errDeadlock)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectPrepareOrder(mock, line)
+	expectInsertOrder(mock, 50, testAdmin.ID, line)
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/api/v1/orders", `{"customer_id":3,"address_id":20,"items":[{"product_id":7,"qty":2}]}`, h)
+	expectStatus(t, w, http.StatusCreated)
+	if got := decode(t, w)["order_id"]; got != float64(50) {
+		t.Errorf("order_id = %v", got)
+	}
+}
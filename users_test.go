@@ -0,0 +1,442 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidNumDoc(t *testing.T) {
+	cases := []struct {
+		doc  string
+		want bool
+	}{
+		{"12345678", true},
+		{"1234567890", true},
+		{"1234567", false},
+		{"12345678901", false},
+		{"1234567A", false},
+	}
+	for _, tc := range cases {
+		if got := validNumDoc(tc.doc); got != tc.want {
+			t.Errorf("validNumDoc(%q) = %v, quiero %v", tc.doc, got, tc.want)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		name, password string
+		failed         []string
+	}{
+		{"válida", "agua2024", nil},
+		{"corta", "ab1", []string{"mínimo 8 caracteres"}},
+		{"sin número", "aguapura", []string{"debe incluir un número"}},
+		{"sin letra", "12345678", []string{"debe incluir una letra"}},
+		{"vacía", "", []string{"mínimo 8 caracteres", "debe incluir un número", "debe incluir una letra"}},
+		{"cuenta runas, no bytes", "ñandú1ñ", []string{"mínimo 8 caracteres"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := validatePassword(tc.password); !slices.Equal(got, tc.failed) {
+				t.Errorf("validatePassword(%q) = %q, quiero %q", tc.password, got, tc.failed)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordConfigurable(t *testing.T) {
+	setVar(t, &passwordMinLen, 4)
+	setVar(t, &passwordRequireDigit, false)
+	if failed := validatePassword("agua"); len(failed) != 0 {
+		t.Errorf("validatePassword = %q, quiero válida", failed)
+	}
+}
+
+func TestCreateUserRejectsWeakPassword(t *testing.T) {
+	newMock(t)
+	w := serve(http.MethodPost, "/api/v1/users", `{"role_id":3,"full_name":"Ana","password":"corta"}`, nil)
+	expectStatus(t, w, http.StatusUnprocessableEntity)
+	body := decode(t, w)
+	if body["field"] != "password" || body["rules"] == nil {
+		t.Errorf("cuerpo = %v", body)
+	}
+}
+
+func TestCreateUserSyntacticVsSemanticErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  string
+		want  int
+		field string
+	}{
+		{"json mal formado", `{"role_id":3,`, http.StatusBadRequest, ""},
+		{"tipo incorrecto", `{"full_name":[]}`, http.StatusBadRequest, ""},
+		{"role_id no numérico", `{"role_id":"cliente","full_name":"Ana"}`, http.StatusUnprocessableEntity, "role_id"},
+		{"falta full_name", `{"role_id":3,"password":"secreta123"}`, http.StatusUnprocessableEntity, "full_name"},
+		{"rol inexistente", `{"role_id":9,"full_name":"Ana","password":"secreta123"}`, http.StatusUnprocessableEntity, "role_id"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			w := serve(http.MethodPost, "/api/v1/users", tc.body, authAs(t, mock, testAdmin))
+			expectStatus(t, w, tc.want)
+			if got, _ := decode(t, w)["field"].(string); got != tc.field {
+				t.Errorf("field = %q, quiero %q", got, tc.field)
+			}
+		})
+	}
+}
+
+func TestRoleIDValidation(t *testing.T) {
+	for _, r := range []int8{roleAdmin, roleDriver, roleCustomer} {
+		if !validRole(r) {
+			t.Errorf("validRole(%d) = false", r)
+		}
+	}
+	for _, r := range []int8{0, 4, -1} {
+		if validRole(r) {
+			t.Errorf("validRole(%d) = true", r)
+		}
+	}
+
+	cases := []struct {
+		name, method, path, body string
+	}{
+		{"alta con rol decimal", http.MethodPost, "/api/v1/users", `{"role_id":2.5,"full_name":"Ana","password":"secreta123"}`},
+		{"alta con rol booleano", http.MethodPost, "/api/v1/users", `{"role_id":true,"full_name":"Ana","password":"secreta123"}`},
+		{"edición con rol inexistente", http.MethodPut, "/api/v1/users/3", `{"role_id":7,"full_name":"Ana"}`},
+		{"edición con rol en texto", http.MethodPut, "/api/v1/users/3", `{"role_id":"2","full_name":"Ana"}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMock(t)
+			w := serve(tc.method, tc.path, tc.body, authAs(t, mock, testAdmin))
+			expectStatus(t, w, http.StatusUnprocessableEntity)
+			if got := decode(t, w)["field"]; got != "role_id" {
+				t.Errorf("field = %v", got)
+			}
+		})
+	}
+	t.Run("carga masiva con rol en texto", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPost, "/api/v1/users/bulk", `[{"role_id":"3","full_name":"Ana","password":"secreta123"}]`, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestCreateUserNumDoc(t *testing.T) {
+	t.Run("formato inválido", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodPost, "/api/v1/users", `{"role_id":3,"full_name":"Ana","password":"secreta123","num_doc":"12AB"}`, nil)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if got := decode(t, w)["field"]; got != "num_doc" {
+			t.Errorf("field = %v", got)
+		}
+	})
+	t.Run("duplicado", func(t *testing.T) {
+		mock := newMock(t)
+		mock.ExpectQuery(sqlText(`SELECT COUNT(1) FROM users WHERE num_doc=?`)).WithArgs("12345678", "", "").
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+		w := serve(http.MethodPost, "/api/v1/users", `{"role_id":3,"full_name":"Ana","password":"secreta123","num_doc":" 12345678 "}`, nil)
+		expectStatus(t, w, http.StatusConflict)
+	})
+}
+
+func TestUpdateUserKeepsAnActiveAdmin(t *testing.T) {
+	expectAdminLock := func(mock sqlmock.Sqlmock, others int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT role_id, is_active FROM users WHERE id=? FOR UPDATE`)).WithArgs("1").
+			WillReturnRows(sqlmock.NewRows([]string{"role_id", "is_active"}).AddRow(roleAdmin, true))
+		mock.ExpectQuery(sqlText(`FROM users WHERE role_id=? AND is_active=TRUE AND id<>? FOR UPDATE`)).WithArgs(roleAdmin, "1").
+			WillReturnRows(countRows(others))
+	}
+	const demote = `{"role_id":3,"full_name":"Admin"}`
+
+	t.Run("último admin", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectAdminLock(mock, 0)
+		mock.ExpectRollback()
+		w := serve(http.MethodPut, "/api/v1/users/1", demote, h)
+		expectStatus(t, w, http.StatusConflict)
+	})
+	t.Run("quedan otros admins", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectAdminLock(mock, 1)
+		mock.ExpectExec(sqlText(`UPDATE users SET role_id=?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		w := serve(http.MethodPut, "/api/v1/users/1", demote, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+}
+
+func TestDeactivateCustomerWithOpenOrders(t *testing.T) {
+	const deactivate = `{"role_id":3,"full_name":"Cliente","is_active":false}`
+	expectOpenOrders := func(mock sqlmock.Sqlmock, n int) {
+		mock.ExpectBegin()
+		// ensureAdminRemains y openOrdersOnDeactivation leen la misma fila
+		for range 2 {
+			mock.ExpectQuery(sqlText(`SELECT role_id, is_active FROM users WHERE id=? FOR UPDATE`)).WithArgs("3").
+				WillReturnRows(sqlmock.NewRows([]string{"role_id", "is_active"}).AddRow(roleCustomer, true))
+		}
+		mock.ExpectQuery(sqlText(`SELECT COUNT(*) FROM orders WHERE customer_id=? AND status NOT IN (` + closedStatusesSQL + `)`)).WithArgs("3").
+			WillReturnRows(countRows(n))
+	}
+
+	t.Run("con pedidos en curso", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectOpenOrders(mock, 2)
+		mock.ExpectRollback()
+		w := serve(http.MethodPut, "/api/v1/users/3", deactivate, h)
+		expectStatus(t, w, http.StatusConflict)
+		if got := decode(t, w)["active_orders"]; got != float64(2) {
+			t.Errorf("active_orders = %v", got)
+		}
+	})
+	t.Run("force desactiva igual", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectOpenOrders(mock, 2)
+		mock.ExpectExec(sqlText(`UPDATE users SET role_id=?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		w := serve(http.MethodPut, "/api/v1/users/3?force=true", deactivate, h)
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["active_orders"]; got != float64(2) {
+			t.Errorf("active_orders = %v", got)
+		}
+	})
+	t.Run("sin pedidos en curso", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectOpenOrders(mock, 0)
+		mock.ExpectExec(sqlText(`UPDATE users SET role_id=?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		w := serve(http.MethodPut, "/api/v1/users/3", deactivate, h)
+		expectStatus(t, w, http.StatusOK)
+		if _, ok := decode(t, w)["active_orders"]; ok {
+			t.Error("active_orders sin pedidos en curso")
+		}
+	})
+	t.Run("force inválido", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPut, "/api/v1/users/3?force=si", deactivate, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestCreateUserRole(t *testing.T) {
+	const admin = `{"role_id":1,"full_name":"Ana","password":"secreta123"}`
+	expectInsert := func(mock sqlmock.Sqlmock, role int8) {
+		mock.ExpectExec(sqlText(`INSERT INTO users`)).
+			WithArgs(role, "Ana", nil, nil, nil, sqlmock.AnyArg(), nil).
+			WillReturnResult(sqlmock.NewResult(20, 1))
+	}
+
+	t.Run("registro público es cliente", func(t *testing.T) {
+		mock := newMock(t)
+		expectInsert(mock, roleCustomer)
+		w := serve(http.MethodPost, "/api/v1/users", admin, nil)
+		expectStatus(t, w, http.StatusCreated)
+	})
+	t.Run("un cliente no elige rol", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		expectInsert(mock, roleCustomer)
+		w := serve(http.MethodPost, "/api/v1/users", admin, h)
+		expectStatus(t, w, http.StatusCreated)
+	})
+	t.Run("admin elige rol", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		expectInsert(mock, roleAdmin)
+		w := serve(http.MethodPost, "/api/v1/users", admin, h)
+		expectStatus(t, w, http.StatusCreated)
+	})
+}
+
+func TestUpdateUserPermissions(t *testing.T) {
+	const self = `{"role_id":3,"full_name":"Cliente nuevo"}`
+
+	t.Run("requiere autenticación", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodPut, "/api/v1/users/3", self, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+	t.Run("otro usuario", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPut, "/api/v1/users/3", self, authAs(t, mock, otherUser))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("id no numérico", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPut, "/api/v1/users/abc", self, authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+	t.Run("el propio usuario", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlText(`SELECT role_id, is_active FROM users WHERE id=? FOR UPDATE`)).WithArgs("3").
+			WillReturnRows(sqlmock.NewRows([]string{"role_id", "is_active"}).AddRow(roleCustomer, true))
+		mock.ExpectExec(sqlText(`UPDATE users SET role_id=?`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		w := serve(http.MethodPut, "/api/v1/users/3", self, h)
+		expectStatus(t, w, http.StatusOK)
+	})
+	for name, body := range map[string]string{
+		"ascenderse a admin": `{"role_id":1,"full_name":"Cliente"}`,
+		"desactivarse":       `{"role_id":3,"full_name":"Cliente","is_active":false}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			mock := newMock(t)
+			w := serve(http.MethodPut, "/api/v1/users/3", body, authAs(t, mock, testCustomer))
+			expectStatus(t, w, http.StatusForbidden)
+		})
+	}
+}
+
+func TestListUsersAdminOnly(t *testing.T) {
+	newMock(t)
+	expectStatus(t, serve(http.MethodGet, "/api/v1/users", "", nil), http.StatusUnauthorized)
+	mock := newMock(t)
+	expectStatus(t, serve(http.MethodGet, "/api/v1/users", "", authAs(t, mock, testCustomer)), http.StatusForbidden)
+}
+
+func TestBulkCreateUsers(t *testing.T) {
+	const batch = `[{"role_id":3,"full_name":"Ana","password":"agua2024","email":"ana@example.com"},{"role_id":2,"full_name":"Beto","password":"agua2025"}]`
+
+	t.Run("requiere autenticación", func(t *testing.T) {
+		newMock(t)
+		w := serve(http.MethodPost, "/api/v1/users/bulk", batch, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+	t.Run("solo admin", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodPost, "/api/v1/users/bulk", batch, authAs(t, mock, testCustomer))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("todo o nada", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlText(`INSERT INTO users`)).WillReturnResult(sqlmock.NewResult(11, 1))
+		mock.ExpectExec(sqlText(`INSERT INTO users`)).WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectCommit()
+
+		w := serve(http.MethodPost, "/api/v1/users/bulk", batch, h)
+		expectStatus(t, w, http.StatusCreated)
+		if !strings.Contains(w.Body.String(), `"id":12`) {
+			t.Errorf("cuerpo = %s", w.Body.String())
+		}
+	})
+	t.Run("duplicado en el lote", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		w := serve(http.MethodPost, "/api/v1/users/bulk", `[{"role_id":3,"full_name":"Ana","password":"agua2024","email":"ana@example.com"},{"role_id":3,"full_name":"Ana bis","password":"agua2024","email":"ANA@example.com"}]`, h)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+		if !strings.Contains(w.Body.String(), "email duplicado en el lote (fila 0)") {
+			t.Errorf("cuerpo = %s", w.Body.String())
+		}
+	})
+}
+
+func TestNormalizeSearch(t *testing.T) {
+	cases := map[string]string{
+		"  José ":    "jose",
+		"ÑANDÚ":      "nandu",
+		"Agua Pura":  "agua pura",
+		"":           "",
+		"Müller 20L": "muller 20l",
+	}
+	for in, want := range cases {
+		if got := normalizeSearch(in); got != want {
+			t.Errorf("normalizeSearch(%q) = %q, quiero %q", in, got, want)
+		}
+	}
+}
+
+func TestListUsersSearchIgnoresAccents(t *testing.T) {
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`select count(*) from users where (full_name COLLATE utf8mb4_unicode_ci LIKE ?`)).
+		WithArgs("%jose%", "%jose%", "%jose%").
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectQuery(sqlText(`from users where (full_name COLLATE utf8mb4_unicode_ci LIKE ?`)).
+		WithArgs("%jose%", "%jose%", "%jose%", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := serve(http.MethodGet, "/api/v1/users?q=JOS%C3%89", "", h)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestListUsersCreatedRange(t *testing.T) {
+	lima := time.FixedZone("Lima", -5*3600)
+	setVar(t, &appLocation, lima)
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, lima)
+	to := time.Date(2026, 10, 16, 0, 0, 0, 0, lima) // fecha sola: hasta el final de ese día
+
+	mock := newMock(t)
+	h := authAs(t, mock, testAdmin)
+	mock.ExpectQuery(sqlText(`select count(*) from users where created_at>=? and created_at<?`)).
+		WithArgs(from, to).WillReturnRows(countRows(0))
+	mock.ExpectQuery(sqlText(`from users where created_at>=? and created_at<?`)).
+		WithArgs(from, to, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := serve(http.MethodGet, "/api/v1/users?created_from=2026-10-01&created_to=2026-10-15", "", h)
+	expectStatus(t, w, http.StatusOK)
+
+	for _, q := range []string{"created_from=ayer", "created_from=2026-10-15&created_to=2026-10-01"} {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/users?"+q, "", authAs(t, mock, testAdmin))
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+}
+
+func TestValidTimezone(t *testing.T) {
+	str := func(s string) *string { return &s }
+	if !validTimezone(nil) || !validTimezone(str("UTC")) {
+		t.Error("nil y UTC son válidas")
+	}
+	if validTimezone(str("Local")) || validTimezone(str("Marte/Olympus")) {
+		t.Error("Local y nombres inexistentes no son válidos")
+	}
+}
+
+func TestUserStats(t *testing.T) {
+	t.Run("el propio cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testCustomer)
+		mock.ExpectQuery(sqlText(`SELECT role_id FROM users WHERE id=?`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"role_id"}).AddRow(roleCustomer))
+		mock.ExpectQuery(sqlText(`SELECT COUNT(*), COALESCE(SUM(total), 0), MAX(created_at)`)).WithArgs(testCustomer.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"n", "revenue", "last"}).AddRow(3, 100.0, testNow))
+
+		w := serve(http.MethodGet, "/api/v1/users/3/stats", "", h)
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["delivered_count"] != float64(3) || body["avg_order_value"] != 33.33 {
+			t.Errorf("cuerpo = %v", body)
+		}
+	})
+	t.Run("otro cliente", func(t *testing.T) {
+		mock := newMock(t)
+		w := serve(http.MethodGet, "/api/v1/users/3/stats", "", authAs(t, mock, otherUser))
+		expectStatus(t, w, http.StatusForbidden)
+	})
+	t.Run("no es cliente", func(t *testing.T) {
+		mock := newMock(t)
+		h := authAs(t, mock, testAdmin)
+		mock.ExpectQuery(sqlText(`SELECT role_id FROM users WHERE id=?`)).WithArgs(testDriver.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"role_id"}).AddRow(roleDriver))
+		w := serve(http.MethodGet, "/api/v1/users/2/stats", "", h)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}